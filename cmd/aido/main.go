@@ -8,23 +8,35 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/lhdbsbz/aido/internal/agent"
 	"github.com/lhdbsbz/aido/internal/bridge"
+	"github.com/lhdbsbz/aido/internal/budget"
 	"github.com/lhdbsbz/aido/internal/config"
 	"github.com/lhdbsbz/aido/internal/gateway"
 	"github.com/lhdbsbz/aido/internal/llm"
 	"github.com/lhdbsbz/aido/internal/mcp"
 	"github.com/lhdbsbz/aido/internal/session"
 	"github.com/lhdbsbz/aido/internal/skills"
+	"github.com/lhdbsbz/aido/internal/tokenizer"
 	"github.com/lhdbsbz/aido/internal/tool"
 )
 
 const version = "0.1.0"
 
 func main() {
-	if err := serve(); err != nil {
+	run := serve
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		run = func() error { return replay(os.Args[2:]) }
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tokenizers" {
+		run = func() error { return fetchTokenizers(os.Args[2:]) }
+	}
+	if err := run(); err != nil {
 		slog.Error("fatal", "error", err)
 		os.Exit(1)
 	}
@@ -45,9 +57,11 @@ func serve() error {
 		config.TempDir(),
 		config.StoreDir(),
 		config.SkillsDir(),
+		config.TokenizersDir(),
 	} {
 		os.MkdirAll(dir, 0755)
 	}
+	tokenizer.Dir = config.TokenizersDir()
 
 	// Load config; if missing, create from embedded example (token replaced) then load
 	cfgPath := config.Path()
@@ -71,6 +85,7 @@ func serve() error {
 		}
 	}
 	config.Set(cfg)
+	tokenizer.AutoFetch = cfg.Gateway.FetchTokenizers
 
 	store := session.NewStore(config.SessionDir())
 	if err := store.Load(); err != nil {
@@ -88,12 +103,37 @@ func serve() error {
 	mcpClient := mcp.NewClient()
 	reloadMCP(context.Background(), cfg, mcpClient, registry, config.ResolveHome())
 
+	clients := llm.NewClientPool()
+	if err := checkOllamaModels(context.Background(), cfg, clients); err != nil {
+		return err
+	}
+	mock := llm.NewMockClient()
+	if err := loadMockScripts(cfg, mock); err != nil {
+		return err
+	}
+
+	usage := llm.NewUsageTracker(config.DataDir())
+	usage.SetPricing(usagePricing(cfg))
+	budgets, err := budget.New(cfg.Budgets, usage)
+	if err != nil {
+		return err
+	}
+	models := llm.NewCatalog()
+	models.SetOverrides(catalogModels(cfg))
+	models.SetPricing(usagePricing(cfg))
+	go discoverModels(cfg, clients, models)
+
 	// Initialize agent loop (all tools allowed)
 	loop := &agent.Loop{
-		OpenAI:    llm.NewOpenAIClient(),
-		Anthropic: llm.NewAnthropicClient(),
-		Tools:     registry,
-		Config:    cfg,
+		Clients: clients,
+		Mock:    mock,
+		Tools:   registry,
+		Config:  cfg,
+		Usage:   usage,
+		Budget:  budgets,
+		Models:  models,
+
+		Approvals: agent.NewApprovalManager(),
 	}
 
 	router := agent.NewRouter(loop, store)
@@ -102,6 +142,14 @@ func serve() error {
 	config.RegisterOnReload(func(cfg *config.Config) {
 		reloadMCP(context.Background(), cfg, mcpClient, registry, config.ResolveHome())
 		reloadSkills(cfg, router)
+		if err := loadMockScripts(cfg, mock); err != nil {
+			slog.Warn("reload mock scripts failed", "error", err)
+		}
+		usage.SetPricing(usagePricing(cfg))
+		budgets.SetConfig(cfg.Budgets)
+		models.SetOverrides(catalogModels(cfg))
+		models.SetPricing(usagePricing(cfg))
+		go discoverModels(cfg, clients, models)
 	})
 
 	// Start gateway with graceful shutdown
@@ -147,10 +195,70 @@ func serve() error {
 		slog.Info("bridges init", "total_in_config", len(cfg.Bridges.Instances), "started", bridgeCount)
 	}
 
-	srv := gateway.NewServer(router, bridgeMgr)
+	srv := gateway.NewServer(router, bridgeMgr, usage)
+	srv.Models = models
 	return srv.Start(ctx)
 }
 
+// usagePricing converts the configured model prices for the usage tracker.
+func usagePricing(cfg *config.Config) llm.Pricing {
+	p := make(llm.Pricing, len(cfg.Pricing))
+	for model, price := range cfg.Pricing {
+		p[model] = llm.ModelPrice{Input: price.Input, Output: price.Output, CacheRead: price.CacheRead, CacheWrite: price.CacheWrite}
+	}
+	return p
+}
+
+// catalogModels converts the config models for the model catalog.
+func catalogModels(cfg *config.Config) map[string]llm.ModelInfo {
+	m := make(map[string]llm.ModelInfo, len(cfg.Models))
+	for key, mc := range cfg.Models {
+		m[key] = llm.ModelInfo{
+			ContextWindow:   mc.ContextWindow,
+			MaxOutputTokens: mc.MaxOutputTokens,
+			Input:           mc.Input,
+			Tools:           mc.Tools,
+			Reasoning:       mc.Reasoning,
+		}
+	}
+	return m
+}
+
+// discoverModels refreshes the catalog with the model lists of providers that have discoverModels on;
+// failures are logged and leave the previous list in place.
+func discoverModels(cfg *config.Config, clients *llm.ClientPool, models *llm.Catalog) {
+	for name, prov := range cfg.Providers {
+		clientType := prov.ClientType(name)
+		if !prov.DiscoverModels || clientType == "mock" {
+			models.SetDiscovered(name, nil)
+			continue
+		}
+		opts, err := agent.TransportOptions(prov)
+		if err != nil {
+			slog.Warn("model discovery skipped", "provider", name, "error", err)
+			continue
+		}
+		client, err := clients.Get(name, clientType, opts)
+		if err != nil {
+			slog.Warn("model discovery skipped", "provider", name, "error", err)
+			continue
+		}
+		d, ok := client.(llm.ModelDiscoverer)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		list, err := d.DiscoverModels(ctx, prov.BaseURL, prov.APIKey)
+		cancel()
+		if err != nil {
+			slog.Warn("model discovery failed", "provider", name, "error", err)
+			continue
+		}
+		models.SetDiscovered(name, list)
+		slog.Info("models discovered", "provider", name, "count", len(list))
+	}
+}
+
 func reloadMCP(ctx context.Context, cfg *config.Config, mcpClient *mcp.Client, registry *tool.Registry, home string) {
 	for _, name := range mcpClient.ServerNames() {
 		mcpClient.RemoveServer(name)
@@ -235,6 +343,93 @@ func reloadBridges(ctx context.Context, cfg *config.Config, bridgeMgr *bridge.Ma
 	}
 }
 
+// loadMockScripts loads the replay script of every mock provider; relative paths are resolved against home.
+func loadMockScripts(cfg *config.Config, client *llm.MockClient) error {
+	for name, prov := range cfg.Providers {
+		if prov.ClientType(name) != "mock" {
+			continue
+		}
+		if prov.Script == "" {
+			return fmt.Errorf("mock provider %q: script is required", name)
+		}
+		path := prov.Script
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(config.Home(), path)
+		}
+		script, err := llm.LoadMockScript(path)
+		if err != nil {
+			return fmt.Errorf("mock provider %q: %w", name, err)
+		}
+		client.SetScript(name, script)
+		slog.Info("mock provider loaded", "provider", name, "script", path, "turns", len(script.Turns))
+	}
+	return nil
+}
+
+// checkOllamaModels verifies, for ollama providers with checkModels enabled, that every model an agent
+// uses (primary or fallback) is installed locally, so misconfigured agents fail at startup instead of mid-chat.
+func checkOllamaModels(ctx context.Context, cfg *config.Config, clients *llm.ClientPool) error {
+	installed := make(map[string][]llm.OllamaModel) // provider → installed models
+	for name, prov := range cfg.Providers {
+		if prov.ClientType(name) != "ollama" || !prov.CheckModels {
+			continue
+		}
+		opts, err := agent.TransportOptions(prov)
+		if err != nil {
+			return fmt.Errorf("ollama provider %q: %w", name, err)
+		}
+		c, err := clients.Get(name, "ollama", opts)
+		if err != nil {
+			return err
+		}
+		client := c.(*llm.OllamaClient)
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		models, err := client.ListModels(listCtx, prov.BaseURL)
+		if err == nil {
+			if running, psErr := client.RunningModels(listCtx, prov.BaseURL); psErr == nil {
+				loaded := make([]string, 0, len(running))
+				for _, m := range running {
+					loaded = append(loaded, m.Name)
+				}
+				slog.Info("ollama models", "provider", name, "installed", len(models), "loaded", loaded)
+			}
+		}
+		cancel()
+		if err != nil {
+			return fmt.Errorf("ollama provider %q: list models: %w", name, err)
+		}
+		installed[name] = models
+	}
+	if len(installed) == 0 {
+		return nil
+	}
+
+	var missing []string
+	for agentID, agentCfg := range cfg.Agents {
+		refs := make([][2]string, 0, 1+len(agentCfg.Fallbacks))
+		provider, model, _, err := config.ResolveProviderForAgent(cfg, &agentCfg)
+		if err == nil {
+			refs = append(refs, [2]string{provider, model})
+		}
+		for _, fb := range agentCfg.Fallbacks {
+			if p, m, _, err := config.ResolveProviderWithDefault(cfg, fb, provider); err == nil {
+				refs = append(refs, [2]string{p, m})
+			}
+		}
+		for _, ref := range refs {
+			models, checked := installed[ref[0]]
+			if checked && !llm.HasOllamaModel(models, ref[1]) {
+				missing = append(missing, fmt.Sprintf("agent %q: %s/%s (run `ollama pull %s`)", agentID, ref[0], ref[1], ref[1]))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("ollama models not installed: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
 func reloadSkills(cfg *config.Config, router *agent.Router) {
 	skillDir := config.SkillsDir()
 	for agentID := range cfg.Agents {
@@ -245,3 +440,19 @@ func reloadSkills(cfg *config.Config, router *agent.Router) {
 		}
 	}
 }
+
+// fetchTokenizers downloads the BPE vocabularies (aido tokenizers fetch), e.g. before going offline.
+func fetchTokenizers(args []string) error {
+	if len(args) != 1 || args[0] != "fetch" {
+		return fmt.Errorf("usage: aido tokenizers fetch")
+	}
+	config.ResolveHome()
+	tokenizer.Dir = config.TokenizersDir()
+	for _, name := range tokenizer.Encodings() {
+		if err := tokenizer.Fetch(context.Background(), name); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", name, filepath.Join(tokenizer.Dir, name+".tiktoken"))
+	}
+	return nil
+}
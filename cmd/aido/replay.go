@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lhdbsbz/aido/internal/agent"
+	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
+	"github.com/lhdbsbz/aido/internal/tokenizer"
+)
+
+// replay re-runs a recorded run (aido replay <runId | path/to/cassette.jsonl>) against its cassette,
+// printing the run's events, and fails if the outcome differs from the recording.
+func replay(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: aido replay <runId | cassette.jsonl>")
+	}
+	config.ResolveHome()
+	tokenizer.Dir = config.TokenizersDir()
+
+	path := args[0]
+	if !strings.HasSuffix(path, ".jsonl") {
+		path = filepath.Join(config.CassettesDir(), path+".jsonl")
+	}
+	cassette, err := llm.OpenCassette(path)
+	if err != nil {
+		return err
+	}
+
+	res, err := agent.Replay(context.Background(), cassette, printReplayEvent)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("replayed %s (agent %s, session %s): %d recorded LLM calls\n", res.Run.RunID, res.Run.AgentID, res.Run.SessionKey, res.LLMCalls)
+	if res.Err != nil {
+		fmt.Printf("replay error: %v\n", res.Err)
+	}
+	if res.Matches() {
+		fmt.Println("result matches the recording")
+		return nil
+	}
+	if res.Unused > 0 {
+		fmt.Printf("%d recorded LLM calls were not replayed\n", res.Unused)
+	}
+	if res.Recorded.Error != "" {
+		fmt.Printf("recorded error: %s\n", res.Recorded.Error)
+	} else if res.Text != res.Recorded.Text {
+		fmt.Printf("recorded reply:\n%s\n", res.Recorded.Text)
+	}
+	return fmt.Errorf("replay of %s differs from the recording", res.Run.RunID)
+}
+
+func printReplayEvent(e agent.Event) {
+	switch e.Type {
+	case agent.EventTypeTextDelta:
+		fmt.Print(e.Text)
+	case agent.EventTypeToolStart:
+		fmt.Printf("\n→ %s %s\n", e.ToolName, e.ToolParams)
+	case agent.EventTypeToolEnd:
+		fmt.Printf("← %s\n", truncate(e.ToolResult, 200))
+	case agent.EventTypeCompactStart:
+		fmt.Println("\n[compacting]")
+	case agent.EventTypeFallback, agent.EventTypeRetry, agent.EventTypeContinue:
+		fmt.Printf("\n[%s] %s\n", e.Type, e.Text)
+	case agent.EventTypeError:
+		fmt.Fprintf(os.Stderr, "\n[error] %s\n", e.Error)
+	}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "..."
+}
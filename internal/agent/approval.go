@@ -0,0 +1,318 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
+	"github.com/lhdbsbz/aido/internal/tool"
+)
+
+// DefaultApprovalTimeout is how long a tool call waits for a human decision before it is denied.
+const DefaultApprovalTimeout = 5 * time.Minute
+
+// ErrApprovalNotFound is returned when resolving an approval that is not pending (unknown, decided or expired).
+var ErrApprovalNotFound = errors.New("approval not found or already decided")
+
+// Approval actions of a rule or the default.
+const (
+	ApprovalAlways = "always" // run without asking
+	ApprovalAsk    = "ask"    // pause the run until a human approves or denies
+	ApprovalNever  = "never"  // deny without asking
+)
+
+// Approval decisions.
+const (
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalTimeout  = "timeout"
+)
+
+// Approval is a tool call waiting for, or decided by, a human.
+type Approval struct {
+	ID         string    `json:"id"`
+	RunID      string    `json:"runId"`
+	SessionKey string    `json:"sessionKey"`
+	AgentID    string    `json:"agentId"`
+	ToolName   string    `json:"toolName"`
+	ToolParams string    `json:"toolParams"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+
+	// Set once decided
+	Decision string `json:"decision,omitempty"` // "approved" | "denied" | "timeout"
+	By       string `json:"by,omitempty"`       // who decided, e.g. "feishu:ou_123"; empty for timeouts
+	Reason   string `json:"reason,omitempty"`
+}
+
+// note describes the decision for the tool result, e.g. "approved by feishu:ou_123".
+func (a *Approval) note() string {
+	var b strings.Builder
+	b.WriteString(a.Decision)
+	if a.By != "" {
+		b.WriteString(" by " + a.By)
+	}
+	if a.Reason != "" {
+		b.WriteString(": " + a.Reason)
+	}
+	return b.String()
+}
+
+// ApprovalManager holds the pending approvals of all runs; gateway methods resolve them.
+type ApprovalManager struct {
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+	seq     atomic.Int64
+}
+
+type pendingApproval struct {
+	approval Approval
+	decided  chan Approval // buffered: Resolve never blocks
+}
+
+func NewApprovalManager() *ApprovalManager {
+	return &ApprovalManager{pending: make(map[string]*pendingApproval)}
+}
+
+// Pending returns the approvals waiting for a decision, oldest first.
+func (m *ApprovalManager) Pending() []Approval {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Approval, 0, len(m.pending))
+	for _, p := range m.pending {
+		out = append(out, p.approval)
+	}
+	slices.SortFunc(out, func(a, b Approval) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return out
+}
+
+// Get returns a pending approval.
+func (m *ApprovalManager) Get(id string) (Approval, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pending[id]
+	if !ok {
+		return Approval{}, false
+	}
+	return p.approval, true
+}
+
+// Resolve approves or denies a pending tool call; the paused run continues with the decision.
+func (m *ApprovalManager) Resolve(id string, approve bool, by, reason string) error {
+	m.mu.Lock()
+	p, ok := m.pending[id]
+	delete(m.pending, id)
+	m.mu.Unlock()
+	if !ok {
+		return ErrApprovalNotFound
+	}
+	a := p.approval
+	a.Decision, a.By, a.Reason = ApprovalDenied, by, reason
+	if approve {
+		a.Decision = ApprovalApproved
+	}
+	p.decided <- a
+	return nil
+}
+
+// open registers a pending approval.
+func (m *ApprovalManager) open(a Approval) *pendingApproval {
+	a.ID = fmt.Sprintf("approval_%d_%d", a.CreatedAt.UnixMilli(), m.seq.Add(1))
+	p := &pendingApproval{approval: a, decided: make(chan Approval, 1)}
+	m.mu.Lock()
+	m.pending[a.ID] = p
+	m.mu.Unlock()
+	return p
+}
+
+// wait blocks until p is resolved, expires (decision "timeout") or ctx ends (denied, reason "run aborted").
+func (m *ApprovalManager) wait(ctx context.Context, p *pendingApproval) Approval {
+	timer := time.NewTimer(time.Until(p.approval.ExpiresAt))
+	defer timer.Stop()
+	var decision string
+	select {
+	case a := <-p.decided:
+		return a
+	case <-timer.C:
+		decision = ApprovalTimeout
+	case <-ctx.Done():
+		decision = ApprovalDenied
+	}
+	m.mu.Lock()
+	_, stillPending := m.pending[p.approval.ID]
+	delete(m.pending, p.approval.ID)
+	m.mu.Unlock()
+	if !stillPending { // resolved just now
+		return <-p.decided
+	}
+	a := p.approval
+	a.Decision = decision
+	if decision == ApprovalDenied {
+		a.Reason = "run aborted"
+	}
+	return a
+}
+
+// approvalPolicy is an agent's compiled tools.approval config.
+type approvalPolicy struct {
+	def     string
+	timeout time.Duration
+	rules   []approvalRule
+}
+
+type approvalRule struct {
+	tool   tool.Policy // allows exactly the tools the rule names
+	args   map[string]*regexp.Regexp
+	action string
+}
+
+// newApprovalPolicy compiles an agent's approval settings; it fails on unknown actions, malformed
+// tool patterns or argument regexps and invalid timeouts.
+func newApprovalPolicy(cfg config.ApprovalConfig) (*approvalPolicy, error) {
+	p := &approvalPolicy{def: cmp.Or(cfg.Default, ApprovalAlways), timeout: DefaultApprovalTimeout}
+	if err := checkApprovalAction(p.def); err != nil {
+		return nil, fmt.Errorf("tools.approval.default: %w", err)
+	}
+	if cfg.Timeout != "" {
+		d, err := parseTimeout("tools.approval.timeout", cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if d > 0 {
+			p.timeout = d
+		}
+	}
+	for i, r := range cfg.Rules {
+		if err := checkApprovalAction(r.Action); err != nil {
+			return nil, fmt.Errorf("tools.approval.rules[%d]: %w", i, err)
+		}
+		if strings.TrimSpace(r.Tool) == "" {
+			return nil, fmt.Errorf("tools.approval.rules[%d]: tool required", i)
+		}
+		names, err := tool.NewPolicy([]string{r.Tool}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("tools.approval.rules[%d]: %w", i, err)
+		}
+		rule := approvalRule{tool: names, action: r.Action, args: make(map[string]*regexp.Regexp, len(r.Args))}
+		for field, expr := range r.Args {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("tools.approval.rules[%d].args.%s: %w", i, field, err)
+			}
+			rule.args[field] = re
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+func checkApprovalAction(action string) error {
+	switch action {
+	case ApprovalAlways, ApprovalAsk, ApprovalNever:
+		return nil
+	}
+	return fmt.Errorf("invalid action %q (use always, ask or never)", action)
+}
+
+// action returns what to do with a tool call: the action of the first rule matching the tool and
+// its arguments, else the default.
+func (p *approvalPolicy) action(name, args string) string {
+	if p == nil {
+		return ApprovalAlways
+	}
+	var fields map[string]any
+	for _, r := range p.rules {
+		if !r.tool.Allows(name) {
+			continue
+		}
+		if len(r.args) > 0 && fields == nil {
+			if json.Unmarshal([]byte(args), &fields) != nil || fields == nil {
+				fields = map[string]any{}
+			}
+		}
+		if matchArgs(r.args, fields) {
+			return r.action
+		}
+	}
+	return p.def
+}
+
+// matchArgs reports whether every listed argument is present and matches its regexp; non-string
+// values are matched in their JSON form.
+func matchArgs(patterns map[string]*regexp.Regexp, fields map[string]any) bool {
+	for field, re := range patterns {
+		v, ok := fields[field]
+		if !ok {
+			return false
+		}
+		s, isString := v.(string)
+		if !isString {
+			b, _ := json.Marshal(v)
+			s = string(b)
+		}
+		if !re.MatchString(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// approve applies the approval policy to a tool call, pausing for a human decision on "ask". It returns
+// the decision to record with the result (nil when none was needed) and an error if the call must not run.
+// Calls the tool policy rejects anyway are not asked about, nor are replayed ones: their recorded
+// results carry the decision.
+func (l *Loop) approve(ctx context.Context, tc llm.ToolCall, policy *approvalPolicy, emitter *EventEmitter) (*Approval, error) {
+	action := policy.action(tc.Name, tc.Arguments)
+	if action == ApprovalAlways || !tool.PolicyFromContext(ctx).Allows(tc.Name) || replayCassette(ctx) != nil {
+		return nil, nil
+	}
+	if action == ApprovalNever {
+		a := &Approval{ToolName: tc.Name, ToolParams: tc.Arguments, Decision: ApprovalDenied, By: "policy"}
+		return a, errors.New("tool call " + a.note())
+	}
+	if l.Approvals == nil {
+		a := &Approval{ToolName: tc.Name, ToolParams: tc.Arguments, Decision: ApprovalDenied, Reason: "no approver available"}
+		return a, errors.New("tool call " + a.note())
+	}
+
+	info, _ := tool.RunInfoFromContext(ctx)
+	now := time.Now()
+	p := l.Approvals.open(Approval{
+		RunID:      info.RunID,
+		SessionKey: info.SessionKey,
+		AgentID:    info.AgentID,
+		ToolName:   tc.Name,
+		ToolParams: tc.Arguments,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(policy.timeout),
+	})
+	req := p.approval
+	emitter.Emit(EventTypeApprovalRequest, func(e *Event) {
+		e.ToolName = tc.Name
+		e.ToolParams = tc.Arguments
+		e.Approval = &req
+	})
+	a := l.Approvals.wait(ctx, p)
+	emitter.Emit(EventTypeApprovalResult, func(e *Event) {
+		e.ToolName = tc.Name
+		e.ToolParams = tc.Arguments
+		e.Approval = &a
+	})
+	if a.Decision != ApprovalApproved {
+		if a.Decision == ApprovalTimeout {
+			return &a, fmt.Errorf("tool call denied: no decision within %s", policy.timeout)
+		}
+		return &a, errors.New("tool call " + a.note())
+	}
+	return &a, nil
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+// userMessage builds the user turn from the message text and its attachments: images become image
+// blocks, PDFs become documents the client sends natively, text-like files are decoded and inlined as
+// document text, and everything else (audio, video, binary files) is noted in the text. Images and PDFs
+// the model does not accept (per the model catalog) are not sent but noted, so the model can say so.
+func userMessage(ctx context.Context, text string, attachments []Attachment, model llm.ModelInfo) llm.Message {
+	var images []llm.ImageData
+	var docs []llm.DocumentData
+	var otherParts, refused []string
+	for _, a := range attachments {
+		switch {
+		case a.Type == "image" && !model.Accepts(llm.ModalityImage):
+			refused = append(refused, attachmentNote(a))
+		case a.Type == "image":
+			images = append(images, llm.ImageData{URL: a.URL, Base64: a.Base64, MIME: a.MIME})
+		case a.Type == "file" && isPDF(a) && !model.Accepts(llm.ModalityPDF):
+			refused = append(refused, attachmentNote(a))
+		case a.Type == "file" && isPDF(a):
+			docs = append(docs, llm.DocumentData{Name: attachmentName(a), MIME: "application/pdf", URL: a.URL, Base64: a.Base64})
+		case a.Type == "file":
+			if doc, ok := textDocument(ctx, a); ok {
+				docs = append(docs, doc)
+				continue
+			}
+			otherParts = append(otherParts, attachmentNote(a))
+		case a.Type != "":
+			otherParts = append(otherParts, attachmentNote(a))
+		}
+	}
+	if len(refused) > 0 {
+		slog.Info("attachments not sent: unsupported by model", "model", model.ID, "attachments", refused)
+	}
+	for _, note := range []struct {
+		label string
+		parts []string
+	}{{"Attached", otherParts}, {"Attached, but this model cannot read it", refused}} {
+		if len(note.parts) == 0 {
+			continue
+		}
+		if text != "" {
+			text += "\n\n"
+		}
+		text += "[" + note.label + ": " + strings.Join(note.parts, "; ") + "]"
+	}
+	msg := llm.UserMessage(text)
+	if len(images) > 0 {
+		msg = llm.UserMessageWithImages(text, images)
+	}
+	msg.Documents = docs
+	return msg
+}
+
+func attachmentNote(a Attachment) string {
+	switch {
+	case a.URL != "":
+		return a.Type + ": " + a.URL
+	case a.Name != "":
+		return a.Type + ": " + a.Name
+	default:
+		return a.Type + " (inline)"
+	}
+}
+
+// attachmentName returns the file name of an attachment, falling back to the last URL path segment.
+func attachmentName(a Attachment) string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if u, err := url.Parse(a.URL); err == nil && !strings.HasPrefix(a.URL, "data:") {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			return base
+		}
+	}
+	return ""
+}
+
+func attachmentExt(a Attachment) string {
+	return strings.ToLower(path.Ext(attachmentName(a)))
+}
+
+func isPDF(a Attachment) bool {
+	if strings.EqualFold(a.MIME, "application/pdf") || attachmentExt(a) == ".pdf" {
+		return true
+	}
+	if a.Base64 != "" {
+		head, _ := base64.StdEncoding.DecodeString(a.Base64[:min(len(a.Base64), 8)])
+		return strings.HasPrefix(string(head), "%PDF-")
+	}
+	return false
+}
+
+// textExtensions are file types read as text when the MIME type does not say so.
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".csv": true, ".tsv": true, ".json": true, ".jsonl": true,
+	".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".log": true, ".html": true,
+	".htm": true, ".css": true, ".js": true, ".ts": true, ".go": true, ".py": true, ".java": true, ".c": true,
+	".h": true, ".cpp": true, ".rs": true, ".sh": true, ".sql": true,
+}
+
+func isTextMIME(m string) bool {
+	m, _, _ = mime.ParseMediaType(m)
+	switch {
+	case strings.HasPrefix(m, "text/"):
+		return true
+	case strings.HasSuffix(m, "+json"), strings.HasSuffix(m, "+xml"):
+		return true
+	}
+	switch m {
+	case "application/json", "application/xml", "application/yaml", "application/x-yaml", "application/toml",
+		"application/javascript", "application/x-sh", "application/sql":
+		return true
+	}
+	return false
+}
+
+// textDocument decodes a text-like file attachment (by MIME type, extension, or content) into a text
+// document, truncated at llm.MaxDocumentTextBytes. ok is false for binary files and files that cannot
+// be loaded.
+func textDocument(ctx context.Context, a Attachment) (llm.DocumentData, bool) {
+	declared := isTextMIME(a.MIME) || textExtensions[attachmentExt(a)]
+	if !declared && a.MIME != "" && a.MIME != "application/octet-stream" {
+		return llm.DocumentData{}, false
+	}
+	var data []byte
+	mimeType := a.MIME
+	if a.Base64 != "" {
+		b, err := base64.StdEncoding.DecodeString(a.Base64)
+		if err != nil {
+			return llm.DocumentData{}, false
+		}
+		data = b
+	} else {
+		b, fetched, err := llm.FetchMedia(ctx, a.URL, llm.MaxMediaBytes)
+		if err != nil {
+			slog.Warn("file attachment could not be fetched", "name", attachmentName(a), "error", err)
+			return llm.DocumentData{}, false
+		}
+		data = b
+		if mimeType == "" {
+			mimeType = fetched
+		}
+	}
+	if !declared && !isTextMIME(mimeType) && !looksLikeText(data) {
+		return llm.DocumentData{}, false
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	doc := llm.DocumentData{Name: attachmentName(a), MIME: mimeType}
+	if len(data) > llm.MaxDocumentTextBytes {
+		cut := llm.MaxDocumentTextBytes
+		for cut > 0 && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+		data = data[:cut]
+		doc.Truncated = true
+	}
+	doc.Text = string(data)
+	return doc, true
+}
+
+// looksLikeText reports whether data is valid UTF-8 without NUL bytes.
+func looksLikeText(data []byte) bool {
+	sample := data
+	if len(sample) > 8<<10 {
+		sample = sample[:8<<10]
+		// the sample may end inside a multi-byte rune
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+	return utf8.Valid(sample) && !strings.ContainsRune(string(sample), 0)
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+func TestUserMessageAttachments(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	long := strings.Repeat("é", llm.MaxDocumentTextBytes/2+10)
+
+	msg := userMessage(context.Background(), "see attached", []Attachment{
+		{Type: "image", URL: "https://example.com/a.png"},
+		{Type: "file", Name: "report.pdf", Base64: b64("%PDF-1.7 ...")},
+		{Type: "file", Base64: b64("%PDF-1.4 unnamed")},
+		{Type: "file", Name: "notes.md", Base64: b64("# Notes")},
+		{Type: "file", Name: "data", MIME: "application/json", Base64: b64(`{"a":1}`)},
+		{Type: "file", Name: "big.txt", MIME: "text/plain", Base64: b64(long)},
+		{Type: "file", Name: "blob.bin", Base64: b64("\x00\x01\x02")},
+		{Type: "audio", URL: "https://example.com/a.mp3"},
+	}, llm.ModelInfo{})
+
+	if len(msg.Images) != 1 || msg.Images[0].URL != "https://example.com/a.png" {
+		t.Errorf("images = %+v", msg.Images)
+	}
+	if len(msg.Documents) != 5 {
+		t.Fatalf("documents = %+v", msg.Documents)
+	}
+	if d := msg.Documents[0]; !d.IsPDF() || d.Name != "report.pdf" || d.Base64 == "" {
+		t.Errorf("pdf = %+v", d)
+	}
+	if d := msg.Documents[1]; !d.IsPDF() {
+		t.Errorf("sniffed pdf = %+v", d)
+	}
+	if d := msg.Documents[2]; d.Text != "# Notes" || d.Name != "notes.md" {
+		t.Errorf("markdown = %+v", d)
+	}
+	if d := msg.Documents[3]; d.Text != `{"a":1}` {
+		t.Errorf("json = %+v", d)
+	}
+	big := msg.Documents[4]
+	if !big.Truncated || len(big.Text) > llm.MaxDocumentTextBytes || !strings.HasSuffix(big.Text, "é") {
+		t.Errorf("truncated text: truncated=%v len=%d", big.Truncated, len(big.Text))
+	}
+	if msg.Content != "see attached\n\n[Attached: file: blob.bin; audio: https://example.com/a.mp3]" {
+		t.Errorf("content = %q", msg.Content)
+	}
+
+	// A text-only model gets notes instead of images and PDFs
+	textOnly := llm.ModelInfo{ID: "deepseek-chat", Input: []string{llm.ModalityText}}
+	msg = userMessage(context.Background(), "", []Attachment{
+		{Type: "image", URL: "https://example.com/a.png"},
+		{Type: "file", Name: "report.pdf", Base64: b64("%PDF-1.7 ...")},
+		{Type: "file", Name: "notes.md", Base64: b64("# Notes")},
+	}, textOnly)
+	if len(msg.Images) != 0 || len(msg.Documents) != 1 {
+		t.Errorf("text-only: images = %+v, documents = %+v", msg.Images, msg.Documents)
+	}
+	if msg.Content != "[Attached, but this model cannot read it: image: https://example.com/a.png; file: report.pdf]" {
+		t.Errorf("text-only content = %q", msg.Content)
+	}
+}
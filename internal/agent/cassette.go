@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
+	"github.com/lhdbsbz/aido/internal/session"
+	"github.com/lhdbsbz/aido/internal/tool"
+)
+
+// Record kinds the loop adds to a run's cassette next to the "llm" exchanges.
+const (
+	cassetteKindRun    = "run"
+	cassetteKindTool   = "tool"
+	cassetteKindResult = "result"
+)
+
+// RunRecord is the first record of a cassette: the input Loop.Run needs to repeat the run.
+type RunRecord struct {
+	RunID          string                           `json:"runId"`
+	SessionKey     string                           `json:"sessionKey"`
+	AgentID        string                           `json:"agentId"`
+	Locale         string                           `json:"locale,omitempty"`
+	Agent          config.AgentConfig               `json:"agent"`
+	Providers      map[string]config.ProviderConfig `json:"providers"` // API keys removed
+	SystemPrompt   string                           `json:"systemPrompt"`
+	UserMessage    string                           `json:"userMessage"`
+	Attachments    []Attachment                     `json:"attachments,omitempty"`
+	History        []llm.Message                    `json:"history"` // transcript before the run
+	Tools          []llm.ToolDef                    `json:"tools"`
+	ContinuePrompt string                           `json:"continuePrompt,omitempty"`
+	ResponseSchema *llm.ResponseSchema              `json:"responseSchema,omitempty"`
+
+	ToolChoice        llm.ToolChoice `json:"toolChoice,omitempty"`
+	ParallelToolCalls *bool          `json:"parallelToolCalls,omitempty"`
+}
+
+// ToolRecord is one tool execution of a recorded run.
+type ToolRecord struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ResultRecord is the outcome of a recorded run.
+type ResultRecord struct {
+	Text  string `json:"text"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReplayResult compares a replayed run with its recording.
+type ReplayResult struct {
+	Run      RunRecord
+	Text     string
+	Err      error
+	Recorded ResultRecord
+	LLMCalls int // exchanges in the recording
+	Unused   int // recorded exchanges the replay did not ask for
+}
+
+// Matches reports whether the replay ended like the recorded run.
+func (r *ReplayResult) Matches() bool {
+	errText := ""
+	if r.Err != nil {
+		errText = r.Err.Error()
+	}
+	return r.Text == r.Recorded.Text && errText == r.Recorded.Error && r.Unused == 0
+}
+
+type (
+	cassetteKey struct{}
+	replayKey   struct{}
+)
+
+// runCassette returns the cassette the current run records into, nil if recording is off.
+func runCassette(ctx context.Context) *llm.Cassette {
+	c, _ := ctx.Value(cassetteKey{}).(*llm.Cassette)
+	return c
+}
+
+// replayCassette returns the cassette the current run is replayed from, nil for live runs.
+func replayCassette(ctx context.Context) *llm.Cassette {
+	c, _ := ctx.Value(replayKey{}).(*llm.Cassette)
+	return c
+}
+
+// startRecording creates the run's cassette under CassettesDir when debug.recordRuns is on.
+func startRecording(ctx context.Context, runID string) (context.Context, *llm.Cassette) {
+	cfg := config.Get()
+	if cfg == nil || !cfg.Debug.RecordRuns {
+		return ctx, nil
+	}
+	c, err := llm.CreateCassette(filepath.Join(config.CassettesDir(), runID+".jsonl"))
+	if err != nil {
+		slog.Warn("run recording disabled", "runId", runID, "error", err)
+		return ctx, nil
+	}
+	return context.WithValue(ctx, cassetteKey{}, c), c
+}
+
+// finishRecording writes the run outcome and closes the cassette.
+func finishRecording(c *llm.Cassette, text string, runErr error) {
+	res := ResultRecord{Text: text}
+	if runErr != nil {
+		res.Error = runErr.Error()
+	}
+	if err := c.AppendData(cassetteKindResult, res); err != nil {
+		slog.Warn("cassette write failed", "path", c.Path(), "error", err)
+	}
+	c.Close()
+}
+
+// recordRun writes the run input as the first record of the cassette, if recording.
+func recordRun(ctx context.Context, rec RunRecord) {
+	c := runCassette(ctx)
+	if c == nil {
+		return
+	}
+	if cfg := config.Get(); cfg != nil {
+		rec.Locale = cfg.Gateway.Locale
+		rec.Providers = make(map[string]config.ProviderConfig, len(cfg.Providers))
+		for name, p := range cfg.Providers {
+			p.APIKey = ""
+			if len(p.Headers) > 0 { // custom headers often carry tokens: keep only their names
+				headers := make(map[string]string, len(p.Headers))
+				for k := range p.Headers {
+					headers[k] = ""
+				}
+				p.Headers = headers
+			}
+			p.Proxy = withoutUserinfo(p.Proxy)
+			if p.Azure.EntraID != nil {
+				entra := *p.Azure.EntraID
+				entra.ClientSecret = ""
+				p.Azure.EntraID = &entra
+			}
+			rec.Providers[name] = p
+		}
+	}
+	if err := c.AppendData(cassetteKindRun, rec); err != nil {
+		slog.Warn("cassette write failed", "path", c.Path(), "error", err)
+	}
+}
+
+// withoutUserinfo drops the credentials from a proxy URL; an unparsable one is dropped entirely.
+func withoutUserinfo(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// recordTool writes one tool execution, if recording.
+func recordTool(ctx context.Context, name, args, result string, toolErr error) {
+	c := runCassette(ctx)
+	if c == nil {
+		return
+	}
+	rec := ToolRecord{Name: name, Arguments: args, Result: result}
+	if toolErr != nil {
+		rec.Error = toolErr.Error()
+	}
+	if err := c.AppendData(cassetteKindTool, rec); err != nil {
+		slog.Warn("cassette write failed", "path", c.Path(), "error", err)
+	}
+}
+
+// ReadRunRecord returns the recorded run input of a cassette.
+func ReadRunRecord(c *llm.Cassette) (RunRecord, error) {
+	var run RunRecord
+	if err := c.NextData(cassetteKindRun, &run); err != nil {
+		return run, fmt.Errorf("read run record: %w", err)
+	}
+	return run, nil
+}
+
+// Replay re-runs a recorded run against its cassette. Provider responses and tool results are served
+// from the recording, so nothing is sent or executed and the run takes the same path as the original.
+// The run uses a throwaway session seeded with the recorded history; it replaces the global config with
+// the recorded agent and providers.
+func Replay(ctx context.Context, c *llm.Cassette, sink EventSink) (*ReplayResult, error) {
+	run, err := ReadRunRecord(c)
+	if err != nil {
+		return nil, err
+	}
+	var recorded ResultRecord
+	if err := c.NextData(cassetteKindResult, &recorded); err != nil && !errors.Is(err, llm.ErrCassetteExhausted) {
+		return nil, fmt.Errorf("read result record: %w", err)
+	}
+
+	cfg := &config.Config{
+		Gateway:   config.GatewayConfig{Locale: run.Locale},
+		Agents:    map[string]config.AgentConfig{run.AgentID: run.Agent},
+		Providers: run.Providers,
+	}
+	config.Set(cfg)
+
+	dir, err := os.MkdirTemp("", "aido-replay-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	store := session.NewStore(dir)
+	store.GetOrCreate(run.SessionKey, run.AgentID)
+	mgr := session.NewManager(store, newCompactor(cfg, &run.Agent), run.SessionKey, run.AgentID)
+	for _, msg := range run.History {
+		if err := mgr.Append(msg); err != nil {
+			return nil, fmt.Errorf("seed history: %w", err)
+		}
+	}
+
+	registry := tool.NewRegistry()
+	for _, def := range run.Tools {
+		registry.Register(&replayTool{def: def})
+	}
+	loop := &Loop{Tools: registry}
+
+	text, runErr := loop.Run(context.WithValue(ctx, replayKey{}, c), RunParams{
+		SessionMgr:     mgr,
+		AgentID:        run.AgentID,
+		AgentConfig:    &run.Agent,
+		SystemPrompt:   run.SystemPrompt,
+		UserMessage:    run.UserMessage,
+		Attachments:    run.Attachments,
+		EventSink:      sink,
+		ContinuePrompt: run.ContinuePrompt,
+		ResponseSchema: run.ResponseSchema,
+
+		ToolChoice:        run.ToolChoice,
+		ParallelToolCalls: run.ParallelToolCalls,
+	})
+	res := &ReplayResult{Run: run, Text: text, Err: runErr, Recorded: recorded, LLMCalls: c.Count(llm.CassetteKindLLM)}
+	for {
+		if _, err := c.Next(llm.CassetteKindLLM); err != nil {
+			break
+		}
+		res.Unused++
+	}
+	return res, nil
+}
+
+// replayTool stands in for a recorded tool definition; during a replay executeTool serves the
+// recorded results and never calls Execute.
+type replayTool struct {
+	def llm.ToolDef
+}
+
+func (t *replayTool) Name() string                { return t.def.Name }
+func (t *replayTool) Description() string         { return t.def.Description }
+func (t *replayTool) Parameters() json.RawMessage { return t.def.Parameters }
+
+func (t *replayTool) Execute(context.Context, json.RawMessage) (string, error) {
+	return "", fmt.Errorf("tool %s is not executed during replay", t.def.Name)
+}
+
+// replayToolResult returns the next recorded tool result, checking that the run asks for the same tool.
+func replayToolResult(c *llm.Cassette, name string) (string, error) {
+	var rec ToolRecord
+	if err := c.NextData(cassetteKindTool, &rec); err != nil {
+		return "", err
+	}
+	if rec.Name != name {
+		return "", fmt.Errorf("%w: run called tool %s, recording has %s", llm.ErrReplayDiverged, name, rec.Name)
+	}
+	if rec.Error != "" {
+		return rec.Result, errors.New(rec.Error)
+	}
+	return rec.Result, nil
+}
@@ -1,18 +1,38 @@
 package agent
 
-import "time"
+import (
+	"sync"
+	"time"
+
+	"github.com/lhdbsbz/aido/internal/budget"
+)
+
+// ErrorKindBudget is the error event's ErrorKind when a run is refused by a budget limit.
+const ErrorKindBudget = "budget_exceeded"
+
+// ErrorKindStreamStalled is the ErrorKind of an error, retry or fallback event caused by a provider
+// stream that stopped sending data (llm.ErrStreamStalled).
+const ErrorKindStreamStalled = "stream_stalled"
 
 // EventType constants
 const (
-	EventTypeStreamStart  = "stream_start"
-	EventTypeTextDelta    = "text_delta"
-	EventTypeToolStart    = "tool_start"
-	EventTypeToolEnd      = "tool_end"
-	EventTypeAssistant    = "assistant"
-	EventTypeCompactStart = "compact_start"
-	EventTypeCompactEnd   = "compact_end"
-	EventTypeError        = "error"
-	EventTypeDone         = "done"
+	EventTypeStreamStart     = "stream_start"
+	EventTypeTextDelta       = "text_delta"
+	EventTypeThinkingDelta   = "thinking_delta"
+	EventTypeReasoningDelta  = "reasoning_delta" // reasoning text of OpenAI-compatible reasoners (reasoning_content)
+	EventTypeToolStart       = "tool_start"
+	EventTypeToolEnd         = "tool_end"
+	EventTypeApprovalRequest = "approval_request" // a tool call waits for a human decision (tools.approval)
+	EventTypeApprovalResult  = "approval_result"  // the decision: approved, denied or timeout
+	EventTypeAssistant       = "assistant"
+	EventTypeCompactStart    = "compact_start"
+	EventTypeCompactEnd      = "compact_end"
+	EventTypeRetry           = "retry"
+	EventTypeFallback        = "fallback"
+	EventTypeContinue        = "continue"
+	EventTypeBudgetWarning   = "budget_warning"
+	EventTypeError           = "error"
+	EventTypeDone            = "done"
 )
 
 // ToolStep represents one tool invocation (for API response and history).
@@ -31,7 +51,7 @@ type Event struct {
 	Seq        int       `json:"seq"`
 	Timestamp  time.Time `json:"timestamp"`
 
-	// For text_delta
+	// For text_delta / thinking_delta / reasoning_delta
 	Text string `json:"text,omitempty"`
 
 	// For tool_start / tool_end
@@ -39,24 +59,47 @@ type Event struct {
 	ToolParams string `json:"toolParams,omitempty"`
 	ToolResult string `json:"toolResult,omitempty"`
 
-	// For error
-	Error string `json:"error,omitempty"`
+	// For approval_request / approval_result (ToolName / ToolParams are set too)
+	Approval *Approval `json:"approval,omitempty"`
+
+	// For error; for retry / fallback, the error that triggered it
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"errorKind,omitempty"` // llm.ErrorKind of a provider error, e.g. "context_length", "rate_limit"
+
+	// For retry; for continue, Attempt/MaxAttempts count continuations
+	Attempt      int   `json:"attempt,omitempty"`
+	MaxAttempts  int   `json:"maxAttempts,omitempty"`
+	RetryDelayMs int64 `json:"retryDelayMs,omitempty"`
 
 	// For done
 	TotalTokensIn  int `json:"totalTokensIn,omitempty"`
 	TotalTokensOut int `json:"totalTokensOut,omitempty"`
 	Iterations     int `json:"iterations,omitempty"`
+
+	// For done: prompt cache hits (read) and writes (creation), not included in TotalTokensIn
+	CacheReadTokens     int `json:"cacheReadTokens,omitempty"`
+	CacheCreationTokens int `json:"cacheCreationTokens,omitempty"`
+
+	// For budget_warning: the limit nearly used up
+	Budget *budget.Limit `json:"budget,omitempty"`
+
+	// For done / fallback: "provider/model" that answered (done) or is being switched to (fallback)
+	Model    string `json:"model,omitempty"`
+	Fallback bool   `json:"fallback,omitempty"` // done: at least one call in this run used a fallback model
 }
 
 // EventSink receives events from the agent loop.
 type EventSink func(Event)
 
 // EventEmitter provides sequential event emission for a single run.
+// It is safe for concurrent use: tool calls running in parallel emit approval events.
 type EventEmitter struct {
 	runID      string
 	sessionKey string
 	sink       EventSink
-	seq        int
+
+	mu  sync.Mutex
+	seq int
 }
 
 func NewEventEmitter(runID, sessionKey string, sink EventSink) *EventEmitter {
@@ -71,6 +114,8 @@ func (e *EventEmitter) Emit(eventType string, mutators ...func(*Event)) {
 	if e.sink == nil {
 		return
 	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.seq++
 	evt := Event{
 		Type:       eventType,
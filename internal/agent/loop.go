@@ -5,11 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/lhdbsbz/aido/internal/budget"
 	"github.com/lhdbsbz/aido/internal/config"
 	"github.com/lhdbsbz/aido/internal/llm"
+	"github.com/lhdbsbz/aido/internal/prompts"
 	"github.com/lhdbsbz/aido/internal/session"
 	"github.com/lhdbsbz/aido/internal/tool"
 )
@@ -17,56 +22,81 @@ import (
 var (
 	ErrMaxIterations = errors.New("max tool call iterations reached")
 	ErrAborted       = errors.New("agent run aborted")
+	ErrMaxTokens     = errors.New("reply truncated at max output tokens")
+	ErrContextFull   = errors.New("conversation exceeds the model's context window")
 )
 
 const (
-	DefaultMaxIterations = 50
-	DefaultContextWindow = 200_000
+	DefaultMaxIterations    = 50
+	DefaultContextWindow    = 200_000
+	DefaultMaxContinuations = 3 // auto-continuations of one reply cut off at max tokens
+	DefaultMaxOverflows     = 2 // forced compactions per run after the provider rejects the prompt as too long
+	DefaultToolConcurrency  = 4 // tool calls of one assistant message run at once
 )
 
 // Loop is the core agent execution engine.
 type Loop struct {
-	OpenAI    *llm.OpenAIClient
-	Anthropic *llm.AnthropicClient
-	Tools     *tool.Registry
-	Config    *config.Config
+	Clients *llm.ClientPool // one client per HTTP provider, built from its transport options; created on first use
+	Mock    *llm.MockClient // shared by all mock providers
+	Tools   *tool.Registry
+	Config  *config.Config
+	Usage   *llm.UsageTracker // optional: records the token usage of every LLM call
+	Budget  *budget.Manager   // optional: token and cost limits checked before every LLM call
+	Models  *llm.Catalog      // optional: model limits and capabilities (context window, modalities, tools)
+
+	Approvals *ApprovalManager // optional: pending human approvals of tool calls; without it "ask" calls are denied
+
+	clientsOnce sync.Once
 
 	MaxIterations int
 	ContextWindow int
 }
 
 // RunParams holds parameters for a single agent run.
-// Attachments are converted to LLM content in one place: image -> image blocks; others noted in text.
+// Attachments are converted to LLM content in one place (see userMessage): images and PDFs are sent
+// natively, text files inlined, others noted in text.
 type RunParams struct {
 	SessionMgr   *session.Manager
-	AgentID      string             // resolved agent id (e.g. "default")
+	AgentID      string // resolved agent id (e.g. "default")
 	AgentConfig  *config.AgentConfig
 	SystemPrompt string
 	UserMessage  string
 	Attachments  []Attachment
 	EventSink    EventSink
 	ToolSteps    *[]ToolStep // optional: collect tool steps for API response
+
+	ContinuePrompt string              // user turn sent to resume a reply cut off at max tokens; empty uses the zh default
+	ResponseSchema *llm.ResponseSchema // optional: structured output for the final answer
+
+	ToolChoice        llm.ToolChoice // optional: overrides AgentConfig.Tools.Choice
+	ParallelToolCalls *bool          // optional: overrides AgentConfig.Tools.Parallel
 }
 
 // Run executes one complete agent turn: LLM call → tool calls → ... → final response.
+// With debug.recordRuns on, the run's provider traffic and tool results are recorded to a cassette.
 func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
+	runID := fmt.Sprintf("run_%d", time.Now().UnixMilli())
+	ctx, cassette := startRecording(ctx, runID)
+	if l.Budget != nil {
+		defer l.Budget.EndRun(runID)
+	}
+	text, err := l.run(ctx, runID, params)
+	if cassette != nil {
+		finishRecording(cassette, text, err)
+	}
+	return text, err
+}
+
+func (l *Loop) run(ctx context.Context, runID string, params RunParams) (string, error) {
 	maxIter := l.MaxIterations
 	if maxIter <= 0 {
 		maxIter = DefaultMaxIterations
 	}
-	contextWindow := params.AgentConfig.Compaction.ContextWindow
-	if contextWindow <= 0 {
-		contextWindow = l.ContextWindow
-	}
-	if contextWindow <= 0 {
-		contextWindow = DefaultContextWindow
-	}
-
-	runID := fmt.Sprintf("run_%d", time.Now().UnixMilli())
 	emitter := NewEventEmitter(runID, params.SessionMgr.SessionKey(), params.EventSink)
 
 	workspace := config.Workspace()
 	ctx = tool.WithRunInfo(ctx, tool.RunInfo{
+		RunID:      runID,
 		SessionKey: params.SessionMgr.SessionKey(),
 		AgentID:    params.AgentID,
 		Model:      params.AgentConfig.Model,
@@ -78,47 +108,55 @@ func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("load transcript: %w", err)
 	}
+	history := messages
 
-	// Convert attachments to LLM content: image -> ImageData; other types noted in text
-	var images []llm.ImageData
-	var otherParts []string
-	for _, a := range params.Attachments {
-		if a.Type == "image" {
-			images = append(images, llm.ImageData{URL: a.URL, Base64: a.Base64, MIME: a.MIME})
-		} else if a.Type != "" {
-			if a.URL != "" {
-				otherParts = append(otherParts, a.Type+": "+a.URL)
-			} else {
-				otherParts = append(otherParts, a.Type+" (inline)")
-			}
-		}
+	// Resolve provider and model from agent config (agent.Provider + agent.Model)
+	provider, model, provCfg, err := config.ResolveProviderForAgent(config.Get(), params.AgentConfig)
+	if err != nil {
+		return "", fmt.Errorf("resolve provider: %w", err)
 	}
-	userText := params.UserMessage
-	if len(otherParts) > 0 {
-		if userText != "" {
-			userText += "\n\n"
-		}
-		userText += "[Attached: " + strings.Join(otherParts, "; ") + "]"
+	modelInfo := l.ModelInfo(provider, model)
+
+	contextWindow := params.AgentConfig.Compaction.ContextWindow
+	if contextWindow <= 0 {
+		contextWindow = modelInfo.ContextWindow
 	}
-	var userMsg llm.Message
-	if len(images) > 0 {
-		userMsg = llm.UserMessageWithImages(userText, images)
-	} else {
-		userMsg = llm.UserMessage(userText)
+	if contextWindow <= 0 {
+		contextWindow = l.ContextWindow
 	}
-	messages = append(messages, userMsg)
-	if err := params.SessionMgr.Append(userMsg); err != nil {
-		slog.Warn("failed to append user message to transcript", "error", err)
+	if contextWindow <= 0 {
+		contextWindow = DefaultContextWindow
 	}
 
-	// Resolve provider and model from agent config (agent.Provider + agent.Model)
-	provider, model, provCfg, err := config.ResolveProviderForAgent(config.Get(), params.AgentConfig)
+	// Build tool definitions filtered by policy
+	policy, err := tool.NewPolicy(params.AgentConfig.Tools.Allow, params.AgentConfig.Tools.Deny)
 	if err != nil {
-		return "", fmt.Errorf("resolve provider: %w", err)
+		return "", fmt.Errorf("agent %s: %w", params.AgentID, err)
 	}
+	ctx = tool.WithPolicy(ctx, policy)
+	toolDefs := policy.Filter(l.Tools.ListToolDefs())
 
-	// Build tool definitions filtered by policy
-	toolDefs := l.Tools.ListToolDefs()
+	approvals, err := newApprovalPolicy(params.AgentConfig.Tools.Approval)
+	if err != nil {
+		return "", fmt.Errorf("agent %s: %w", params.AgentID, err)
+	}
+
+	recordRun(ctx, RunRecord{
+		RunID:          runID,
+		SessionKey:     params.SessionMgr.SessionKey(),
+		AgentID:        params.AgentID,
+		Agent:          *params.AgentConfig,
+		SystemPrompt:   params.SystemPrompt,
+		UserMessage:    params.UserMessage,
+		Attachments:    params.Attachments,
+		History:        history,
+		Tools:          toolDefs,
+		ContinuePrompt: params.ContinuePrompt,
+		ResponseSchema: params.ResponseSchema,
+
+		ToolChoice:        params.ToolChoice,
+		ParallelToolCalls: params.ParallelToolCalls,
+	})
 
 	// Build LLM params
 	baseLLMParams := llm.ChatParams{
@@ -128,11 +166,45 @@ func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
 		BaseURL:  provCfg.BaseURL,
 		System:   params.SystemPrompt,
 		Tools:    toolDefs,
+		Retry:    retryPolicy(provCfg.Retry),
+
+		ThinkingBudget: params.AgentConfig.Thinking.BudgetTokens,
+	}
+	applyGeneration(&baseLLMParams, params.AgentConfig.Generation)
+	baseLLMParams.Ollama = ollamaOptions(params.AgentConfig.Ollama)
+	baseLLMParams.Azure = azureOptions(provCfg.Azure)
+	baseLLMParams.ResponseSchema = params.ResponseSchema
+	if err := applyToolChoice(&baseLLMParams, params); err != nil {
+		return "", err
+	}
+
+	// Persist the user turn only once the agent's tool settings are known to be valid: a misconfigured
+	// agent must not leave an unanswered message in the transcript
+	userMsg := userMessage(ctx, params.UserMessage, params.Attachments, modelInfo)
+	messages = append(messages, userMsg)
+	if err := params.SessionMgr.Append(userMsg); err != nil {
+		slog.Warn("failed to append user message to transcript", "error", err)
 	}
 
-	var totalIn, totalOut int
+	continuePrompt := params.ContinuePrompt
+	if continuePrompt == "" {
+		continuePrompt = prompts.Get("zh").ContinuePrompt
+	}
 
-	for i := 0; i < maxIter; i++ {
+	var totalIn, totalOut, totalCacheRead, totalCacheWrite int
+	var lastModel string
+	var usedFallback bool
+
+	// A reply cut off at max tokens is resumed with a continue prompt; the partial text is kept in
+	// contPrefix and joined with the continuation, which replaces messages[contBase:] once complete.
+	var contPrefix string
+	var contBase, continuations int
+	var overflows int
+	budgetWarned := make(map[string]bool)
+
+	// Past maxIter the model gets one more call with tool choice "none" to answer from what it has.
+	for i := 0; ; i++ {
+		final := i >= maxIter
 		select {
 		case <-ctx.Done():
 			return "", ErrAborted
@@ -145,24 +217,33 @@ func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
 
 		llmParams := baseLLMParams
 		llmParams.Messages = messages
+		if final {
+			llmParams.ToolChoice = llm.ToolChoiceNone
+		}
+
+		if err := l.checkBudget(ctx, params.SessionMgr, llmParams, budgetWarned, emitter); err != nil {
+			emitter.Emit(EventTypeError, func(e *Event) {
+				e.Error = err.Error()
+				e.ErrorKind = ErrorKindBudget
+			})
+			return "", err
+		}
 
 		result, err := l.callLLM(ctx, llmParams, params.AgentConfig, emitter)
 		if err != nil {
-			// Check for context overflow → try compaction
-			var apiErr *llm.APIError
-			if errors.As(err, &apiErr) && apiErr.IsContextOverflow() {
-				slog.Info("context overflow, attempting compaction")
-				emitter.Emit(EventTypeCompactStart)
-				if compactErr := params.SessionMgr.DoCompact(ctx, l.resolveClient(provider), baseLLMParams, contextWindow); compactErr != nil {
-					return "", fmt.Errorf("compaction failed: %w (original: %w)", compactErr, err)
+			// Context overflow → force compaction, a bounded number of times per run
+			if llm.IsContextOverflow(err) {
+				err = l.recoverOverflow(ctx, params.SessionMgr, provider, baseLLMParams, err, &overflows, emitter)
+				if err == nil {
+					messages, _ = params.SessionMgr.LoadTranscript()
+					contPrefix = ""
+					continue
 				}
-				emitter.Emit(EventTypeCompactEnd)
-				// Reload messages after compaction
-				messages, _ = params.SessionMgr.LoadTranscript()
-				messages = append(messages, userMsg)
-				continue
 			}
-			emitter.Emit(EventTypeError, func(e *Event) { e.Error = err.Error() })
+			emitter.Emit(EventTypeError, func(e *Event) {
+				e.Error = err.Error()
+				e.ErrorKind = errorKind(err)
+			})
 			return "", err
 		}
 
@@ -170,7 +251,52 @@ func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
 		if result.Usage != nil {
 			totalIn += result.Usage.InputTokens
 			totalOut += result.Usage.OutputTokens
-			params.SessionMgr.Store.UpdateUsage(params.SessionMgr.SessionKey(), result.Usage.InputTokens, result.Usage.OutputTokens)
+			totalCacheRead += result.Usage.CacheReadTokens
+			totalCacheWrite += result.Usage.CacheCreationTokens
+			params.SessionMgr.Store.UpdateUsage(params.SessionMgr.SessionKey(), *result.Usage)
+		}
+
+		// Record which model answered (primary or fallback)
+		lastModel = result.Provider + "/" + result.Model
+		isFallback := result.Provider != provider || result.Model != model
+		usedFallback = usedFallback || isFallback
+		params.SessionMgr.Store.RecordModel(params.SessionMgr.SessionKey(), lastModel, isFallback)
+
+		// Calibrate token estimates against the primary model's real input count
+		if result.Usage != nil && !isFallback {
+			params.SessionMgr.Calibrate(llmParams, *result.Usage)
+		}
+
+		// Join a continuation with the truncated reply(s) before it
+		if contPrefix != "" {
+			result.Text = contPrefix + result.Text
+			result.Message.Content = contPrefix + result.Message.Content
+			messages = messages[:contBase]
+			contPrefix = ""
+		}
+
+		// Reply cut off at max tokens → continue it or fail clearly
+		if isTruncated(result.StopReason) {
+			if err := truncationError(result, params.AgentConfig.Generation, continuations); err != nil {
+				emitter.Emit(EventTypeError, func(e *Event) { e.Error = err.Error() })
+				return "", err
+			}
+			continuations++
+			slog.Info("reply truncated at max tokens, continuing", "session", params.SessionMgr.SessionKey(), "continuation", continuations)
+			emitter.Emit(EventTypeContinue, func(e *Event) {
+				e.Text = fmt.Sprintf("reply truncated at max tokens, continuing (%d/%d)", continuations, DefaultMaxContinuations)
+				e.Attempt = continuations
+				e.MaxAttempts = DefaultMaxContinuations
+			})
+			contPrefix = result.Text
+			contBase = len(messages)
+			messages = append(messages, result.Message, llm.UserMessage(continuePrompt))
+			continue
+		}
+
+		// Still calling tools after the final call (provider without tool_choice) → give up
+		if final && len(result.ToolCalls) > 0 {
+			return "", ErrMaxIterations
 		}
 
 		// Persist assistant message
@@ -188,28 +314,20 @@ func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
 			emitter.Emit(EventTypeDone, func(e *Event) {
 				e.TotalTokensIn = totalIn
 				e.TotalTokensOut = totalOut
+				e.CacheReadTokens = totalCacheRead
+				e.CacheCreationTokens = totalCacheWrite
 				e.Iterations = i + 1
+				e.Model = lastModel
+				e.Fallback = usedFallback
 			})
 			return result.Text, nil
 		}
 
-		// Execute tool calls
+		// Execute tool calls; results come back in call order
 		messages = append(messages, result.Message)
-		for _, tc := range result.ToolCalls {
-			emitter.Emit(EventTypeToolStart, func(e *Event) {
-				e.ToolName = tc.Name
-				e.ToolParams = tc.Arguments
-			})
-
-			toolResult, err := l.Tools.Execute(ctx, tc.Name, tc.Arguments)
-			if err != nil {
-				toolResult = fmt.Sprintf(`{"error": %q}`, err.Error())
-			}
-
-			emitter.Emit(EventTypeToolEnd, func(e *Event) {
-				e.ToolName = tc.Name
-				e.ToolResult = toolResult
-			})
+		toolResults := l.executeToolCalls(ctx, result.ToolCalls, params.AgentConfig.Tools, approvals, emitter)
+		for i, tc := range result.ToolCalls {
+			toolResult := toolResults[i]
 			if params.ToolSteps != nil {
 				*params.ToolSteps = append(*params.ToolSteps, ToolStep{
 					ToolName:   tc.Name,
@@ -226,132 +344,556 @@ func (l *Loop) Run(ctx context.Context, params RunParams) (string, error) {
 		}
 
 		// Check if compaction needed after tool calls
-		shouldCompact, _ := params.SessionMgr.ShouldCompact(contextWindow)
+		shouldCompact, _ := params.SessionMgr.ShouldCompact(baseLLMParams, contextWindow)
 		if shouldCompact {
 			emitter.Emit(EventTypeCompactStart)
-			if err := params.SessionMgr.DoCompact(ctx, l.resolveClient(provider), baseLLMParams, contextWindow); err != nil {
+			client, err := l.resolveClient(ctx, provider, llm.UsageKindCompaction)
+			if err == nil {
+				err = params.SessionMgr.DoCompact(ctx, client, baseLLMParams, contextWindow)
+			}
+			if err != nil {
 				slog.Warn("post-iteration compaction failed", "error", err)
 			} else {
 				emitter.Emit(EventTypeCompactEnd)
 				messages, _ = params.SessionMgr.LoadTranscript()
 			}
 		}
+
+		// A forced tool choice only applies until the model called a tool; then it may answer.
+		if baseLLMParams.ToolChoice.Forced() {
+			baseLLMParams.ToolChoice = ""
+		}
 	}
+}
+
+// recoverOverflow force-compacts the session after the provider rejected the prompt as too long.
+// It returns nil if the transcript shrank and the call should be retried, or an error wrapping ErrContextFull
+// once DefaultMaxOverflows compactions were spent or nothing is left to summarize.
+func (l *Loop) recoverOverflow(ctx context.Context, mgr *session.Manager, provider string, params llm.ChatParams, cause error, overflows *int, emitter *EventEmitter) error {
+	if *overflows >= DefaultMaxOverflows {
+		return fmt.Errorf("%w after %d compactions: %w", ErrContextFull, *overflows, cause)
+	}
+	*overflows++
+	slog.Info("context overflow, forcing compaction", "session", mgr.SessionKey(), "attempt", *overflows, "error", cause)
+	emitter.Emit(EventTypeCompactStart)
+	client, err := l.resolveClient(ctx, provider, llm.UsageKindCompaction)
+	if err != nil {
+		return fmt.Errorf("compaction failed: %w (original: %w)", err, cause)
+	}
+	compacted, err := mgr.ForceCompact(ctx, client, params)
+	if err != nil {
+		return fmt.Errorf("compaction failed: %w (original: %w)", err, cause)
+	}
+	emitter.Emit(EventTypeCompactEnd)
+	if !compacted {
+		return fmt.Errorf("%w and the latest message alone is too long: %w", ErrContextFull, cause)
+	}
+	return nil
+}
 
-	return "", ErrMaxIterations
+// checkBudget checks the next call, estimated from the request about to be sent, against the budgets;
+// limits past the soft threshold are reported once per run as budget_warning events.
+func (l *Loop) checkBudget(ctx context.Context, mgr *session.Manager, params llm.ChatParams, warned map[string]bool, emitter *EventEmitter) error {
+	if l.Budget == nil {
+		return nil
+	}
+	next := usageRecord(ctx, llm.UsageKindChat)
+	next.Provider, next.Model = params.Provider, params.Model
+	next.InputTokens = mgr.Compactor.EstimateRequest(params, params.Messages)
+	warnings, err := l.Budget.Check(next)
+	for _, w := range warnings {
+		if warned[w.Key()] {
+			continue
+		}
+		warned[w.Key()] = true
+		slog.Warn("budget nearly used up", "session", mgr.SessionKey(), "limit", w.String())
+		emitter.Emit(EventTypeBudgetWarning, func(e *Event) {
+			e.Text = "budget nearly used up: " + w.String()
+			e.Budget = &w
+		})
+	}
+	return err
 }
 
-func (l *Loop) callLLM(ctx context.Context, params llm.ChatParams, agentCfg *config.AgentConfig, emitter *EventEmitter) (*llm.StreamResult, error) {
+// applyGeneration copies the agent's generation parameters into the LLM params.
+func applyGeneration(p *llm.ChatParams, g config.GenerationConfig) {
+	p.MaxTokens = g.MaxTokens
+	p.Temperature = g.Temperature
+	p.TopP = g.TopP
+	p.Stop = g.Stop
+	p.Seed = g.Seed
+}
+
+// ModelInfo returns the catalog entry of a provider's model; zero (everything allowed, no limits known)
+// without a catalog or for unknown models.
+func (l *Loop) ModelInfo(provider, model string) llm.ModelInfo {
+	if l.Models == nil {
+		return llm.ModelInfo{ID: model, Provider: provider}
+	}
+	info, _ := l.Models.Lookup(provider, model)
+	return info
+}
+
+// applyModelInfo adapts a request to what the model supports, so it is not rejected: no tool definitions
+// for models without tool calling, no thinking for non-reasoning models, maxTokens capped at the
+// model's maximum output.
+func applyModelInfo(p *llm.ChatParams, info llm.ModelInfo) {
+	if !info.SupportsTools() {
+		p.Tools = nil
+	}
+	if !info.SupportsReasoning() {
+		p.ThinkingBudget = 0
+	}
+	if info.MaxOutputTokens > 0 && p.MaxTokens > info.MaxOutputTokens {
+		p.MaxTokens = info.MaxOutputTokens
+	}
+}
+
+// applyToolChoice sets the tool choice and parallel tool calls of a run: the request's, else the agent's.
+// A specific tool must be one of the run's tools.
+func applyToolChoice(p *llm.ChatParams, params RunParams) error {
+	p.ToolChoice = params.ToolChoice
+	if p.ToolChoice == "" {
+		p.ToolChoice = llm.ToolChoice(params.AgentConfig.Tools.Choice)
+	}
+	p.ParallelToolCalls = params.ParallelToolCalls
+	if p.ParallelToolCalls == nil {
+		p.ParallelToolCalls = params.AgentConfig.Tools.Parallel
+	}
+	if name := p.ToolChoice.Tool(); name != "" && !slices.ContainsFunc(p.Tools, func(t llm.ToolDef) bool { return t.Name == name }) {
+		return fmt.Errorf("tool choice: unknown tool %q", name)
+	}
+	return nil
+}
+
+// azureOptions converts a provider's Azure OpenAI settings; nil when none are set.
+func azureOptions(a config.AzureConfig) *llm.AzureOptions {
+	if a.APIVersion == "" && len(a.Deployments) == 0 && a.EntraID == nil {
+		return nil
+	}
+	opts := &llm.AzureOptions{APIVersion: a.APIVersion, Deployments: a.Deployments}
+	if e := a.EntraID; e != nil {
+		opts.EntraID = &llm.EntraIDCredentials{TenantID: e.TenantID, ClientID: e.ClientID, ClientSecret: e.ClientSecret, AuthorityHost: e.AuthorityHost}
+	}
+	return opts
+}
+
+// ollamaOptions converts the agent's Ollama settings; nil when none are set.
+func ollamaOptions(o config.OllamaConfig) *llm.OllamaOptions {
+	if o.NumCtx == 0 && o.KeepAlive == "" && len(o.Options) == 0 {
+		return nil
+	}
+	return &llm.OllamaOptions{NumCtx: o.NumCtx, KeepAlive: o.KeepAlive, Options: o.Options}
+}
+
+// isTruncated reports whether the stop reason means the reply hit the output token limit
+// ("max_tokens" for Anthropic, "length" for OpenAI-compatible providers).
+func isTruncated(stopReason string) bool {
+	return stopReason == "max_tokens" || stopReason == "length"
+}
+
+// truncationError returns nil if a truncated reply can be auto-continued, or an error explaining why not.
+// Tool calls cut off mid-arguments cannot be resumed, so they always fail.
+func truncationError(result *llm.StreamResult, gen config.GenerationConfig, continuations int) error {
+	switch {
+	case len(result.ToolCalls) > 0:
+		return fmt.Errorf("%w: tool call %q was cut off; raise generation.maxTokens", ErrMaxTokens, result.ToolCalls[len(result.ToolCalls)-1].Name)
+	case gen.OnMaxTokens == "error":
+		return fmt.Errorf("%w (generation.onMaxTokens is \"error\")", ErrMaxTokens)
+	case strings.TrimSpace(result.Text) == "":
+		return fmt.Errorf("%w before any text was produced; raise generation.maxTokens", ErrMaxTokens)
+	case continuations >= DefaultMaxContinuations:
+		return fmt.Errorf("%w after %d continuations; raise generation.maxTokens", ErrMaxTokens, continuations)
+	}
+	return nil
+}
+
+// modelCandidate is one provider/model the loop may call: the agent's primary model or a fallback.
+type modelCandidate struct {
+	provider string
+	model    string
+	provCfg  config.ProviderConfig
+}
+
+func (c modelCandidate) ref() string { return c.provider + "/" + c.model }
+
+// modelCandidates returns the agent's primary model followed by its configured fallbacks, in order.
+// Fallback refs without a provider use the primary provider; unresolvable fallbacks are skipped.
+func modelCandidates(cfg *config.Config, agentCfg *config.AgentConfig) ([]modelCandidate, error) {
 	defaultProvider := agentCfg.Provider
 	if defaultProvider == "" && strings.Contains(agentCfg.Model, "/") {
-		if p, _, _, e := config.ResolveProvider(config.Get(), agentCfg.Model); e == nil {
+		if p, _, _, e := config.ResolveProvider(cfg, agentCfg.Model); e == nil {
 			defaultProvider = p
 		}
 	}
-	provider, model, provCfg, err := config.ResolveProviderWithDefault(config.Get(), agentCfg.Model, defaultProvider)
+	provider, model, provCfg, err := config.ResolveProviderWithDefault(cfg, agentCfg.Model, defaultProvider)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []modelCandidate{{provider: provider, model: model, provCfg: provCfg}}
+	for _, ref := range agentCfg.Fallbacks {
+		p, m, pc, err := config.ResolveProviderWithDefault(cfg, ref, defaultProvider)
+		if err != nil {
+			slog.Warn("skipping invalid fallback model", "ref", ref, "error", err)
+			continue
+		}
+		candidates = append(candidates, modelCandidate{provider: p, model: m, provCfg: pc})
+	}
+	return candidates, nil
+}
+
+// shouldFailover reports whether a failed Chat call should move on to the next fallback model.
+// Cancellation is final, context overflow is left to compaction, and requests the provider rejected as
+// invalid or filtered would fail the same way elsewhere; provider failures that survived retries
+// (auth, quota, rate limit, overload, 5xx, unknown model, network) fail over.
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch llm.ErrorKindOf(err) {
+	case "":
+		var tErr *llm.TransportError
+		return errors.As(err, &tErr)
+	case llm.ErrorKindContextLength, llm.ErrorKindInvalidRequest, llm.ErrorKindContentFilter:
+		return false
+	}
+	return true
+}
+
+// errorKind classifies err for error, retry and fallback events.
+func errorKind(err error) string {
+	if errors.Is(err, llm.ErrStreamStalled) {
+		return ErrorKindStreamStalled
+	}
+	return string(llm.ErrorKindOf(err))
+}
+
+// callLLM calls the agent's primary model, walking agentCfg.Fallbacks on provider failures.
+// The returned result records which provider/model answered.
+func (l *Loop) callLLM(ctx context.Context, params llm.ChatParams, agentCfg *config.AgentConfig, emitter *EventEmitter) (*llm.StreamResult, error) {
+	candidates, err := modelCandidates(config.Get(), agentCfg)
 	if err != nil {
 		return nil, err
 	}
+	for i, c := range candidates {
+		if i > 0 {
+			slog.Warn("falling back to next model", "from", candidates[i-1].ref(), "to", c.ref(), "error", err)
+			emitter.Emit(EventTypeFallback, func(e *Event) {
+				e.Text = fmt.Sprintf("%s unavailable, switching to %s", candidates[i-1].ref(), c.ref())
+				e.Error = err.Error()
+				e.ErrorKind = errorKind(err)
+				e.Model = c.ref()
+			})
+		}
+		var result *llm.StreamResult
+		var streamed bool
+		result, streamed, err = l.callModel(ctx, params, c, emitter)
+		if err == nil {
+			result.Provider = c.provider
+			result.Model = c.model
+			return result, nil
+		}
+		// Once part of the answer reached the client, another model would stream a second answer after it
+		if streamed || !shouldFailover(ctx, err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// callModel makes one Chat call (with provider-level retries) against a single provider/model.
+// streamed reports whether delta events were emitted before an error.
+func (l *Loop) callModel(ctx context.Context, params llm.ChatParams, c modelCandidate, emitter *EventEmitter) (result *llm.StreamResult, streamed bool, err error) {
+	provider, model, provCfg := c.provider, c.model, c.provCfg
 	p := params
 	p.Provider = provider
 	p.Model = model
 	p.APIKey = provCfg.APIKey
 	p.BaseURL = provCfg.BaseURL
-	client := l.resolveClient(provider)
+	p.Retry = retryPolicy(provCfg.Retry)
+	p.ChainResponses = provCfg.ChainResponses
+	p.Azure = azureOptions(provCfg.Azure)
+	applyModelInfo(&p, l.ModelInfo(provider, model))
+	p.OnRetry = func(info llm.RetryInfo) {
+		slog.Warn("LLM request failed, retrying", "provider", provider, "model", model,
+			"attempt", info.Attempt, "maxRetries", info.MaxRetries, "delay", info.Delay, "error", info.Err)
+		emitter.Emit(EventTypeRetry, func(e *Event) {
+			e.Text = fmt.Sprintf("%s/%s unavailable, retrying in %s (%d/%d)",
+				provider, model, info.Delay.Round(100*time.Millisecond), info.Attempt, info.MaxRetries)
+			e.Error = info.Err.Error()
+			e.ErrorKind = errorKind(info.Err)
+			e.Attempt = info.Attempt
+			e.MaxAttempts = info.MaxRetries
+			e.RetryDelayMs = info.Delay.Milliseconds()
+		})
+	}
+	client, err := l.resolveClient(ctx, provider, llm.UsageKindChat)
+	if err != nil {
+		return nil, false, err
+	}
 	stream, err := client.Chat(ctx, p)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	return l.consumeWithEvents(ctx, stream, emitter)
 }
 
-// consumeWithEvents reads the stream and emits text_delta events in real time.
-func (l *Loop) consumeWithEvents(ctx context.Context, stream <-chan llm.StreamEvent, emitter *EventEmitter) (*llm.StreamResult, error) {
-	result := &llm.StreamResult{}
-	var textBuf []byte
-	toolArgs := make(map[int]*[]byte)
-
+// consumeWithEvents reads the stream and emits text_delta / thinking_delta / reasoning_delta events in real time.
+// streamed reports whether any of them was emitted, also when the stream then fails.
+func (l *Loop) consumeWithEvents(ctx context.Context, stream <-chan llm.StreamEvent, emitter *EventEmitter) (result *llm.StreamResult, streamed bool, err error) {
+	acc := llm.NewStreamAccumulator()
 	for event := range stream {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, streamed, ctx.Err()
 		default:
 		}
 
 		switch event.Type {
 		case "text_delta":
-			textBuf = append(textBuf, event.Text...)
 			emitter.Emit(EventTypeTextDelta, func(e *Event) { e.Text = event.Text })
-
-		case "tool_call_delta":
-			if _, ok := toolArgs[event.ToolCallIndex]; !ok {
-				buf := []byte{}
-				toolArgs[event.ToolCallIndex] = &buf
-				result.ToolCalls = append(result.ToolCalls, llm.ToolCall{
-					ID:   event.ToolCallID,
-					Name: event.ToolCallName,
-				})
-			}
-			*toolArgs[event.ToolCallIndex] = append(*toolArgs[event.ToolCallIndex], event.ToolCallArgs...)
-			idx := event.ToolCallIndex
-			if idx < len(result.ToolCalls) {
-				if event.ToolCallID != "" {
-					result.ToolCalls[idx].ID = event.ToolCallID
-				}
-				if event.ToolCallName != "" {
-					result.ToolCalls[idx].Name = event.ToolCallName
-				}
+			streamed = true
+		case "thinking_delta":
+			if event.Text != "" {
+				emitter.Emit(EventTypeThinkingDelta, func(e *Event) { e.Text = event.Text })
+				streamed = true
 			}
+		case "reasoning_delta":
+			emitter.Emit(EventTypeReasoningDelta, func(e *Event) { e.Text = event.Text })
+			streamed = true
+		}
+		if err := acc.Add(event); err != nil {
+			return nil, streamed, err
+		}
+	}
+	return acc.Result(), streamed, nil
+}
 
-		case "usage":
-			if result.Usage == nil {
-				result.Usage = &llm.Usage{}
-			}
-			if event.Usage != nil {
-				result.Usage.InputTokens += event.Usage.InputTokens
-				result.Usage.OutputTokens += event.Usage.OutputTokens
-			}
+// retryPolicy converts provider retry config to the llm retry policy.
+func retryPolicy(c config.RetryConfig) llm.RetryPolicy {
+	return llm.RetryPolicy{
+		MaxRetries:   c.MaxRetries,
+		InitialDelay: time.Duration(c.InitialDelayMs) * time.Millisecond,
+		MaxDelay:     time.Duration(c.MaxDelayMs) * time.Millisecond,
+	}
+}
 
-		case "error":
-			return nil, event.Error
+// executeToolCalls runs the tool calls of one assistant message and returns their results in call order.
+// Up to tools.maxConcurrency calls run at once; serial calls (tool.SerialTool or listed in tools.serial)
+// run one after another in call order. tool_start events are emitted in call order before the calls
+// start, tool_end events and recorded results in call order as the calls complete. Calls needing
+// human approval (tools.approval) wait for it before they take a slot. Calls not yet started when ctx
+// ends fail with its error.
+func (l *Loop) executeToolCalls(ctx context.Context, calls []llm.ToolCall, cfg config.AgentToolsConfig, approvals *approvalPolicy, emitter *EventEmitter) []string {
+	limit := cfg.MaxConcurrency
+	if limit <= 0 {
+		limit = DefaultToolConcurrency
+	}
+	replaying := replayCassette(ctx) != nil
+	if replaying {
+		limit = 1 // recorded results are read back in call order
+	}
+
+	type output struct {
+		result string
+		err    error
+	}
+	outputs := make([]output, len(calls))
+	results := make([]string, len(calls))
+	start := func(tc llm.ToolCall) {
+		emitter.Emit(EventTypeToolStart, func(e *Event) {
+			e.ToolName = tc.Name
+			e.ToolParams = tc.Arguments
+		})
+	}
+	finish := func(i int) {
+		tc, out := calls[i], outputs[i]
+		if !replaying {
+			recordTool(ctx, tc.Name, tc.Arguments, out.result, out.err)
+		}
+		results[i] = out.result
+		if out.err != nil {
+			results[i] = fmt.Sprintf(`{"error": %q}`, out.err.Error())
+		}
+		emitter.Emit(EventTypeToolEnd, func(e *Event) {
+			e.ToolName = tc.Name
+			e.ToolResult = results[i]
+		})
+	}
 
-		case "done":
-			result.StopReason = event.Text
+	if limit == 1 || len(calls) == 1 {
+		for i, tc := range calls {
+			start(tc)
+			if outputs[i].err = ctx.Err(); outputs[i].err == nil {
+				outputs[i].result, outputs[i].err = l.callTool(ctx, tc, approvals, nil, emitter)
+			}
+			finish(i)
 		}
+		return results
 	}
 
-	result.Text = string(textBuf)
-	for idx, args := range toolArgs {
-		if idx < len(result.ToolCalls) {
-			result.ToolCalls[idx].Arguments = string(*args)
+	for _, tc := range calls {
+		start(tc)
+	}
+	sem := make(chan struct{}, limit)
+	done := make([]chan struct{}, len(calls))
+	var lastSerial chan struct{}
+	for i, tc := range calls {
+		done[i] = make(chan struct{})
+		var after chan struct{}
+		if l.Tools.IsSerial(tc.Name) || slices.Contains(cfg.Serial, tc.Name) {
+			after, lastSerial = lastSerial, done[i]
 		}
+		go func() {
+			defer close(done[i])
+			if after != nil {
+				select {
+				case <-after:
+				case <-ctx.Done():
+					outputs[i].err = ctx.Err()
+					return
+				}
+			}
+			outputs[i].result, outputs[i].err = l.callTool(ctx, tc, approvals, sem, emitter)
+		}()
 	}
+	for i := range calls {
+		<-done[i]
+		finish(i)
+	}
+	return results
+}
 
-	if len(result.ToolCalls) > 0 {
-		result.Message = llm.Message{Role: llm.RoleAssistant, Content: result.Text, ToolCalls: result.ToolCalls}
-	} else {
-		result.Message = llm.Message{Role: llm.RoleAssistant, Content: result.Text}
+// callTool gets the approval a tool call needs, then runs it holding a slot of sem (if not nil), so
+// calls waiting for a human do not block others. The decision is recorded in the result.
+func (l *Loop) callTool(ctx context.Context, tc llm.ToolCall, approvals *approvalPolicy, sem chan struct{}, emitter *EventEmitter) (string, error) {
+	approval, err := l.approve(ctx, tc, approvals, emitter)
+	if err != nil {
+		return "", err
+	}
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
 	}
+	result, err := l.executeTool(ctx, tc.Name, tc.Arguments)
+	if approval != nil {
+		if err != nil {
+			return result, fmt.Errorf("tool call %s, then failed: %w", approval.note(), err)
+		}
+		result = fmt.Sprintf("[tool call %s]\n%s", approval.note(), result)
+	}
+	return result, err
+}
 
-	return result, nil
+// executeTool runs a tool call; during a replay the recorded result is returned instead.
+func (l *Loop) executeTool(ctx context.Context, name, args string) (string, error) {
+	if c := replayCassette(ctx); c != nil {
+		return replayToolResult(c, name)
+	}
+	return l.Tools.Execute(ctx, name, args)
 }
 
-// resolveClient picks the right LLM client based on provider config.
-func (l *Loop) resolveClient(provider string) llm.Client {
+// resolveClient picks the LLM client for a provider; it records into the run's cassette when recording,
+// and serves the cassette instead of the provider when replaying. Live calls are metered in l.Usage
+// under the given kind (llm.UsageKindChat or llm.UsageKindCompaction).
+func (l *Loop) resolveClient(ctx context.Context, provider, kind string) (llm.Client, error) {
+	if c := replayCassette(ctx); c != nil {
+		return llm.NewReplayClient(c), nil
+	}
 	clientType := "openai"
+	var provCfg config.ProviderConfig
 	if cfg := config.Get(); cfg != nil {
-		if provCfg, ok := cfg.Providers[provider]; ok {
-			clientType = provCfg.ClientType(provider)
+		if pc, ok := cfg.Providers[provider]; ok {
+			provCfg = pc
+			clientType = pc.ClientType(provider)
 		}
 	}
-	if clientType == "anthropic" {
-		if l.Anthropic == nil {
-			l.Anthropic = llm.NewAnthropicClient()
-		}
-		return l.Anthropic
+	client, err := l.providerClient(provider, clientType, provCfg)
+	if err != nil {
+		return nil, err
+	}
+	client = llm.NewStallRetryClient(client)
+	if c := runCassette(ctx); c != nil {
+		client = llm.NewRecordingClient(client, clientType, c)
+	}
+	if l.Usage != nil {
+		client = llm.NewMeteredClient(client, l.Usage, usageRecord(ctx, kind))
+	}
+	return client, nil
+}
+
+// usageRecord attributes an LLM call to the run, its agent, session and channel (the session key prefix).
+func usageRecord(ctx context.Context, kind string) llm.UsageRecord {
+	rec := llm.UsageRecord{Kind: kind}
+	if info, ok := tool.RunInfoFromContext(ctx); ok {
+		rec.RunID = info.RunID
+		rec.Agent = info.AgentID
+		rec.SessionKey = info.SessionKey
+		rec.Channel, _, _ = strings.Cut(info.SessionKey, ":")
+	}
+	return rec
+}
+
+// providerClient returns the provider's client from l.Clients (the shared mock client for mock providers).
+func (l *Loop) providerClient(provider, clientType string, provCfg config.ProviderConfig) (llm.Client, error) {
+	if clientType == "mock" {
+		l.clientsOnce.Do(l.initClients)
+		return l.Mock, nil
+	}
+	opts, err := TransportOptions(provCfg)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", provider, err)
+	}
+	l.clientsOnce.Do(l.initClients)
+	return l.Clients.Get(provider, clientType, opts)
+}
+
+func (l *Loop) initClients() {
+	if l.Clients == nil {
+		l.Clients = llm.NewClientPool()
+	}
+	if l.Mock == nil {
+		l.Mock = llm.NewMockClient()
+	}
+}
+
+// TransportOptions converts a provider's network settings; a relative caFile is resolved against home.
+func TransportOptions(p config.ProviderConfig) (llm.TransportOptions, error) {
+	opts := llm.TransportOptions{
+		Headers:            p.Headers,
+		Proxy:              p.Proxy,
+		CAFile:             p.CAFile,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" && !filepath.IsAbs(opts.CAFile) {
+		opts.CAFile = filepath.Join(config.Home(), opts.CAFile)
+	}
+	var err error
+	if opts.ConnectTimeout, err = parseTimeout("connectTimeout", p.ConnectTimeout); err != nil {
+		return opts, err
+	}
+	if opts.RequestTimeout, err = parseTimeout("requestTimeout", p.RequestTimeout); err != nil {
+		return opts, err
+	}
+	if p.StreamIdleTimeout == "off" {
+		opts.StreamIdleTimeout = -1
+	} else if opts.StreamIdleTimeout, err = parseTimeout("streamIdleTimeout", p.StreamIdleTimeout); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func parseTimeout(name, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
 	}
-	if l.OpenAI == nil {
-		l.OpenAI = llm.NewOpenAIClient()
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q (use e.g. \"30s\" or \"5m\")", name, s)
 	}
-	return l.OpenAI
+	return d, nil
 }
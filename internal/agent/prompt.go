@@ -24,6 +24,7 @@ type PromptBuilder struct {
 	ToolDefs    []llm.ToolDef
 	Skills      []skills.SkillEntry
 	Workspace   string
+	Model       llm.ModelInfo // catalog entry of the agent's model; zero when unknown
 }
 
 // Build constructs the full system prompt.
@@ -52,7 +53,7 @@ func (b *PromptBuilder) writeIdentity(sb *strings.Builder) {
 }
 
 func (b *PromptBuilder) writeTooling(sb *strings.Builder) {
-	if len(b.ToolDefs) == 0 {
+	if len(b.ToolDefs) == 0 || !b.Model.SupportsTools() {
 		return
 	}
 	sb.WriteString(b.Prompts.SectionToolsTitle)
@@ -127,6 +128,12 @@ func (b *PromptBuilder) writeRuntime(sb *strings.Builder) {
 		modelDisplay = b.AgentConfig.Provider + "/" + b.AgentConfig.Model
 	}
 	fmt.Fprintf(sb, "- Model: %s\n", modelDisplay)
+	if b.Model.ContextWindow > 0 {
+		fmt.Fprintf(sb, "- Context window: %d tokens\n", b.Model.ContextWindow)
+	}
+	if len(b.Model.Input) > 0 {
+		fmt.Fprintf(sb, "- Input: %s\n", strings.Join(b.Model.Input, ", "))
+	}
 	fmt.Fprintf(sb, "- OS: %s/%s\n", runtime.GOOS, runtime.GOARCH)
 	fmt.Fprintf(sb, "- Time: %s\n", time.Now().Format("2006-01-02 15:04:05 MST"))
 	home := config.Home()
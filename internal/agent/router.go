@@ -8,9 +8,12 @@ import (
 	"time"
 
 	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
 	"github.com/lhdbsbz/aido/internal/prompts"
 	"github.com/lhdbsbz/aido/internal/session"
 	"github.com/lhdbsbz/aido/internal/skills"
+	"github.com/lhdbsbz/aido/internal/tokenizer"
+	"github.com/lhdbsbz/aido/internal/tool"
 )
 
 // Router manages multiple agents and routes messages to the correct one.
@@ -49,12 +52,18 @@ type InboundMessage struct {
 	Text        string
 	Attachments []Attachment // image | audio | video | file
 	MessageID   string       // for dedup
+
+	ResponseSchema *llm.ResponseSchema // optional: structured output, final answer must be JSON matching this schema
+
+	ToolChoice        llm.ToolChoice // optional: overrides the agent's tools.choice
+	ParallelToolCalls *bool          // optional: overrides the agent's tools.parallel
 }
 
 // Attachment is one media or file item. Type is "image" | "audio" | "video" | "file".
-// Content is either URL or Base64+MIME.
+// Content is either URL or Base64+MIME. Name is the original file name, if known.
 type Attachment struct {
 	Type   string
+	Name   string
 	URL    string
 	Base64 string
 	MIME   string
@@ -92,6 +101,14 @@ func (r *Router) HandleMessage(ctx context.Context, msg InboundMessage, eventSin
 	lock.Lock()
 	defer lock.Unlock()
 
+	// Refuse up front when a day or month budget is already used up
+	if b := r.loop.Budget; b != nil {
+		if _, err := b.Check(llm.UsageRecord{Agent: agentID, SessionKey: sessionKey}); err != nil {
+			slog.Warn("agent run refused", "agent", agentID, "session", sessionKey, "error", err)
+			return "", nil, err
+		}
+	}
+
 	// Get or create session
 	r.store.GetOrCreate(sessionKey, agentID)
 
@@ -101,25 +118,24 @@ func (r *Router) HandleMessage(ctx context.Context, msg InboundMessage, eventSin
 	}
 	p := prompts.Get(locale)
 
-	compactor := session.DefaultCompactor()
-	compactor.SummarizePromptTemplate = p.SummarizePromptTemplate
-	if agentCfg.Compaction.KeepRecentTokens > 0 {
-		compactor.KeepRecentTokens = agentCfg.Compaction.KeepRecentTokens
-	}
-	if agentCfg.Compaction.ReserveTokens > 0 {
-		compactor.ReserveTokens = agentCfg.Compaction.ReserveTokens
-	}
-	if agentCfg.Compaction.ChunkRatio > 0 {
-		compactor.ChunkRatio = agentCfg.Compaction.ChunkRatio
-	}
+	compactor := newCompactor(cfg, &agentCfg)
 
 	sessionDir := r.store.TranscriptPath(sessionKey)
 	_ = sessionDir // transcript path is derived from store
 	mgr := session.NewManager(r.store, compactor, sessionKey, agentID)
 
-	toolDefs := r.loop.Tools.ListToolDefs()
+	policy, err := tool.NewPolicy(agentCfg.Tools.Allow, agentCfg.Tools.Deny)
+	if err != nil {
+		return "", nil, fmt.Errorf("agent %s: %w", agentID, err)
+	}
+	toolDefs := policy.Filter(r.loop.Tools.ListToolDefs())
 	workspace := config.Workspace()
 
+	var modelInfo llm.ModelInfo
+	if provider, model, _, err := config.ResolveProviderForAgent(cfg, &agentCfg); err == nil {
+		modelInfo = r.loop.ModelInfo(provider, model)
+	}
+
 	promptBuilder := &PromptBuilder{
 		Prompts:     p,
 		AgentConfig: &agentCfg,
@@ -127,8 +143,12 @@ func (r *Router) HandleMessage(ctx context.Context, msg InboundMessage, eventSin
 		ToolDefs:    toolDefs,
 		Skills:      loadedSkills,
 		Workspace:   workspace,
+		Model:       modelInfo,
 	}
 	systemPrompt := promptBuilder.Build()
+	if msg.ResponseSchema != nil {
+		systemPrompt += fmt.Sprintf(p.StructuredOutputFmt, string(msg.ResponseSchema.Schema))
+	}
 
 	slog.Info("agent run started", "agent", agentID, "session", sessionKey, "channel", msg.Channel)
 	start := time.Now()
@@ -143,6 +163,12 @@ func (r *Router) HandleMessage(ctx context.Context, msg InboundMessage, eventSin
 		Attachments:  msg.Attachments,
 		EventSink:    eventSink,
 		ToolSteps:    &toolSteps,
+
+		ContinuePrompt: p.ContinuePrompt,
+		ResponseSchema: msg.ResponseSchema,
+
+		ToolChoice:        msg.ToolChoice,
+		ParallelToolCalls: msg.ParallelToolCalls,
 	})
 
 	duration := time.Since(start)
@@ -161,6 +187,30 @@ func (r *Router) HandleMessage(ctx context.Context, msg InboundMessage, eventSin
 	return result, toolSteps, nil
 }
 
+// newCompactor builds the session compactor for an agent: summary prompt in the configured locale,
+// the agent's compaction settings and the tokenizer of its model.
+func newCompactor(cfg *config.Config, agentCfg *config.AgentConfig) *session.Compactor {
+	locale := cfg.Gateway.Locale
+	if locale != "en" {
+		locale = "zh"
+	}
+	compactor := session.DefaultCompactor()
+	compactor.SummarizePromptTemplate = prompts.Get(locale).SummarizePromptTemplate
+	if agentCfg.Compaction.KeepRecentTokens > 0 {
+		compactor.KeepRecentTokens = agentCfg.Compaction.KeepRecentTokens
+	}
+	if agentCfg.Compaction.ReserveTokens > 0 {
+		compactor.ReserveTokens = agentCfg.Compaction.ReserveTokens
+	}
+	if agentCfg.Compaction.ChunkRatio > 0 {
+		compactor.ChunkRatio = agentCfg.Compaction.ChunkRatio
+	}
+	if _, model, provCfg, err := config.ResolveProviderForAgent(cfg, agentCfg); err == nil {
+		compactor.Tokenizer = tokenizer.ForModel(provCfg.Tokenizer, model)
+	}
+	return compactor
+}
+
 // SessionKeyFromChannelChat creates the session key for storage/lock: channel:channelChatId only.
 func SessionKeyFromChannelChat(channel, chatID string) string {
 	if channel == "" {
@@ -177,6 +227,11 @@ func (r *Router) Store() *session.Store {
 	return r.store
 }
 
+// Approvals returns the pending tool call approvals; nil if the loop has no approval manager.
+func (r *Router) Approvals() *ApprovalManager {
+	return r.loop.Approvals
+}
+
 func (r *Router) getSessionLock(key string) *sync.Mutex {
 	r.locksMu.Lock()
 	defer r.locksMu.Unlock()
@@ -0,0 +1,270 @@
+// Package budget enforces token and cost budgets per agent, channel and session.
+// Spend comes from the usage log: the current month is loaded at startup and every recorded LLM call
+// is added as it happens.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+// ErrExceeded is wrapped by the error Check returns when a call would exceed a hard limit.
+var ErrExceeded = errors.New("budget exceeded")
+
+// DefaultSoftRatio is the share of a limit at which Check starts warning.
+const DefaultSoftRatio = 0.8
+
+// Scopes a limit applies to.
+const (
+	ScopeAgent   = "agent"
+	ScopeChannel = "channel"
+	ScopeSession = "session"
+)
+
+// Periods a limit counts over.
+const (
+	PeriodRun   = "run"
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Limit is one budget limit and the spend counted against it, including the call being checked.
+type Limit struct {
+	Scope  string  `json:"scope"`  // ScopeAgent | ScopeChannel | ScopeSession
+	ID     string  `json:"id"`     // agent id, channel or session key
+	Period string  `json:"period"` // PeriodRun | PeriodDay | PeriodMonth
+	Unit   string  `json:"unit"`   // "tokens" | "usd"
+	Max    float64 `json:"max"`
+	Used   float64 `json:"used"`
+}
+
+func (l Limit) String() string {
+	if l.Unit == "usd" {
+		return fmt.Sprintf("%s %s %s cost $%.4f of $%.2f", l.Scope, l.ID, l.Period, l.Used, l.Max)
+	}
+	return fmt.Sprintf("%s %s %s tokens %d of %d", l.Scope, l.ID, l.Period, int(l.Used), int(l.Max))
+}
+
+// Key identifies the limit within its period, e.g. to warn about it once per run.
+func (l Limit) Key() string {
+	return l.Scope + "/" + l.ID + "/" + l.Period + "/" + l.Unit
+}
+
+// ExceededError reports the limit a call would exceed.
+type ExceededError struct {
+	Limit Limit
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrExceeded, e.Limit)
+}
+
+func (e *ExceededError) Unwrap() error { return ErrExceeded }
+
+type spend struct {
+	tokens int
+	cost   float64
+}
+
+func (s *spend) add(rec llm.UsageRecord) {
+	s.tokens += rec.Tokens()
+	s.cost += rec.EstCostUSD
+}
+
+type scopeKey struct{ scope, id string }
+
+// Manager counts spend per scope and period and checks calls against the configured limits.
+type Manager struct {
+	mu      sync.Mutex
+	cfg     config.BudgetsConfig
+	usage   *llm.UsageTracker
+	now     func() time.Time
+	day     string // current local day and month; counters are reset when they change
+	month   string
+	daily   map[scopeKey]*spend
+	monthly map[scopeKey]*spend
+	runs    map[string]*spend
+}
+
+// New creates a Manager with the given limits, loads this month's spend from the usage log and
+// counts every call the tracker records from now on.
+func New(cfg config.BudgetsConfig, usage *llm.UsageTracker) (*Manager, error) {
+	m := &Manager{cfg: cfg, usage: usage, now: time.Now, runs: make(map[string]*spend)}
+	m.rollover(m.now())
+	if err := usage.Scan(m.add); err != nil {
+		return nil, fmt.Errorf("load usage: %w", err)
+	}
+	usage.OnRecord(m.add)
+	return m, nil
+}
+
+// SetConfig replaces the limits (config hot reload); spend is kept.
+func (m *Manager) SetConfig(cfg config.BudgetsConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+}
+
+// rollover resets the day and month counters when now is in a new period; m.mu must be held.
+func (m *Manager) rollover(now time.Time) {
+	if day := now.Format("2006-01-02"); day != m.day {
+		m.day = day
+		m.daily = make(map[scopeKey]*spend)
+	}
+	if month := now.Format("2006-01"); month != m.month {
+		m.month = month
+		m.monthly = make(map[scopeKey]*spend)
+	}
+}
+
+func (m *Manager) add(rec llm.UsageRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover(m.now())
+	ts := rec.Timestamp.Local()
+	for _, k := range scopeKeys(rec) {
+		if ts.Format("2006-01") == m.month {
+			counter(m.monthly, k).add(rec)
+		}
+		if ts.Format("2006-01-02") == m.day {
+			counter(m.daily, k).add(rec)
+		}
+	}
+	if s := m.runs[rec.RunID]; s != nil {
+		s.add(rec)
+	}
+}
+
+func counter(counters map[scopeKey]*spend, k scopeKey) *spend {
+	s := counters[k]
+	if s == nil {
+		s = &spend{}
+		counters[k] = s
+	}
+	return s
+}
+
+// scopeKeys returns the agent, channel and session a call counts against.
+func scopeKeys(rec llm.UsageRecord) []scopeKey {
+	channel := rec.Channel
+	if channel == "" {
+		channel, _, _ = strings.Cut(rec.SessionKey, ":")
+	}
+	var keys []scopeKey
+	if rec.Agent != "" {
+		keys = append(keys, scopeKey{ScopeAgent, rec.Agent})
+	}
+	if channel != "" {
+		keys = append(keys, scopeKey{ScopeChannel, channel})
+	}
+	if rec.SessionKey != "" {
+		keys = append(keys, scopeKey{ScopeSession, rec.SessionKey})
+	}
+	return keys
+}
+
+// limitsFor returns the configured limits of a scope, falling back to its "*" entry.
+func (m *Manager) limitsFor(k scopeKey) (config.BudgetLimits, bool) {
+	var byID map[string]config.BudgetLimits
+	switch k.scope {
+	case ScopeAgent:
+		byID = m.cfg.Agents
+	case ScopeChannel:
+		byID = m.cfg.Channels
+	case ScopeSession:
+		byID = m.cfg.Sessions
+	}
+	if l, ok := byID[k.id]; ok {
+		return l, true
+	}
+	l, ok := byID["*"]
+	return l, ok
+}
+
+// Check tests the next LLM call against every limit that applies to it. next carries the call's
+// attribution (RunID, Agent, Channel, SessionKey) and its estimated tokens (Provider and Model price them);
+// a zero estimate checks the spend so far. It returns the limits past the soft threshold, or an
+// *ExceededError if the call would reach a hard limit.
+func (m *Manager) Check(next llm.UsageRecord) ([]Limit, error) {
+	nextCost := 0.0
+	if next.Tokens() > 0 {
+		nextCost = m.usage.Cost(next)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover(m.now())
+	softRatio := m.cfg.SoftRatio
+	if softRatio <= 0 || softRatio >= 1 {
+		softRatio = DefaultSoftRatio
+	}
+
+	var run spend
+	if next.RunID != "" {
+		// the run is counted from its first check until EndRun
+		if s := m.runs[next.RunID]; s != nil {
+			run = *s
+		} else {
+			m.runs[next.RunID] = &spend{}
+		}
+	}
+	var warnings []Limit
+	for _, k := range scopeKeys(next) {
+		limits, ok := m.limitsFor(k)
+		if !ok {
+			continue
+		}
+		var day, month spend
+		if s := m.daily[k]; s != nil {
+			day = *s
+		}
+		if s := m.monthly[k]; s != nil {
+			month = *s
+		}
+		checks := []struct {
+			period string
+			spent  spend
+			tokens int
+			cost   float64
+		}{
+			{PeriodRun, run, limits.RunTokens, limits.RunCostUSD},
+			{PeriodDay, day, limits.DailyTokens, limits.DailyCostUSD},
+			{PeriodMonth, month, limits.MonthlyTokens, limits.MonthlyCostUSD},
+		}
+		for _, c := range checks {
+			if c.period == PeriodRun && next.RunID == "" {
+				continue
+			}
+			candidates := []Limit{
+				{Unit: "tokens", Max: float64(c.tokens), Used: float64(c.spent.tokens + next.Tokens())},
+				{Unit: "usd", Max: c.cost, Used: c.spent.cost + nextCost},
+			}
+			for _, l := range candidates {
+				if l.Max <= 0 {
+					continue
+				}
+				l.Scope, l.ID, l.Period = k.scope, k.id, c.period
+				if l.Used >= l.Max {
+					return warnings, &ExceededError{Limit: l}
+				}
+				if l.Used >= l.Max*softRatio {
+					warnings = append(warnings, l)
+				}
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// EndRun drops the spend of a finished run.
+func (m *Manager) EndRun(runID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.runs, runID)
+}
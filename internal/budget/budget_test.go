@@ -0,0 +1,71 @@
+package budget
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+func TestManagerCheck(t *testing.T) {
+	usage := llm.NewUsageTracker(t.TempDir())
+	usage.SetPricing(llm.Pricing{"m": {Input: 10, Output: 10}}) // $10 per 1M tokens
+	usage.Record(llm.UsageRecord{Timestamp: time.Now().AddDate(0, 0, -40), Model: "m", Agent: "default", SessionKey: "telegram:1", InputTokens: 900_000})
+	usage.Record(llm.UsageRecord{Model: "m", Agent: "default", SessionKey: "telegram:1", InputTokens: 70_000, OutputTokens: 5_000})
+
+	m, err := New(config.BudgetsConfig{
+		Channels: map[string]config.BudgetLimits{"telegram": {DailyTokens: 100_000}},
+		Agents:   map[string]config.BudgetLimits{"*": {MonthlyCostUSD: 2, RunTokens: 50_000}},
+	}, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 75k of the 100k daily tokens used; older records do not count
+	warnings, err := m.Check(llm.UsageRecord{Agent: "default", SessionKey: "telegram:1", Model: "m", InputTokens: 10_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Scope != ScopeChannel || warnings[0].Period != PeriodDay || warnings[0].Used != 85_000 {
+		t.Errorf("warnings = %+v", warnings)
+	}
+
+	// The next call would pass the daily limit
+	_, err = m.Check(llm.UsageRecord{Agent: "default", SessionKey: "telegram:2", Model: "m", InputTokens: 30_000})
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || !errors.Is(err, ErrExceeded) || exceeded.Limit.ID != "telegram" {
+		t.Fatalf("err = %v", err)
+	}
+
+	// Other channels only have the agent limits ("*"); the run limit counts the run's recorded calls
+	next := llm.UsageRecord{RunID: "run_1", Agent: "ops", SessionKey: "feishu:1", Model: "m", InputTokens: 20_000}
+	if _, err := m.Check(next); err != nil {
+		t.Fatal(err)
+	}
+	usage.Record(llm.UsageRecord{RunID: "run_1", Agent: "ops", SessionKey: "feishu:1", Model: "m", InputTokens: 40_000})
+	if _, err := m.Check(next); !errors.As(err, &exceeded) || exceeded.Limit.Period != PeriodRun {
+		t.Errorf("run limit: %v", err)
+	}
+	m.EndRun("run_1")
+	next.RunID = "run_2"
+	if _, err := m.Check(next); err != nil {
+		t.Errorf("new run: %v", err)
+	}
+
+	// Monthly cost: $0.75 spent by default this month
+	warnings, _ = m.Check(llm.UsageRecord{Agent: "default", SessionKey: "webchat:1", Model: "m", InputTokens: 10_000})
+	if len(warnings) != 0 {
+		t.Errorf("below soft ratio: %+v", warnings)
+	}
+	if _, err := m.Check(llm.UsageRecord{Agent: "default", SessionKey: "webchat:1", Model: "m", InputTokens: 130_000}); !errors.As(err, &exceeded) || exceeded.Limit.Unit != "usd" {
+		t.Errorf("monthly cost: %v", err)
+	}
+
+	// Hot reload
+	m.SetConfig(config.BudgetsConfig{})
+	if _, err := m.Check(llm.UsageRecord{Agent: "default", SessionKey: "telegram:1", Model: "m", InputTokens: 1_000_000}); err != nil {
+		t.Errorf("after removing limits: %v", err)
+	}
+}
@@ -39,6 +39,11 @@ func LogsDir() string {
 	return filepath.Join(Home(), "logs")
 }
 
+// CassettesDir 返回运行录制目录，固定为 home/logs/cassettes（每次运行一个 <runId>.jsonl）。
+func CassettesDir() string {
+	return filepath.Join(LogsDir(), "cassettes")
+}
+
 // TempDir 返回临时文件目录，固定为 home/tmp。供模型写入任务产生的临时文件；可被定期清理，勿放重要数据。
 func TempDir() string {
 	return filepath.Join(Home(), "tmp")
@@ -53,3 +58,8 @@ func StoreDir() string {
 func SkillsDir() string {
 	return filepath.Join(Workspace(), "skills")
 }
+
+// TokenizersDir 返回分词器词表目录，固定为 home/data/tokenizers（存放 <encoding>.tiktoken）。
+func TokenizersDir() string {
+	return filepath.Join(DataDir(), "tokenizers")
+}
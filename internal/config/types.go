@@ -6,6 +6,51 @@ type Config struct {
 	Providers map[string]ProviderConfig `yaml:"providers" json:"providers"`
 	Tools     ToolsConfig               `yaml:"tools" json:"tools"`
 	Bridges   BridgesConfig             `yaml:"bridges" json:"bridges"`
+	Debug     DebugConfig               `yaml:"debug" json:"debug"`
+	Pricing   map[string]ModelPrice     `yaml:"pricing" json:"pricing"` // 模型单价，键为 "provider/model" 或模型名（前缀匹配，如 claude-sonnet-4）；未配置的用内置价目表
+	Budgets   BudgetsConfig             `yaml:"budgets" json:"budgets"`
+	Models    map[string]ModelConfig    `yaml:"models" json:"models"` // 模型目录补充/覆盖，键同 pricing；未配置的用内置目录与 provider 发现结果
+}
+
+// ModelConfig 模型的能力与上限，覆盖内置目录与 discoverModels 的结果；0 / 空表示沿用。
+type ModelConfig struct {
+	ContextWindow   int      `yaml:"contextWindow" json:"contextWindow"`     // 上下文窗口 token 数；agent 未设 compaction.contextWindow 时使用
+	MaxOutputTokens int      `yaml:"maxOutputTokens" json:"maxOutputTokens"` // 单次最大输出 token 数；generation.maxTokens 超过时按此截断
+	Input           []string `yaml:"input" json:"input"`                     // 可接受的输入："text" | "image" | "pdf"；不含 image 时图片附件不发给模型
+	Tools           *bool    `yaml:"tools" json:"tools"`                     // 是否支持工具调用；false 时不发送工具定义
+	Reasoning       *bool    `yaml:"reasoning" json:"reasoning"`             // 是否支持思考/推理；false 时忽略 thinking 配置
+}
+
+// BudgetsConfig 用量预算：达到 softRatio 时发 budget_warning 事件，达到上限时拒绝调用模型。
+// 各 map 的键为 agent id / 渠道 / 会话键，"*" 表示未单独配置的每一个（各自计数）。
+type BudgetsConfig struct {
+	SoftRatio float64                 `yaml:"softRatio" json:"softRatio"` // 软阈值比例，默认 0.8
+	Agents    map[string]BudgetLimits `yaml:"agents" json:"agents"`       // 按 agent
+	Channels  map[string]BudgetLimits `yaml:"channels" json:"channels"`   // 按渠道（会话键前缀，如 telegram、webchat）
+	Sessions  map[string]BudgetLimits `yaml:"sessions" json:"sessions"`   // 按会话（如 telegram:12345）
+}
+
+// BudgetLimits 一组上限，0 表示不限。token 数含输入（含缓存）与输出；费用按 pricing 估算，单位美元；日、月按本地时间。
+type BudgetLimits struct {
+	RunTokens      int     `yaml:"runTokens" json:"runTokens"`           // 单次运行
+	DailyTokens    int     `yaml:"dailyTokens" json:"dailyTokens"`       // 每天
+	MonthlyTokens  int     `yaml:"monthlyTokens" json:"monthlyTokens"`   // 每月
+	RunCostUSD     float64 `yaml:"runCostUSD" json:"runCostUSD"`         // 单次运行
+	DailyCostUSD   float64 `yaml:"dailyCostUSD" json:"dailyCostUSD"`     // 每天
+	MonthlyCostUSD float64 `yaml:"monthlyCostUSD" json:"monthlyCostUSD"` // 每月
+}
+
+// ModelPrice 模型单价，美元 / 百万 token，用于 usage.jsonl 的费用估算。
+type ModelPrice struct {
+	Input      float64 `yaml:"input" json:"input"`           // 输入（未命中缓存）
+	Output     float64 `yaml:"output" json:"output"`         // 输出
+	CacheRead  float64 `yaml:"cacheRead" json:"cacheRead"`   // 缓存命中；0 = 按 input 计
+	CacheWrite float64 `yaml:"cacheWrite" json:"cacheWrite"` // 缓存写入（Anthropic）；0 = 按 input 计
+}
+
+// DebugConfig 调试选项。
+type DebugConfig struct {
+	RecordRuns bool `yaml:"recordRuns" json:"recordRuns"` // 把每次运行的模型请求/响应与工具结果录制到 logs/cassettes/<runId>.jsonl，可用 aido replay <runId> 复现
 }
 
 type BridgesConfig struct {
@@ -22,8 +67,10 @@ type BridgeInstanceConfig struct {
 type GatewayConfig struct {
 	Port         int        `yaml:"port" json:"port"`
 	Auth         AuthConfig `yaml:"auth" json:"auth"`
-	CurrentAgent string     `yaml:"currentAgent" json:"currentAgent"`   // 固定使用的 agent，空则可由请求指定
-	Locale       string     `yaml:"locale" json:"locale"`               // 系统提示词语言：en（英语）| zh（中文），默认 zh
+	CurrentAgent string     `yaml:"currentAgent" json:"currentAgent"` // 固定使用的 agent，空则可由请求指定
+	Locale       string     `yaml:"locale" json:"locale"`             // 系统提示词语言：en（英语）| zh（中文），默认 zh
+	// 缺失的分词器词表是否从 OpenAI 公开地址后台下载，默认关闭（不联网，可用 aido tokenizers fetch 手动下载）
+	FetchTokenizers bool `yaml:"fetchTokenizers" json:"fetchTokenizers"`
 }
 
 type AuthConfig struct {
@@ -32,27 +79,116 @@ type AuthConfig struct {
 
 type AgentConfig struct {
 	Provider   string           `yaml:"provider" json:"provider"`   // 绑定的 provider（providers 的 key）
-	Model      string           `yaml:"model" json:"model"`        // 模型 id（如 claude-sonnet-4-20250514）
+	Model      string           `yaml:"model" json:"model"`         // 模型 id（如 claude-sonnet-4-20250514）
+	Fallbacks  []string         `yaml:"fallbacks" json:"fallbacks"` // 备用模型（"provider/model"，或省略 provider 沿用主 provider），主模型不可用时按序切换
 	Tools      AgentToolsConfig `yaml:"tools" json:"tools"`
 	Compaction CompactionConfig `yaml:"compaction" json:"compaction"`
+	Thinking   ThinkingConfig   `yaml:"thinking" json:"thinking"`
+	Generation GenerationConfig `yaml:"generation" json:"generation"`
+	Ollama     OllamaConfig     `yaml:"ollama" json:"ollama"` // 仅 type: ollama 的 provider 生效
+}
+
+// GenerationConfig 生成参数；未设置的字段不下发，沿用服务端默认值。
+type GenerationConfig struct {
+	MaxTokens   int      `yaml:"maxTokens" json:"maxTokens"`     // 单次回复最大输出 token，0 表示默认（Anthropic 8192，OpenAI 兼容不下发）
+	Temperature *float64 `yaml:"temperature" json:"temperature"` // 采样温度；开启扩展思考时 Anthropic 忽略
+	TopP        *float64 `yaml:"topP" json:"topP"`               // 核采样；开启扩展思考时 Anthropic 忽略
+	Stop        []string `yaml:"stop" json:"stop"`               // 停止序列
+	Seed        *int     `yaml:"seed" json:"seed"`               // 随机种子（仅 OpenAI 兼容接口支持）
+	OnMaxTokens string   `yaml:"onMaxTokens" json:"onMaxTokens"` // 回复达到 maxTokens 被截断时："continue"（默认，自动续写）| "error"
+}
+
+// OllamaConfig Ollama 专用参数，原样透传到 /api/chat。
+type OllamaConfig struct {
+	NumCtx    int            `yaml:"numCtx" json:"numCtx"`       // 上下文长度（options.num_ctx），0 表示模型默认
+	KeepAlive string         `yaml:"keepAlive" json:"keepAlive"` // 模型常驻时长，如 "10m"；"-1" 表示常驻
+	Options   map[string]any `yaml:"options" json:"options"`     // 其他 options.*（如 num_gpu、repeat_penalty），优先级最高
+}
+
+// ThinkingConfig 扩展思考（Anthropic extended thinking）；budgetTokens 为 0 表示关闭。
+type ThinkingConfig struct {
+	BudgetTokens int `yaml:"budgetTokens" json:"budgetTokens"` // 思考预算 token 数，最小 1024
 }
 
 type AgentToolsConfig struct {
-	Allow []string `yaml:"allow" json:"allow"`
-	Deny  []string `yaml:"deny" json:"deny"`
+	Allow    []string `yaml:"allow" json:"allow"`       // 允许的工具：glob（如 "web_*"、"github:*"）或工具组（group:fs、group:mcp:<服务名> 等），空表示全部
+	Deny     []string `yaml:"deny" json:"deny"`         // 禁止的工具，写法同 allow，优先于 allow
+	Choice   string   `yaml:"choice" json:"choice"`     // 工具调用策略："auto"（默认）| "none"（不调用工具）| "required"（必须调用）| 工具名（必须调用该工具）；后两者只约束每轮对话的第一次工具调用
+	Parallel *bool    `yaml:"parallel" json:"parallel"` // 是否允许一次回复中并行调用多个工具；不填沿用服务端默认（允许）
+
+	MaxConcurrency int      `yaml:"maxConcurrency" json:"maxConcurrency"` // 同一条回复中的工具调用最多同时执行几个，0 表示默认 4，1 表示逐个执行
+	Serial         []string `yaml:"serial" json:"serial"`                 // 需逐个执行的工具名（如有副作用的 MCP 工具）；内置的 write_file、edit_file、exec、cron_add、cron_remove 默认逐个执行
+
+	Approval ApprovalConfig `yaml:"approval" json:"approval"` // 工具调用的人工审批
+}
+
+// ApprovalConfig 工具调用审批：按顺序匹配 rules，第一条命中的规则决定动作，都不命中用 default。
+// 动作为 "ask" 时运行暂停并发出 approval_request 事件，等待客户端或桥接通过 tool.approve 批准或拒绝。
+type ApprovalConfig struct {
+	Default string         `yaml:"default" json:"default"` // 未命中规则时的动作："always"（默认，直接执行）| "ask"（需人工批准）| "never"（直接拒绝）
+	Timeout string         `yaml:"timeout" json:"timeout"` // 等待审批的时长，如 "10m"，空表示默认 5m；超时视为拒绝
+	Rules   []ApprovalRule `yaml:"rules" json:"rules"`
+}
+
+// ApprovalRule 一条审批规则。
+type ApprovalRule struct {
+	Tool   string            `yaml:"tool" json:"tool"`     // 工具名 glob 或工具组，写法同 allow（如 "exec"、"group:fs"、"github:*"）
+	Args   map[string]string `yaml:"args" json:"args"`     // 可选：参数名 → 正则，全部匹配才命中（如 command: "^git (status|log)"）；非字符串参数按 JSON 文本匹配
+	Action string            `yaml:"action" json:"action"` // "always" | "ask" | "never"
 }
 
 type CompactionConfig struct {
-	ContextWindow    int     `yaml:"contextWindow" json:"contextWindow"`       // 模型上下文上限 token 数，0 表示用默认 200000
+	ContextWindow    int     `yaml:"contextWindow" json:"contextWindow"` // 模型上下文上限 token 数，0 表示用默认 200000
 	KeepRecentTokens int     `yaml:"keepRecentTokens" json:"keepRecentTokens"`
 	ReserveTokens    int     `yaml:"reserveTokens" json:"reserveTokens"`
 	ChunkRatio       float64 `yaml:"chunkRatio" json:"chunkRatio"`
 }
 
 type ProviderConfig struct {
-	APIKey  string `yaml:"apiKey" json:"apiKey"`
-	BaseURL string `yaml:"baseURL" json:"baseURL"`
-	Type    string `yaml:"type" json:"type"` // "openai" | "anthropic" (default: inferred from provider name)
+	APIKey  string      `yaml:"apiKey" json:"apiKey"`
+	BaseURL string      `yaml:"baseURL" json:"baseURL"`
+	Type    string      `yaml:"type" json:"type"` // "openai" | "openai-responses" | "azure-openai" | "anthropic" | "gemini" | "ollama" | "mock" (default: inferred from provider name)
+	Retry   RetryConfig `yaml:"retry" json:"retry"`
+
+	CheckModels    bool `yaml:"checkModels" json:"checkModels"`       // 仅 ollama：启动时列出本地模型，agent 引用的模型不存在则启动失败
+	ChainResponses bool `yaml:"chainResponses" json:"chainResponses"` // 仅 openai-responses：用 previous_response_id 只发送新增消息（需服务端存储响应），失败时自动回退为完整发送
+	DiscoverModels bool `yaml:"discoverModels" json:"discoverModels"` // 启动及配置热更新时拉取模型列表（/v1/models 等）补充模型目录，如上下文长度、输入类型
+
+	Tokenizer string `yaml:"tokenizer" json:"tokenizer"` // 估算 token 用的分词器："auto"（默认，按模型名选择）| "cl100k_base" | "o200k_base" | "heuristic"
+	Script    string `yaml:"script" json:"script"`       // 仅 mock：回放脚本（YAML/JSON）路径，相对路径基于 home
+
+	Azure AzureConfig `yaml:"azure" json:"azure"` // 仅 azure-openai：baseURL 填 https://<资源名>.openai.azure.com
+
+	// 网络传输：每个 provider 使用独立的 HTTP 客户端（连接复用），配置热更新后重建
+	Headers            map[string]string `yaml:"headers" json:"headers"`                       // 每个请求附加的 HTTP 头（如 X-Org、OpenRouter 的 HTTP-Referer / X-Title），覆盖同名默认头
+	Proxy              string            `yaml:"proxy" json:"proxy"`                           // 代理 URL（http/https/socks5），空则使用 HTTPS_PROXY 等环境变量，"direct" 表示不走代理
+	ConnectTimeout     string            `yaml:"connectTimeout" json:"connectTimeout"`         // 建连 + TLS 握手超时，如 "10s"，空表示默认 30s
+	RequestTimeout     string            `yaml:"requestTimeout" json:"requestTimeout"`         // 单次请求总超时（含流式输出全过程），如 "5m"，空表示不限制
+	StreamIdleTimeout  string            `yaml:"streamIdleTimeout" json:"streamIdleTimeout"`   // 流式响应多久收不到任何数据即中止，空表示默认 2m，"off" 关闭；尚未输出内容时自动重试
+	CAFile             string            `yaml:"caFile" json:"caFile"`                         // 额外信任的 CA 证书（PEM），相对路径基于 home
+	InsecureSkipVerify bool              `yaml:"insecureSkipVerify" json:"insecureSkipVerify"` // 跳过 TLS 证书校验，仅用于测试
+}
+
+// AzureConfig Azure OpenAI 设置；默认用 apiKey 以 api-key 头认证，配置 entraId 后改用 Entra ID 令牌。
+type AzureConfig struct {
+	APIVersion  string            `yaml:"apiVersion" json:"apiVersion"`   // api-version 参数，空表示默认 2024-10-21
+	Deployments map[string]string `yaml:"deployments" json:"deployments"` // 模型名 → 部署名；未列出的模型直接以模型名作为部署名
+	EntraID     *EntraIDConfig    `yaml:"entraId" json:"entraId"`         // 可选：Entra ID 应用（客户端凭据）认证
+}
+
+// EntraIDConfig Microsoft Entra ID 应用注册（client credentials），需在资源上授予 Cognitive Services OpenAI User 角色。
+type EntraIDConfig struct {
+	TenantID      string `yaml:"tenantId" json:"tenantId"`
+	ClientID      string `yaml:"clientId" json:"clientId"`
+	ClientSecret  string `yaml:"clientSecret" json:"clientSecret"`
+	AuthorityHost string `yaml:"authorityHost" json:"authorityHost"` // 登录地址，空表示 https://login.microsoftonline.com（主权云需修改）
+}
+
+// RetryConfig 控制限流（429）、过载（503/529）与 5xx 错误的重试；均为 0 时使用默认值。
+type RetryConfig struct {
+	MaxRetries     int `yaml:"maxRetries" json:"maxRetries"`         // 最大重试次数，0 表示默认 3，负数表示不重试
+	InitialDelayMs int `yaml:"initialDelayMs" json:"initialDelayMs"` // 首次退避毫秒数，0 表示默认 1000
+	MaxDelayMs     int `yaml:"maxDelayMs" json:"maxDelayMs"`         // 单次等待上限毫秒数，0 表示默认 30000；服务端要求的 Retry-After 超过它时不再重试，直接报错
 }
 
 // ClientType returns which LLM client to use for this provider.
@@ -60,8 +196,11 @@ func (p ProviderConfig) ClientType(providerName string) string {
 	if p.Type != "" {
 		return p.Type
 	}
-	if providerName == "anthropic" {
-		return "anthropic"
+	switch providerName {
+	case "anthropic", "gemini", "ollama", "mock":
+		return providerName
+	case "azure":
+		return "azure-openai"
 	}
 	return "openai"
 }
@@ -2,13 +2,19 @@ package gateway
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lhdbsbz/aido/internal/agent"
 	"github.com/lhdbsbz/aido/internal/bridge"
+	"github.com/lhdbsbz/aido/internal/budget"
 	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
 )
 
 const apiPrefix = "/api"
@@ -36,6 +42,10 @@ func (s *Server) registerAPIRoutes(engine *gin.Engine) {
 	api.GET("/chat/history", s.ginAPIChatHistory)
 	api.POST("/chat/send", s.ginAPIChatSend)
 	api.GET("/bridges", s.ginAPIBridges)
+	api.GET("/usage", s.ginAPIUsage)
+	api.GET("/models", s.ginAPIModels)
+	api.GET("/approvals", s.ginAPIApprovals)
+	api.POST("/approvals/:id", s.ginAPIApprovalResolve)
 }
 
 func (s *Server) ginAPIHealth(c *gin.Context) {
@@ -103,6 +113,10 @@ func (s *Server) ginAPIChatSend(c *gin.Context) {
 		ChannelChatID  string            `json:"channelChatId"`
 		Text           string            `json:"text"`
 		Attachments    []AttachmentParam `json:"attachments,omitempty"`
+		ResponseFormat *ResponseFormatParam `json:"responseFormat,omitempty"`
+
+		ToolChoice        ToolChoiceParam `json:"toolChoice,omitempty"`
+		ParallelToolCalls *bool           `json:"parallelToolCalls,omitempty"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -124,10 +138,14 @@ func (s *Server) ginAPIChatSend(c *gin.Context) {
 		ChannelChatID:  body.ChannelChatID,
 		Text:           body.Text,
 		Attachments:    body.Attachments,
+		ResponseFormat: body.ResponseFormat,
+
+		ToolChoice:        body.ToolChoice,
+		ParallelToolCalls: body.ParallelToolCalls,
 	}
 	result, err := s.handleMessageSend(c.Request.Context(), nil, mustMarshal(params))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(runErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, result)
@@ -169,6 +187,126 @@ func (s *Server) ginAPIBridges(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"bridges": list})
 }
 
+// ginAPIUsage aggregates the usage log: ?from=2025-10-01&to=2025-10-31&groupBy=day,channel&agent=&channel=&model=
+// from/to are inclusive local dates (or RFC 3339 times, to exclusive); groupBy defaults to day.
+func (s *Server) ginAPIUsage(c *gin.Context) {
+	if s.Usage == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "usage tracking disabled"})
+		return
+	}
+	q := llm.UsageQuery{
+		Agent:   c.Query("agent"),
+		Channel: c.Query("channel"),
+		Model:   c.Query("model"),
+		GroupBy: []string{"day"},
+	}
+	var err error
+	if q.From, err = parseUsageTime(c.Query("from"), false); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from: " + err.Error()})
+		return
+	}
+	if q.To, err = parseUsageTime(c.Query("to"), true); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "to: " + err.Error()})
+		return
+	}
+	if g := c.Query("groupBy"); g != "" {
+		q.GroupBy = nil
+		for _, name := range strings.Split(g, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				q.GroupBy = append(q.GroupBy, name)
+			}
+		}
+	}
+	groups, totals, err := s.Usage.Query(q)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"groupBy": q.GroupBy, "groups": groups, "totals": totals})
+}
+
+// ginAPIModels lists the model catalog (?provider= keeps that provider's discovered models) and the
+// catalog entry of every agent's model.
+func (s *Server) ginAPIModels(c *gin.Context) {
+	if s.Models == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "model catalog disabled"})
+		return
+	}
+	agents := make(map[string]llm.ModelInfo)
+	if cfg := config.Get(); cfg != nil {
+		for id, agentCfg := range cfg.Agents {
+			if provider, model, _, err := config.ResolveProviderForAgent(cfg, &agentCfg); err == nil {
+				agents[id], _ = s.Models.Lookup(provider, model)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"models": s.Models.List(c.Query("provider")), "agents": agents})
+}
+
+// ginAPIApprovals lists the tool calls waiting for approval, oldest first.
+func (s *Server) ginAPIApprovals(c *gin.Context) {
+	approvals := s.Router.Approvals()
+	if approvals == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": errApprovalsDisabled.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"approvals": approvals.Pending()})
+}
+
+// ginAPIApprovalResolve approves or denies a pending tool call: {"decision": "approve"|"deny", "reason": "", "by": ""}.
+func (s *Server) ginAPIApprovalResolve(c *gin.Context) {
+	var body struct {
+		Decision string `json:"decision"`
+		Reason   string `json:"reason,omitempty"`
+		By       string `json:"by,omitempty"` // who decided; default "api"
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if body.By == "" {
+		body.By = "api"
+	}
+	result, err := s.resolveApproval(c.Param("id"), body.Decision, body.By, body.Reason, "")
+	switch {
+	case errors.Is(err, agent.ErrApprovalNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errApprovalsDisabled):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// parseUsageTime parses a local date (YYYY-MM-DD) or an RFC 3339 time; a date used as end bound
+// includes the whole day.
+func parseUsageTime(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want YYYY-MM-DD or RFC 3339, got %q", v)
+	}
+	return t, nil
+}
+
+// runErrorStatus returns the HTTP status of a failed agent run: 429 when a budget refused it, 500 otherwise.
+func runErrorStatus(err error) int {
+	if errors.Is(err, budget.ErrExceeded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
 func mustMarshal(v any) []byte {
 	b, _ := json.Marshal(v)
 	return b
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -49,7 +50,7 @@ func validateAndConvertAttachments(in []AttachmentParam) ([]agent.Attachment, er
 				return nil, fmt.Errorf("attachment %d: base64 too large (max %d bytes)", i+1, maxAttachmentBase64Bytes)
 			}
 		}
-		out = append(out, agent.Attachment{Type: typ, URL: strings.TrimSpace(a.URL), Base64: a.Base64, MIME: strings.TrimSpace(a.MIME)})
+		out = append(out, agent.Attachment{Type: typ, Name: strings.TrimSpace(a.Name), URL: strings.TrimSpace(a.URL), Base64: a.Base64, MIME: strings.TrimSpace(a.MIME)})
 	}
 	return out, nil
 }
@@ -73,6 +74,10 @@ func (s *Server) handleMessageSend(ctx context.Context, conn *Conn, params json.
 	if err != nil {
 		return nil, err
 	}
+	responseSchema, err := p.ResponseFormat.toResponseSchema()
+	if err != nil {
+		return nil, err
+	}
 
 	channel, channelChatId := p.Channel, p.ChannelChatID
 
@@ -88,13 +93,17 @@ func (s *Server) handleMessageSend(ctx context.Context, conn *Conn, params json.
 		s.Conns.BroadcastToChannel(channel, "agent", payload)
 	}
 
-	result, toolSteps, err := s.Router.HandleMessage(ctx, agent.InboundMessage{
-		Channel:     channel,
-		ChatID:      channelChatId,
-		SenderID:    p.SenderID,
-		Text:        p.Text,
-		Attachments: attachments,
-		MessageID:   p.MessageID,
+	result, parsed, toolSteps, err := s.runAgent(ctx, agent.InboundMessage{
+		Channel:        channel,
+		ChatID:         channelChatId,
+		SenderID:       p.SenderID,
+		Text:           p.Text,
+		Attachments:    attachments,
+		MessageID:      p.MessageID,
+		ResponseSchema: responseSchema,
+
+		ToolChoice:        p.ToolChoice.toToolChoice(),
+		ParallelToolCalls: p.ParallelToolCalls,
 	}, eventSink)
 	if err != nil {
 		return nil, err
@@ -107,6 +116,9 @@ func (s *Server) handleMessageSend(ctx context.Context, conn *Conn, params json.
 	})
 
 	out := map[string]any{"text": result}
+	if parsed != nil {
+		out["json"] = parsed
+	}
 	if len(toolSteps) > 0 {
 		out["toolSteps"] = toolSteps
 	}
@@ -133,16 +145,88 @@ func agentEventPayload(evt agent.Event, channel, channelChatId string) map[strin
 	if evt.Error != "" {
 		m["error"] = evt.Error
 	}
+	if evt.ErrorKind != "" {
+		m["errorKind"] = evt.ErrorKind
+	}
+	if evt.Type == agent.EventTypeRetry || evt.Type == agent.EventTypeContinue {
+		m["attempt"] = evt.Attempt
+		m["maxAttempts"] = evt.MaxAttempts
+		m["retryDelayMs"] = evt.RetryDelayMs
+	}
 	if evt.TotalTokensIn > 0 || evt.TotalTokensOut > 0 {
 		m["totalTokensIn"] = evt.TotalTokensIn
 		m["totalTokensOut"] = evt.TotalTokensOut
 	}
+	if evt.CacheReadTokens > 0 || evt.CacheCreationTokens > 0 {
+		m["cacheReadTokens"] = evt.CacheReadTokens
+		m["cacheCreationTokens"] = evt.CacheCreationTokens
+	}
 	if evt.Iterations > 0 {
 		m["iterations"] = evt.Iterations
 	}
+	if evt.Model != "" {
+		m["model"] = evt.Model
+	}
+	if evt.Fallback {
+		m["fallback"] = true
+	}
+	if evt.Budget != nil {
+		m["budget"] = evt.Budget
+	}
+	if evt.Approval != nil {
+		m["approval"] = evt.Approval
+	}
 	return m
 }
 
+var errApprovalsDisabled = errors.New("tool approvals disabled")
+
+// handleToolApprove resolves a pending approval. The decider is recorded as "<channel>:<senderId>"
+// for bridges and "client:<senderId>" for clients (the senderId part only if given).
+func (s *Server) handleToolApprove(ctx context.Context, conn *Conn, params json.RawMessage) (any, error) {
+	var p ToolApproveParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
+	}
+	by := RoleClient
+	channel := ""
+	if conn != nil && conn.Role == RoleBridge {
+		by, channel = conn.Channel, conn.Channel
+	}
+	if p.SenderID != "" {
+		by += ":" + p.SenderID
+	}
+	return s.resolveApproval(p.ApprovalID, p.Decision, by, p.Reason, channel)
+}
+
+// resolveApproval applies a decision ("approve" | "deny") to a pending approval; a non-empty channel
+// restricts it to approvals of that channel's sessions.
+func (s *Server) resolveApproval(id, decision, by, reason, channel string) (any, error) {
+	approvals := s.Router.Approvals()
+	if approvals == nil {
+		return nil, errApprovalsDisabled
+	}
+	if id == "" {
+		return nil, fmt.Errorf("approvalId required")
+	}
+	if decision != "approve" && decision != "deny" {
+		return nil, fmt.Errorf("decision must be approve or deny")
+	}
+	if channel != "" {
+		a, ok := approvals.Get(id)
+		if !ok {
+			return nil, agent.ErrApprovalNotFound
+		}
+		if c, _ := parseChannelChatId(a.SessionKey); c != channel {
+			return nil, agent.ErrApprovalNotFound
+		}
+	}
+	if err := approvals.Resolve(id, decision == "approve", by, reason); err != nil {
+		return nil, err
+	}
+	return map[string]any{"approvalId": id, "decision": decision}, nil
+}
+
 func (s *Server) getChatHistory(ctx context.Context, channel, channelChatId string) (any, error) {
 	storageKey := SessionKey(channel, channelChatId)
 	entry := s.Router.Store().Get(storageKey)
@@ -159,11 +243,28 @@ func (s *Server) getChatHistory(ctx context.Context, channel, channelChatId stri
 		if len(msg.ToolCalls) > 0 {
 			m["toolCalls"] = msg.ToolCalls
 		}
+		if thinking := thinkingText(msg.Thinking); thinking != "" {
+			m["thinking"] = thinking
+		}
+		if msg.Reasoning != "" {
+			m["reasoning"] = msg.Reasoning
+		}
 		simplified = append(simplified, m)
 	}
 	return map[string]any{"messages": simplified}, nil
 }
 
+// thinkingText joins the readable parts of an assistant message's thinking blocks for display.
+func thinkingText(blocks []llmpkg.ThinkingBlock) string {
+	var parts []string
+	for _, b := range blocks {
+		if b.Thinking != "" {
+			parts = append(parts, b.Thinking)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
 func (s *Server) handleChatHistory(ctx context.Context, conn *Conn, params json.RawMessage) (any, error) {
 	var p struct {
 		Channel        string `json:"channel"`
@@ -189,13 +290,17 @@ func (s *Server) handleSessionsList(ctx context.Context, conn *Conn, params json
 	for _, e := range entries {
 		channel, channelChatId := parseChannelChatId(e.SessionKey)
 		sessions = append(sessions, map[string]any{
-			"channel":        channel,
-			"channelChatId": channelChatId,
-			"createdAt":     e.CreatedAt,
-			"updatedAt":     e.UpdatedAt,
-			"inputTokens":   e.InputTokens,
-			"outputTokens":  e.OutputTokens,
-			"compactions":   e.Compactions,
+			"channel":             channel,
+			"channelChatId":       channelChatId,
+			"createdAt":           e.CreatedAt,
+			"updatedAt":           e.UpdatedAt,
+			"inputTokens":         e.InputTokens,
+			"outputTokens":        e.OutputTokens,
+			"cacheReadTokens":     e.CacheReadTokens,
+			"cacheCreationTokens": e.CacheCreationTokens,
+			"compactions":         e.Compactions,
+			"lastModel":           e.LastModel,
+			"fallbacks":           e.Fallbacks,
 		})
 	}
 	return map[string]any{"sessions": sessions}, nil
@@ -242,10 +347,14 @@ func configForUIFromCfg(cfg *config.Config) map[string]any {
 		"agents":   cfg.Agents,
 		"tools":    cfg.Tools,
 		"bridges":  cfg.Bridges,
+		"debug":   cfg.Debug,
+		"pricing": cfg.Pricing,
+		"budgets": cfg.Budgets,
+		"models":  cfg.Models,
 	}
 	providers := make(map[string]any)
 	for k, p := range cfg.Providers {
-		providers[k] = map[string]any{"apiKey": p.APIKey, "baseURL": p.BaseURL, "type": p.Type}
+		providers[k] = p
 	}
 	out["providers"] = providers
 	return out
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -12,7 +13,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/lhdbsbz/aido/internal/agent"
+	"github.com/lhdbsbz/aido/internal/budget"
 	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
 )
 
 // RegisterOpenAICompat registers OpenAI-compatible /v1/chat/completions on the Gin engine.
@@ -27,6 +30,10 @@ type openAIContentPart struct {
 	ImageURL *struct {
 		URL string `json:"url"`
 	} `json:"image_url"`
+	File *struct {
+		Filename string `json:"filename"`
+		FileData string `json:"file_data"` // data URL
+	} `json:"file"`
 }
 
 func parseOpenAIUserMessage(content json.RawMessage) (text string, attachments []agent.Attachment, err error) {
@@ -70,6 +77,25 @@ func parseOpenAIUserMessage(content json.RawMessage) (text string, attachments [
 			} else {
 				attachments = append(attachments, agent.Attachment{Type: "image", URL: url})
 			}
+		case "file":
+			if p.File == nil || p.File.FileData == "" {
+				return "", nil, fmt.Errorf("content part %d: file missing file_data", i+1)
+			}
+			if len(attachments) >= maxAttachmentsPerMessage {
+				return "", nil, fmt.Errorf("too many attachments: max %d", maxAttachmentsPerMessage)
+			}
+			base64Str, mime, err := parseDataURL(strings.TrimSpace(p.File.FileData))
+			if err != nil {
+				return "", nil, fmt.Errorf("content part %d: %w", i+1, err)
+			}
+			decoded, err := base64.StdEncoding.DecodeString(base64Str)
+			if err != nil {
+				return "", nil, fmt.Errorf("content part %d: invalid base64 in file_data: %w", i+1, err)
+			}
+			if len(decoded) > maxAttachmentBase64Bytes {
+				return "", nil, fmt.Errorf("content part %d: file too large (max %d bytes)", i+1, maxAttachmentBase64Bytes)
+			}
+			attachments = append(attachments, agent.Attachment{Type: "file", Name: p.File.Filename, Base64: base64Str, MIME: mime})
 		default:
 			// ignore unknown part types
 		}
@@ -134,6 +160,13 @@ func (s *Server) ginOpenAIChatCompletions(c *gin.Context) {
 		})
 		return
 	}
+	responseSchema, err := req.ResponseFormat.toResponseSchema()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{"message": err.Error(), "type": "invalid_request"},
+		})
+		return
+	}
 
 	cfg := config.Get()
 	if cfg == nil {
@@ -152,31 +185,40 @@ func (s *Server) ginOpenAIChatCompletions(c *gin.Context) {
 		sessionKey = fmt.Sprintf("openai:%s:%d", agentID, time.Now().UnixMilli())
 	}
 
+	msg := agent.InboundMessage{
+		AgentID:        agentID,
+		Channel:        "openai",
+		ChatID:         sessionKey,
+		Text:           userText,
+		Attachments:    userAttachments,
+		ResponseSchema: responseSchema,
+
+		ToolChoice:        req.ToolChoice.toToolChoice(),
+		ParallelToolCalls: req.ParallelToolCalls,
+	}
 	if req.Stream {
-		s.handleOpenAIStream(c, agentID, sessionKey, userText, userAttachments)
+		s.handleOpenAIStream(c, agentID, msg)
 	} else {
-		s.handleOpenAISync(c, agentID, sessionKey, userText, userAttachments)
+		s.handleOpenAISync(c, agentID, msg)
 	}
 }
 
-func (s *Server) handleOpenAISync(c *gin.Context, agentID, sessionKey, userText string, attachments []agent.Attachment) {
+func (s *Server) handleOpenAISync(c *gin.Context, agentID string, msg agent.InboundMessage) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
 	defer cancel()
 
-	result, _, err := s.Router.HandleMessage(ctx, agent.InboundMessage{
-		AgentID:     agentID,
-		Channel:     "openai",
-		ChatID:      sessionKey,
-		Text:        userText,
-		Attachments: attachments,
-	}, nil)
+	result, parsed, _, err := s.runAgent(ctx, msg, nil)
 	if err != nil {
 		slog.Error("openai compat error", "error", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{"message": err.Error(), "type": "server_error"},
+		c.AbortWithStatusJSON(runErrorStatus(err), gin.H{
+			"error": openAIServerError(err),
 		})
 		return
 	}
+	if parsed != nil {
+		// Structured output: return the validated JSON without fences or surrounding prose.
+		result = string(parsed)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli()),
@@ -193,7 +235,7 @@ func (s *Server) handleOpenAISync(c *gin.Context, agentID, sessionKey, userText
 	})
 }
 
-func (s *Server) handleOpenAIStream(c *gin.Context, agentID, sessionKey, userText string, attachments []agent.Attachment) {
+func (s *Server) handleOpenAIStream(c *gin.Context, agentID string, msg agent.InboundMessage) {
 	flusher, ok := c.Writer.(http.Flusher)
 	if !ok {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
@@ -213,56 +255,66 @@ func (s *Server) handleOpenAIStream(c *gin.Context, agentID, sessionKey, userTex
 
 	completionID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli())
 
+	writeContent := func(text string) {
+		chunk := map[string]any{
+			"id":      completionID,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   agentID,
+			"choices": []map[string]any{
+				{
+					"index": 0,
+					"delta": map[string]any{"content": text},
+				},
+			},
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	writeDone := func() {
+		doneChunk := map[string]any{
+			"id":      completionID,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   agentID,
+			"choices": []map[string]any{
+				{
+					"index":         0,
+					"delta":         map[string]any{},
+					"finish_reason": "stop",
+				},
+			},
+		}
+		data, _ := json.Marshal(doneChunk)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+		fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
+		flusher.Flush()
+	}
+
+	// With a response schema the answer may be retried, so it is sent once validated instead of streamed.
+	structured := msg.ResponseSchema != nil
 	eventSink := func(evt agent.Event) {
+		if structured {
+			return
+		}
 		if evt.Type == agent.EventTypeTextDelta && evt.Text != "" {
-			chunk := map[string]any{
-				"id":      completionID,
-				"object":  "chat.completion.chunk",
-				"created": time.Now().Unix(),
-				"model":   agentID,
-				"choices": []map[string]any{
-					{
-						"index": 0,
-						"delta": map[string]any{"content": evt.Text},
-					},
-				},
-			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
-			flusher.Flush()
+			writeContent(evt.Text)
 		}
 		if evt.Type == agent.EventTypeDone {
-			doneChunk := map[string]any{
-				"id":      completionID,
-				"object":  "chat.completion.chunk",
-				"created": time.Now().Unix(),
-				"model":   agentID,
-				"choices": []map[string]any{
-					{
-						"index":         0,
-						"delta":         map[string]any{},
-						"finish_reason": "stop",
-					},
-				},
-			}
-			data, _ := json.Marshal(doneChunk)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
-			fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-			flusher.Flush()
+			writeDone()
 		}
 	}
 
-	_, _, err := s.Router.HandleMessage(ctx, agent.InboundMessage{
-		AgentID:     agentID,
-		Channel:     "openai",
-		ChatID:      sessionKey,
-		Text:        userText,
-		Attachments: attachments,
-	}, eventSink)
+	_, parsed, _, err := s.runAgent(ctx, msg, eventSink)
+	if err == nil && structured {
+		writeContent(string(parsed))
+		writeDone()
+	}
 	if err != nil {
 		slog.Error("openai stream error", "error", err)
 		errChunk := map[string]any{
-			"error": map[string]any{"message": err.Error(), "type": "server_error"},
+			"error": openAIServerError(err),
 		}
 		data, _ := json.Marshal(errChunk)
 		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
@@ -270,6 +322,19 @@ func (s *Server) handleOpenAIStream(c *gin.Context, agentID, sessionKey, userTex
 	}
 }
 
+// openAIServerError formats a failed agent run; code carries the provider error kind when known.
+func openAIServerError(err error) gin.H {
+	e := gin.H{"message": err.Error(), "type": "server_error"}
+	if errors.Is(err, budget.ErrExceeded) {
+		e["type"], e["code"] = "insufficient_quota", agent.ErrorKindBudget
+		return e
+	}
+	if kind := llm.ErrorKindOf(err); kind != "" {
+		e["code"] = kind
+	}
+	return e
+}
+
 // OpenAI API types
 
 type openAIRequest struct {
@@ -277,6 +342,12 @@ type openAIRequest struct {
 	Messages []openAIMessage `json:"messages"`
 	Stream   bool            `json:"stream"`
 	User     string          `json:"user,omitempty"`
+
+	ResponseFormat *ResponseFormatParam `json:"response_format,omitempty"`
+
+	// Choose among the agent's tools; tools sent by the caller are not supported.
+	ToolChoice        ToolChoiceParam `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
 }
 
 type openAIMessage struct {
@@ -1,6 +1,13 @@
 package gateway
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/lhdbsbz/aido/internal/budget"
+	"github.com/lhdbsbz/aido/internal/llm"
+)
 
 // Frame is the universal WebSocket message format.
 // Three types: "req" (client→server), "res" (server→client), "event" (server→client push).
@@ -46,10 +53,25 @@ type MessageSendParams struct {
 	SenderID     string            `json:"senderId,omitempty"`
 	MessageID    string            `json:"messageId,omitempty"`
 	Attachments  []AttachmentParam `json:"attachments,omitempty"`
+
+	ResponseFormat *ResponseFormatParam `json:"responseFormat,omitempty"` // optional: structured output (OpenAI response_format shape)
+
+	ToolChoice        ToolChoiceParam `json:"toolChoice,omitempty"`        // optional: overrides the agent's tools.choice (OpenAI tool_choice shape)
+	ParallelToolCalls *bool           `json:"parallelToolCalls,omitempty"` // optional: overrides the agent's tools.parallel
+}
+
+// ToolApproveParams answers an approval_request event (tool.approve). Bridges may only answer
+// approvals of their own channel.
+type ToolApproveParams struct {
+	ApprovalID string `json:"approvalId"`
+	Decision   string `json:"decision"`           // "approve" | "deny"
+	Reason     string `json:"reason,omitempty"`   // optional: shown to the model with the tool result
+	SenderID   string `json:"senderId,omitempty"` // optional: who decided, e.g. the chat user id
 }
 
 type AttachmentParam struct {
-	Type   string `json:"type"`   // "image" | "audio" | "video" | "file"
+	Type   string `json:"type"`           // "image" | "audio" | "video" | "file"
+	Name   string `json:"name,omitempty"` // file name, e.g. "report.pdf"
 	URL    string `json:"url,omitempty"`
 	Base64 string `json:"base64,omitempty"`
 	MIME   string `json:"mime,omitempty"`
@@ -68,6 +90,21 @@ func ResErr(id string, code, message string) Frame {
 	return Frame{Type: "res", ID: id, OK: &ok, Error: &ErrorPayload{Code: code, Message: message}}
 }
 
+// errorCode returns the res error code for a failed agent run: "LLM_<KIND>" (e.g. "LLM_CONTEXT_LENGTH")
+// for classified provider errors, "BUDGET_EXCEEDED" for runs refused by a budget, "ERROR" otherwise.
+func errorCode(err error) string {
+	if errors.Is(err, budget.ErrExceeded) {
+		return "BUDGET_EXCEEDED"
+	}
+	if errors.Is(err, llm.ErrStreamStalled) {
+		return "LLM_STREAM_STALLED"
+	}
+	if kind := llm.ErrorKindOf(err); kind != "" {
+		return "LLM_" + strings.ToUpper(string(kind))
+	}
+	return "ERROR"
+}
+
 func EventFrame(event string, seq int, payload any) Frame {
 	data, _ := json.Marshal(payload)
 	return Frame{Type: "event", Event: event, Seq: seq, Payload: data}
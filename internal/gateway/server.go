@@ -4,6 +4,7 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
@@ -16,6 +17,7 @@ import (
 	"github.com/lhdbsbz/aido/internal/agent"
 	"github.com/lhdbsbz/aido/internal/bridge"
 	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
 )
 
 //go:embed web/index.html web/static/*
@@ -32,15 +34,18 @@ type Server struct {
 	Router        *agent.Router
 	Conns         *ConnManager
 	BridgeManager *bridge.Manager
+	Usage         *llm.UsageTracker
+	Models        *llm.Catalog // optional: served at /api/models
 	httpSrv       *http.Server
 	startAt       time.Time
 }
 
-func NewServer(router *agent.Router, bridgeMgr *bridge.Manager) *Server {
+func NewServer(router *agent.Router, bridgeMgr *bridge.Manager, usage *llm.UsageTracker) *Server {
 	return &Server{
 		Router:        router,
 		Conns:         NewConnManager(),
 		BridgeManager: bridgeMgr,
+		Usage:         usage,
 		startAt:       time.Now(),
 	}
 }
@@ -204,11 +209,22 @@ func (s *Server) ginWebSocket(c *gin.Context) {
 			go func(f Frame) {
 				result, err := s.handleMessageSend(ctx, conn, f.Params)
 				if err != nil {
-					conn.Send(ResErr(f.ID, "ERROR", err.Error()))
+					conn.Send(ResErr(f.ID, errorCode(err), err.Error()))
 					return
 				}
 				conn.Send(ResOK(f.ID, result))
 			}(frame)
+		case "tool.approve":
+			result, err := s.handleToolApprove(ctx, conn, frame.Params)
+			if err != nil {
+				code := "ERROR"
+				if errors.Is(err, agent.ErrApprovalNotFound) {
+					code = "NOT_FOUND"
+				}
+				conn.Send(ResErr(frame.ID, code, err.Error()))
+				continue
+			}
+			conn.Send(ResOK(frame.ID, result))
 		case "chat.history", "sessions.list", "health", "config.get":
 			if conn.Role != RoleClient {
 				conn.Send(ResErr(frame.ID, "UNKNOWN_METHOD", "only client supports chat.history, sessions.list, health, config.get"))
@@ -232,7 +248,7 @@ func (s *Server) ginWebSocket(c *gin.Context) {
 			}
 			conn.Send(ResOK(frame.ID, result))
 		default:
-			conn.Send(ResErr(frame.ID, "UNKNOWN_METHOD", "supported: message.send, chat.history, sessions.list, health, config.get"))
+			conn.Send(ResErr(frame.ID, "UNKNOWN_METHOD", "supported: message.send, tool.approve, chat.history, sessions.list, health, config.get"))
 		}
 	}
 }
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/lhdbsbz/aido/internal/agent"
+	"github.com/lhdbsbz/aido/internal/config"
+	"github.com/lhdbsbz/aido/internal/llm"
+	"github.com/lhdbsbz/aido/internal/prompts"
+)
+
+// ResponseFormatParam requests structured output, in OpenAI's response_format shape:
+// {"type":"json_schema","json_schema":{"name","schema","strict"}} or {"type":"json_object"}.
+type ResponseFormatParam struct {
+	Type       string `json:"type"` // "json_schema" | "json_object" | "text"
+	JSONSchema *struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+		Strict bool            `json:"strict"`
+	} `json:"json_schema,omitempty"`
+}
+
+var schemaNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// toResponseSchema converts the request format; nil means free text.
+func (p *ResponseFormatParam) toResponseSchema() (*llm.ResponseSchema, error) {
+	if p == nil {
+		return nil, nil
+	}
+	switch p.Type {
+	case "", "text":
+		return nil, nil
+	case "json_object":
+		return &llm.ResponseSchema{Schema: json.RawMessage(`{"type":"object"}`)}, nil
+	case "json_schema":
+		if p.JSONSchema == nil || len(p.JSONSchema.Schema) == 0 {
+			return nil, fmt.Errorf("response format json_schema requires json_schema.schema")
+		}
+		var schema map[string]any
+		if err := json.Unmarshal(p.JSONSchema.Schema, &schema); err != nil {
+			return nil, fmt.Errorf("invalid json_schema.schema: %w", err)
+		}
+		if err := llm.CheckJSONSchema(p.JSONSchema.Schema); err != nil {
+			return nil, fmt.Errorf("json_schema.schema: %w", err)
+		}
+		if p.JSONSchema.Name != "" && !schemaNameRe.MatchString(p.JSONSchema.Name) {
+			return nil, fmt.Errorf("invalid json_schema.name %q (allowed: a-z, A-Z, 0-9, _ and -, max 64)", p.JSONSchema.Name)
+		}
+		return &llm.ResponseSchema{Name: p.JSONSchema.Name, Schema: p.JSONSchema.Schema, Strict: p.JSONSchema.Strict}, nil
+	}
+	return nil, fmt.Errorf("unsupported response format type %q (allowed: text, json_object, json_schema)", p.Type)
+}
+
+// runAgent sends msg through the router. With a response schema, the final answer is validated;
+// on failure the agent is asked once more (same session, with the validation error) before giving up.
+// parsed is the validated JSON, nil without a schema.
+func (s *Server) runAgent(ctx context.Context, msg agent.InboundMessage, eventSink agent.EventSink) (text string, parsed json.RawMessage, toolSteps []agent.ToolStep, err error) {
+	text, toolSteps, err = s.Router.HandleMessage(ctx, msg, eventSink)
+	if err != nil || msg.ResponseSchema == nil {
+		return text, nil, toolSteps, err
+	}
+	parsed, verr := msg.ResponseSchema.Parse(text)
+	if verr == nil {
+		return text, parsed, toolSteps, nil
+	}
+
+	slog.Info("answer failed schema validation, retrying", "channel", msg.Channel, "chatId", msg.ChatID, "error", verr)
+	locale := ""
+	if cfg := config.Get(); cfg != nil {
+		locale = cfg.Gateway.Locale
+	}
+	retry := msg
+	retry.Text = fmt.Sprintf(prompts.Get(locale).StructuredRetryFmt, verr.Error())
+	retry.Attachments = nil
+	retry.MessageID = ""
+	if retry.ToolChoice.Forced() {
+		retry.ToolChoice = "" // the forced tool already ran in the first attempt
+	}
+	text, retrySteps, err := s.Router.HandleMessage(ctx, retry, eventSink)
+	toolSteps = append(toolSteps, retrySteps...)
+	if err != nil {
+		return text, nil, toolSteps, err
+	}
+	parsed, verr = msg.ResponseSchema.Parse(text)
+	if verr != nil {
+		return text, nil, toolSteps, fmt.Errorf("answer does not match response schema after retry: %w", verr)
+	}
+	return text, parsed, toolSteps, nil
+}
@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+// ToolChoiceParam is a tool choice in OpenAI's tool_choice shape: "auto" | "none" | "required" or
+// {"type":"function","function":{"name":"..."}}. A bare tool name is accepted as well.
+type ToolChoiceParam string
+
+func (p *ToolChoiceParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*p = ToolChoiceParam(s)
+		return nil
+	}
+	var fn struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &fn); err != nil || fn.Type != "function" || fn.Function.Name == "" {
+		return fmt.Errorf(`tool choice must be "auto", "none", "required" or {"type":"function","function":{"name":...}}`)
+	}
+	*p = ToolChoiceParam(fn.Function.Name)
+	return nil
+}
+
+func (p ToolChoiceParam) toToolChoice() llm.ToolChoice { return llm.ToolChoice(p) }
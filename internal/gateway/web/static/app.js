@@ -158,6 +158,12 @@
       chatHistory.scrollTop = chatHistory.scrollHeight;
       passiveStreamDiv = null;
       loadChatHistory();
+    } else if ((ev.type === 'retry' || ev.type === 'fallback' || ev.type === 'continue') && logEl) {
+      appendExecutionLog(logEl, 'status', EXEC.retry + escapeHtml(ev.text || ev.error || ''));
+      chatHistory.scrollTop = chatHistory.scrollHeight;
+    } else if ((ev.type === 'approval_request' || ev.type === 'approval_result') && ev.approval && logEl) {
+      appendApprovalLog(logEl, ev.approval, ev.type === 'approval_request');
+      chatHistory.scrollTop = chatHistory.scrollHeight;
     } else if (ev.type === 'error' && ev.error && logEl) {
       appendExecutionLog(logEl, 'error', EXEC.error + escapeHtml(ev.error));
       chatHistory.scrollTop = chatHistory.scrollHeight;
@@ -193,15 +199,21 @@
   var MINIMAX_MODELS = [
     'minimax-m2', 'minimax-m2.1', 'minimax-m2.1-lightning', 'abab6.5s-32k', 'abab5.5s-32k'
   ];
+  var GEMINI_MODELS = [
+    'gemini-2.5-pro', 'gemini-2.5-flash', 'gemini-2.5-flash-lite', 'gemini-2.0-flash'
+  ];
   var OPENAI_BASEURL = 'https://api.openai.com';
   var ANTHROPIC_BASEURL = 'https://api.anthropic.com';
   var DEEPSEEK_BASEURL = 'https://api.deepseek.com';
   var MINIMAX_BASEURL_CN = 'https://api.minimaxi.com/anthropic';
   var MINIMAX_BASEURL_INTL = 'https://api.minimax.io/anthropic';
+  var GEMINI_BASEURL = 'https://generativelanguage.googleapis.com';
+  var OLLAMA_BASEURL = 'http://localhost:11434';
+  var PROVIDER_TYPES = ['openai', 'openai-responses', 'azure-openai', 'anthropic', 'gemini', 'ollama', 'mock'];
 
   function getModelList(provider) {
     var p = (provider || '').toLowerCase();
-    return p === 'openai' ? OPENAI_MODELS : (p === 'anthropic' ? ANTHROPIC_MODELS : (p === 'minimax' ? MINIMAX_MODELS : []));
+    return p === 'openai' ? OPENAI_MODELS : (p === 'anthropic' ? ANTHROPIC_MODELS : (p === 'minimax' ? MINIMAX_MODELS : (p === 'gemini' ? GEMINI_MODELS : [])));
   }
 
   function buildDatalistOptions(values) {
@@ -306,6 +318,8 @@
     if (p === 'anthropic') return [ANTHROPIC_BASEURL];
     if (p === 'deepseek') return [DEEPSEEK_BASEURL];
     if (p === 'minimax') return [MINIMAX_BASEURL_CN, MINIMAX_BASEURL_INTL];
+    if (p === 'gemini') return [GEMINI_BASEURL];
+    if (p === 'ollama') return [OLLAMA_BASEURL];
     return [];
   }
 
@@ -315,13 +329,15 @@
     if (p === 'anthropic') return ANTHROPIC_BASEURL;
     if (p === 'deepseek') return DEEPSEEK_BASEURL;
     if (p === 'minimax') return MINIMAX_BASEURL_CN;
+    if (p === 'gemini') return GEMINI_BASEURL;
+    if (p === 'ollama') return OLLAMA_BASEURL;
     return '';
   }
 
   function appendProviderBlock(container, name, p) {
     p = p || {};
     var typeVal = (p.type || '').trim().toLowerCase();
-    if (typeVal !== 'openai' && typeVal !== 'anthropic') typeVal = '';
+    if (PROVIDER_TYPES.indexOf(typeVal) < 0) typeVal = '';
     var nameTrim = (name || '').trim().toLowerCase();
     if (nameTrim === 'minimax' && !typeVal) typeVal = 'anthropic';
     var baseURLVal = (p.baseURL || '').trim();
@@ -335,7 +351,7 @@
     div.innerHTML = '<div class="config-block-row-head"><label>名称 <input type="text" class="config-provider-name" value="' + escapeHtml(name || '') + '" placeholder="如 openai"></label><button type="button" class="config-block-remove">删除</button></div>' +
       '<label>API Key <input type="password" class="config-provider-apikey" value="' + escapeHtml(p.apiKey || '') + '" placeholder="必填"></label>' +
       '<label>Base URL <input type="text" class="config-provider-baseurl" data-input-select="' + listBase + '" autocomplete="off" value="' + escapeHtml(baseURLVal) + '" placeholder="可选，常见服务可下拉选或输入"></label><datalist id="' + listBase + '">' + buildDatalistOptions(baseURLOptions) + '</datalist>' +
-      '<label>Type <input type="text" class="config-provider-type" data-input-select="' + listType + '" autocomplete="off" value="' + escapeHtml(typeVal) + '" placeholder="自动 或 openai/anthropic"></label><datalist id="' + listType + '"><option value=""><option value="openai"><option value="anthropic"></datalist>';
+      '<label>Type <input type="text" class="config-provider-type" data-input-select="' + listType + '" autocomplete="off" value="' + escapeHtml(typeVal) + '" placeholder="自动 或 ' + PROVIDER_TYPES.join('/') + '"></label><datalist id="' + listType + '">' + buildDatalistOptions([''].concat(PROVIDER_TYPES)) + '</datalist>';
     container.appendChild(div);
     div.querySelector('.config-provider-name').addEventListener('input', function () {
       var listEl = document.getElementById(listBase);
@@ -814,12 +830,18 @@
           appendExecutionLog(logEl, 'status', escapeHtml(EXEC.done));
           removeExecutionLogIfEmpty(logEl);
           chatHistory.scrollTop = chatHistory.scrollHeight;
+        } else if ((ev.type === 'retry' || ev.type === 'fallback' || ev.type === 'continue') && logEl) {
+          appendExecutionLog(logEl, 'status', EXEC.retry + escapeHtml(ev.text || ev.error || ''));
+          chatHistory.scrollTop = chatHistory.scrollHeight;
+        } else if ((ev.type === 'approval_request' || ev.type === 'approval_result') && ev.approval && logEl) {
+          appendApprovalLog(logEl, ev.approval, ev.type === 'approval_request');
+          chatHistory.scrollTop = chatHistory.scrollHeight;
         } else if (ev.type === 'error' && ev.error && logEl) {
           appendExecutionLog(logEl, 'error', EXEC.error + escapeHtml(ev.error));
           chatHistory.scrollTop = chatHistory.scrollHeight;
         }
       };
-      wsRequest('message.send', { channel: currentChannel, channelChatId: currentChannelChatId, text: text }, 120000).then(function (res) {
+      wsRequest('message.send', { channel: currentChannel, channelChatId: currentChannelChatId, text: text }, 600000).then(function (res) {
         agentEventCallback = null;
         if (streamDiv) {
           streamDiv.classList.remove('streaming');
@@ -841,6 +863,8 @@
     call: '调用 ',
     return: '→ 返回 ',
     done: '完成',
+    retry: '重试: ',
+    approval: '审批 ',
     error: '错误: '
   };
 
@@ -851,6 +875,37 @@
     container.appendChild(line);
   }
 
+  // 工具调用审批：请求时显示批准/拒绝按钮，结果到达（含其他端的决定、超时）后替换为结论
+  function appendApprovalLog(container, approval, isRequest) {
+    var id = 'approval-' + approval.id;
+    var line = document.getElementById(id);
+    if (!isRequest) {
+      var decided = APPROVAL_DECISIONS[approval.decision] || approval.decision;
+      var html = EXEC.approval + escapeHtml(approval.toolName) + ' ' + escapeHtml(decided) +
+        (approval.by ? ' (' + escapeHtml(approval.by) + ')' : '') + (approval.reason ? ': ' + escapeHtml(approval.reason) : '');
+      if (line) line.innerHTML = html; else appendExecutionLog(container, approval.decision === 'approved' ? 'status' : 'error', html);
+      return;
+    }
+    var params = (approval.toolParams || '').trim();
+    var short = params.length > 200 ? params.slice(0, 200) + '…' : params;
+    appendExecutionLog(container, 'approval', EXEC.approval + escapeHtml(approval.toolName) + (short ? ': ' + escapeHtml(short) : '') +
+      ' <button type="button" class="btn-approve">批准</button> <button type="button" class="btn-deny">拒绝</button>');
+    line = container.lastChild;
+    line.id = id;
+    var details = container.closest('details');
+    if (details) details.open = true;
+    function decide(decision) {
+      line.querySelectorAll('button').forEach(function (b) { b.disabled = true; });
+      wsRequest('tool.approve', { approvalId: approval.id, decision: decision }).catch(function (err) {
+        appendExecutionLog(container, 'error', EXEC.error + escapeHtml(err && err.message ? err.message : '审批失败'));
+      });
+    }
+    line.querySelector('.btn-approve').addEventListener('click', function () { decide('approve'); });
+    line.querySelector('.btn-deny').addEventListener('click', function () { decide('deny'); });
+  }
+
+  var APPROVAL_DECISIONS = { approved: '已批准', denied: '已拒绝', timeout: '超时未审批，已拒绝' };
+
   function buildExecutionLogFromSteps(toolSteps) {
     var steps = toolSteps || [];
     if (steps.length === 0) return '';
@@ -1074,7 +1129,11 @@
       agents: {},
       providers: {},
       tools: {},
-      bridges: { instances: [] }
+      bridges: { instances: [] },
+      debug: (currentConfig && currentConfig.debug) || {},
+      pricing: (currentConfig && currentConfig.pricing) || {},
+      budgets: (currentConfig && currentConfig.budgets) || {},
+      models: (currentConfig && currentConfig.models) || {}
     };
     configAgents.querySelectorAll('.config-block').forEach(function (block) {
       var name = (block.querySelector('.config-agent-name') || {}).value;
@@ -1090,15 +1149,15 @@
         var k = parseInt(ctxKEl.value.trim(), 10);
         if (!isNaN(k) && k > 0) comp.contextWindow = k * 1000;
       }
-      cfg.agents[name] = {
+      cfg.agents[name] = Object.assign({}, base, {
         provider: provider,
         model: modelId,
-        tools: { allow: base.tools && base.tools.allow, deny: base.tools && base.tools.deny },
+        tools: Object.assign({}, base.tools),
         fallbacks: base.fallbacks,
         compaction: comp,
         workspace: base.workspace,
         skills: base.skills
-      };
+      });
     });
     configProviders.querySelectorAll('.config-block').forEach(function (block) {
       var name = (block.querySelector('.config-provider-name') || {}).value;
@@ -1107,11 +1166,12 @@
       var apiKey = (block.querySelector('.config-provider-apikey') || {}).value || '';
       var baseURL = (block.querySelector('.config-provider-baseurl') || {}).value || '';
       var typeVal = (block.querySelector('.config-provider-type') || {}).value || '';
-      cfg.providers[name] = {
+      var base = (currentConfig && currentConfig.providers && currentConfig.providers[name]) || {};
+      cfg.providers[name] = Object.assign({}, base, {
         apiKey: apiKey,
         baseURL: baseURL.trim(),
         type: typeVal.trim()
-      };
+      });
     });
     cfg.tools = { mcp: [] };
     configMCP.querySelectorAll('.config-block-row').forEach(function (block) {
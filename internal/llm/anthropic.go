@@ -12,6 +12,8 @@ import (
 
 const anthropicAPIURL = "https://api.anthropic.com"
 const anthropicAPIVersion = "2023-06-01"
+const anthropicMinThinkingBudget = 1024
+const anthropicDefaultMaxTokens = 8192
 
 // AnthropicClient implements Client for Anthropic's native API.
 type AnthropicClient struct {
@@ -28,35 +30,35 @@ func (c *AnthropicClient) Chat(ctx context.Context, params ChatParams) (<-chan S
 		endpoint = params.BaseURL
 	}
 	endpoint = strings.TrimRight(endpoint, "/") + "/v1/messages"
+	// Anthropic needs inline data: fetch URL images and PDFs first.
+	params.Messages = inlineMedia(ctx, params.Messages, true, true)
 	body := c.buildRequest(params)
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", params.APIKey)
-	req.Header.Set("anthropic-version", anthropicAPIVersion)
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := doWithRetry(ctx, c.HTTPClient, params, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-api-key", params.APIKey)
+		req.Header.Set("anthropic-version", anthropicAPIVersion)
+		return req, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("http request: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		errBody, _ := io.ReadAll(resp.Body)
-		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(errBody)}
+		return nil, err
 	}
 
 	ch := make(chan StreamEvent, 32)
-	go c.consumeSSE(resp.Body, ch)
+	go c.consumeSSE(resp.Body, ch, params.ResponseSchema)
 	return ch, nil
 }
 
+var anthropicMedia = mediaSupport{pdf: true}
+
 func (c *AnthropicClient) buildRequest(params ChatParams) map[string]any {
 	messages := make([]map[string]any, 0, len(params.Messages))
 
@@ -67,9 +69,25 @@ func (c *AnthropicClient) buildRequest(params ChatParams) map[string]any {
 			continue
 		case RoleUser:
 			m := map[string]any{"role": "user"}
-			if len(msg.Images) > 0 {
-				content := []map[string]any{
-					{"type": "text", "text": msg.Content},
+			if len(msg.Images) > 0 || len(msg.Documents) > 0 {
+				// Documents first, then the text that refers to them, then images.
+				var content []map[string]any
+				for _, doc := range pdfDocuments(msg) {
+					block := map[string]any{
+						"type": "document",
+						"source": map[string]any{
+							"type":       "base64",
+							"media_type": doc.MIME,
+							"data":       doc.Base64,
+						},
+					}
+					if doc.Name != "" {
+						block["title"] = doc.Name
+					}
+					content = append(content, block)
+				}
+				if text := userText(msg, anthropicMedia); text != "" {
+					content = append(content, map[string]any{"type": "text", "text": text})
 				}
 				for _, img := range msg.Images {
 					if img.Base64 != "" {
@@ -94,8 +112,14 @@ func (c *AnthropicClient) buildRequest(params ChatParams) map[string]any {
 			messages = append(messages, m)
 		case RoleAssistant:
 			m := map[string]any{"role": "assistant"}
-			if len(msg.ToolCalls) > 0 {
-				content := []map[string]any{}
+			// Signed thinking blocks must precede the text/tool_use they led to. Only replay them when
+			// thinking is enabled; other Anthropic-compatible backends reject foreign signatures.
+			var thinking []map[string]any
+			if params.ThinkingBudget > 0 {
+				thinking = anthropicThinkingBlocks(msg.Thinking)
+			}
+			if len(msg.ToolCalls) > 0 || len(thinking) > 0 {
+				content := thinking
 				if msg.Content != "" {
 					content = append(content, map[string]any{
 						"type": "text", "text": msg.Content,
@@ -131,46 +155,234 @@ func (c *AnthropicClient) buildRequest(params ChatParams) map[string]any {
 		}
 	}
 
+	maxTokens := params.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+	var thinkingBudget int
+	if params.ThinkingBudget > 0 {
+		thinkingBudget = max(params.ThinkingBudget, anthropicMinThinkingBudget)
+		if maxTokens <= thinkingBudget {
+			// max_tokens includes the thinking budget and must exceed it
+			maxTokens = thinkingBudget + anthropicDefaultMaxTokens
+		}
+	}
 	req := map[string]any{
 		"model":      params.Model,
 		"messages":   messages,
-		"max_tokens": 8192,
+		"max_tokens": maxTokens,
 		"stream":     true,
 	}
+	if params.ThinkingBudget > 0 {
+		req["thinking"] = map[string]any{
+			"type":          "enabled",
+			"budget_tokens": thinkingBudget,
+		}
+	} else {
+		// Extended thinking rejects custom temperature / top_p, so only send them without it.
+		if params.Temperature != nil {
+			req["temperature"] = *params.Temperature
+		}
+		if params.TopP != nil {
+			req["top_p"] = *params.TopP
+		}
+	}
+	if len(params.Stop) > 0 {
+		req["stop_sequences"] = params.Stop
+	}
 
+	// Prompt caching: breakpoints after the system prompt, after the tool list and on the last
+	// user turn (rolling), so each iteration reuses the previous iteration's prefix.
 	if params.System != "" {
-		req["system"] = params.System
+		req["system"] = []map[string]any{
+			{"type": "text", "text": params.System, "cache_control": anthropicEphemeral()},
+		}
 	}
 
-	if len(params.Tools) > 0 {
-		tools := make([]map[string]any, len(params.Tools))
-		for i, t := range params.Tools {
-			tools[i] = map[string]any{
-				"name":         t.Name,
-				"description":  t.Description,
-				"input_schema": json.RawMessage(t.Parameters),
-			}
-		}
+	tools := make([]map[string]any, 0, len(params.Tools)+1)
+	for _, t := range params.Tools {
+		tools = append(tools, map[string]any{
+			"name":         t.Name,
+			"description":  t.Description,
+			"input_schema": json.RawMessage(t.Parameters),
+		})
+	}
+	// Structured output: the answer is the input of a synthetic tool the model is made to call.
+	if rs := params.ResponseSchema; rs != nil {
+		tools = append(tools, map[string]any{
+			"name":         anthropicStructuredTool,
+			"description":  "Give your final answer by calling this tool exactly once, with the answer as input. Call it only when you are done using other tools.",
+			"input_schema": anthropicStructuredSchema(rs),
+		})
+	}
+	if len(tools) > 0 {
+		tools[len(tools)-1]["cache_control"] = anthropicEphemeral()
 		req["tools"] = tools
+		if choice := anthropicToolChoice(params); choice != nil {
+			req["tool_choice"] = choice
+		}
 	}
 
+	markLastUserTurnCached(messages)
+
 	return req
 }
 
-func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent) {
+// anthropicToolChoice maps ToolChoice and ParallelToolCalls onto tool_choice ("required" is "any"),
+// or returns nil to leave the default. Extended thinking only allows auto and none, so forced choices
+// degrade to auto there. With a ResponseSchema the model must eventually call the structured tool:
+// it is forced when there is nothing else to call, and "none" keeps it callable.
+func anthropicToolChoice(params ChatParams) map[string]any {
+	choice := params.ToolChoice
+	if params.ResponseSchema != nil {
+		switch {
+		case params.ThinkingBudget > 0:
+			// The tool description has to suffice.
+			choice = ToolChoiceAuto
+		case len(params.Tools) == 0 || choice == ToolChoiceNone:
+			choice = anthropicStructuredTool
+		case choice == "" || choice == ToolChoiceAuto:
+			choice = ToolChoiceRequired
+		}
+	} else if params.ThinkingBudget > 0 && choice.Forced() {
+		choice = ToolChoiceAuto
+	}
+
+	var tc map[string]any
+	switch choice {
+	case "":
+		if params.ParallelToolCalls == nil || *params.ParallelToolCalls {
+			return nil
+		}
+		tc = map[string]any{"type": "auto"}
+	case ToolChoiceAuto:
+		tc = map[string]any{"type": "auto"}
+	case ToolChoiceNone:
+		return map[string]any{"type": "none"}
+	case ToolChoiceRequired:
+		tc = map[string]any{"type": "any"}
+	default:
+		tc = map[string]any{"type": "tool", "name": string(choice)}
+	}
+	if params.ParallelToolCalls != nil && !*params.ParallelToolCalls {
+		tc["disable_parallel_tool_use"] = true
+	}
+	return tc
+}
+
+// anthropicStructuredTool is the synthetic tool carrying a structured (ResponseSchema) answer.
+const anthropicStructuredTool = "structured_output"
+
+// anthropicStructuredSchema returns the tool input schema for rs. Tool inputs must be objects, so
+// non-object schemas are wrapped as {"value": ...} and unwrapped again in consumeSSE.
+func anthropicStructuredSchema(rs *ResponseSchema) json.RawMessage {
+	if rs.IsObject() {
+		return rs.Schema
+	}
+	wrapped, _ := json.Marshal(map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"value": rs.Schema},
+		"required":   []string{"value"},
+	})
+	return wrapped
+}
+
+func anthropicEphemeral() map[string]any {
+	return map[string]any{"type": "ephemeral"}
+}
+
+// markLastUserTurnCached puts a cache breakpoint on the final content block of the last user message
+// (a plain user turn or a tool_result turn), converting string content to a text block if needed.
+func markLastUserTurnCached(messages []map[string]any) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i]["role"] != "user" {
+			continue
+		}
+		switch content := messages[i]["content"].(type) {
+		case string:
+			if content == "" {
+				return
+			}
+			messages[i]["content"] = []map[string]any{
+				{"type": "text", "text": content, "cache_control": anthropicEphemeral()},
+			}
+		case []map[string]any:
+			if len(content) > 0 {
+				content[len(content)-1]["cache_control"] = anthropicEphemeral()
+			}
+		}
+		return
+	}
+}
+
+// anthropicThinkingBlocks converts stored thinking blocks to request content blocks.
+func anthropicThinkingBlocks(blocks []ThinkingBlock) []map[string]any {
+	var out []map[string]any
+	for _, b := range blocks {
+		switch b.Type {
+		case "thinking":
+			if b.Signature == "" {
+				continue // unsigned blocks (e.g. interrupted streams) are rejected by the API
+			}
+			out = append(out, map[string]any{
+				"type":      "thinking",
+				"thinking":  b.Thinking,
+				"signature": b.Signature,
+			})
+		case "redacted_thinking":
+			out = append(out, map[string]any{
+				"type": "redacted_thinking",
+				"data": b.Data,
+			})
+		}
+	}
+	return out
+}
+
+// unwrapStructured returns the answer JSON from the structured_output tool input,
+// undoing the {"value": ...} wrapping used for non-object schemas.
+func unwrapStructured(rs *ResponseSchema, input string) string {
+	if rs.IsObject() {
+		return input
+	}
+	var wrapped struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(input), &wrapped); err != nil || wrapped.Value == nil {
+		return input
+	}
+	return string(wrapped.Value)
+}
+
+func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent, structured *ResponseSchema) {
 	defer close(out)
 	defer body.Close()
 
+	// The structured_output tool call is turned into answer text instead of a tool call.
+	structuredIndex := -1
+	var structuredJSON strings.Builder
+	structuredDone := false
+
 	var currentToolIndex int
 	toolIndexMap := make(map[string]int) // content block index → our tool index
+	var currentThinkingIndex int
+	thinkingIndexMap := make(map[int]int) // content block index → our thinking block index
 
 	for event := range ParseSSE(body) {
+		if event.Err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("read stream: %w", event.Err)}
+			return
+		}
 		switch event.Event {
 		case "content_block_start":
 			var block anthropicContentBlockStart
 			if err := json.Unmarshal([]byte(event.Data), &block); err != nil {
 				continue
 			}
+			if block.ContentBlock.Type == "tool_use" && structured != nil && block.ContentBlock.Name == anthropicStructuredTool {
+				structuredIndex = block.Index
+				continue
+			}
 			if block.ContentBlock.Type == "tool_use" {
 				toolIndexMap[fmt.Sprintf("%d", block.Index)] = currentToolIndex
 				out <- StreamEvent{
@@ -181,6 +393,17 @@ func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent)
 				}
 				currentToolIndex++
 			}
+			if t := block.ContentBlock.Type; t == "thinking" || t == "redacted_thinking" {
+				thinkingIndexMap[block.Index] = currentThinkingIndex
+				out <- StreamEvent{
+					Type:          "thinking_delta",
+					ThinkingIndex: currentThinkingIndex,
+					ThinkingType:  t,
+					Text:          block.ContentBlock.Thinking,
+					RedactedData:  block.ContentBlock.Data,
+				}
+				currentThinkingIndex++
+			}
 
 		case "content_block_delta":
 			var delta anthropicContentBlockDelta
@@ -190,7 +413,18 @@ func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent)
 			switch delta.Delta.Type {
 			case "text_delta":
 				out <- StreamEvent{Type: "text_delta", Text: delta.Delta.Text}
+			case "thinking_delta", "signature_delta":
+				out <- StreamEvent{
+					Type:          "thinking_delta",
+					ThinkingIndex: thinkingIndexMap[delta.Index],
+					Text:          delta.Delta.Thinking,
+					Signature:     delta.Delta.Signature,
+				}
 			case "input_json_delta":
+				if delta.Index == structuredIndex {
+					structuredJSON.WriteString(delta.Delta.PartialJSON)
+					continue
+				}
 				idx, ok := toolIndexMap[fmt.Sprintf("%d", delta.Index)]
 				if !ok {
 					idx = 0
@@ -202,11 +436,25 @@ func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent)
 				}
 			}
 
+		case "content_block_stop":
+			var stop struct {
+				Index int `json:"index"`
+			}
+			if err := json.Unmarshal([]byte(event.Data), &stop); err != nil || stop.Index != structuredIndex || structuredDone {
+				continue
+			}
+			structuredDone = true
+			out <- StreamEvent{Type: "text_delta", Text: unwrapStructured(structured, structuredJSON.String())}
+
 		case "message_delta":
 			var md anthropicMessageDelta
 			if err := json.Unmarshal([]byte(event.Data), &md); err != nil {
 				continue
 			}
+			if md.Delta.StopReason == "tool_use" && structuredDone && currentToolIndex == 0 {
+				// Only the answer tool was called: the turn is complete.
+				md.Delta.StopReason = "end_turn"
+			}
 			if md.Usage.OutputTokens > 0 {
 				out <- StreamEvent{
 					Type:  "usage",
@@ -221,15 +469,19 @@ func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent)
 			if err := json.Unmarshal([]byte(event.Data), &ms); err != nil {
 				continue
 			}
-			if ms.Message.Usage.InputTokens > 0 {
+			if u := ms.Message.Usage; u.InputTokens > 0 || u.CacheCreationInputTokens > 0 || u.CacheReadInputTokens > 0 {
 				out <- StreamEvent{
-					Type:  "usage",
-					Usage: &Usage{InputTokens: ms.Message.Usage.InputTokens},
+					Type: "usage",
+					Usage: &Usage{
+						InputTokens:         u.InputTokens,
+						CacheCreationTokens: u.CacheCreationInputTokens,
+						CacheReadTokens:     u.CacheReadInputTokens,
+					},
 				}
 			}
 
 		case "error":
-			out <- StreamEvent{Type: "error", Error: fmt.Errorf("anthropic stream error: %s", event.Data)}
+			out <- StreamEvent{Type: "error", Error: NewAPIError(0, event.Data)}
 			return
 		}
 	}
@@ -240,10 +492,12 @@ func (c *AnthropicClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent)
 type anthropicContentBlockStart struct {
 	Index        int `json:"index"`
 	ContentBlock struct {
-		Type string `json:"type"`
-		ID   string `json:"id"`
-		Name string `json:"name"`
-		Text string `json:"text"`
+		Type     string `json:"type"`
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+		Data     string `json:"data"` // redacted_thinking
 	} `json:"content_block"`
 }
 
@@ -253,6 +507,8 @@ type anthropicContentBlockDelta struct {
 		Type        string `json:"type"`
 		Text        string `json:"text"`
 		PartialJSON string `json:"partial_json"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
 	} `json:"delta"`
 }
 
@@ -268,7 +524,9 @@ type anthropicMessageDelta struct {
 type anthropicMessageStart struct {
 	Message struct {
 		Usage struct {
-			InputTokens int `json:"input_tokens"`
+			InputTokens              int `json:"input_tokens"`
+			CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+			CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 		} `json:"usage"`
 	} `json:"message"`
 }
@@ -0,0 +1,218 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeAnthropic serves the given SSE events (name, data pairs) and records the last request body.
+type fakeAnthropic struct {
+	events [][2]string
+
+	apiKey string
+	body   map[string]any
+}
+
+func (f *fakeAnthropic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.apiKey = r.Header.Get("x-api-key")
+	raw, _ := io.ReadAll(r.Body)
+	f.body = nil
+	_ = json.Unmarshal(raw, &f.body)
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, e := range f.events {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e[0], e[1])
+	}
+}
+
+func newFakeAnthropic(t *testing.T, f *fakeAnthropic) ChatParams {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return ChatParams{
+		Model:   "claude-sonnet-4-5",
+		APIKey:  "test-key",
+		BaseURL: srv.URL,
+		Retry:   RetryPolicy{MaxRetries: -1},
+	}
+}
+
+func TestAnthropicThinking(t *testing.T) {
+	f := &fakeAnthropic{events: [][2]string{
+		{"message_start", `{"type":"message_start","message":{"usage":{"input_tokens":20,"cache_read_input_tokens":5}}}`},
+		{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`},
+		{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me "}}`},
+		{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"think."}}`},
+		{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig-1"}}`},
+		{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+		{"content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"redacted_thinking","data":"opaque"}}`},
+		{"content_block_stop", `{"type":"content_block_stop","index":1}`},
+		{"content_block_start", `{"type":"content_block_start","index":2,"content_block":{"type":"text","text":""}}`},
+		{"content_block_delta", `{"type":"content_block_delta","index":2,"delta":{"type":"text_delta","text":"Answer"}}`},
+		{"content_block_stop", `{"type":"content_block_stop","index":2}`},
+		{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}`},
+	}}
+	params := newFakeAnthropic(t, f)
+	params.ThinkingBudget = 2000
+	params.Messages = []Message{UserMessage("hi")}
+	client := NewClient("anthropic", http.DefaultClient)
+
+	stream, err := client.Chat(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ThinkingBlock{
+		{Type: "thinking", Thinking: "Let me think.", Signature: "sig-1"},
+		{Type: "redacted_thinking", Data: "opaque"},
+	}
+	if len(result.Thinking) != 2 || result.Thinking[0] != want[0] || result.Thinking[1] != want[1] {
+		t.Errorf("thinking = %+v", result.Thinking)
+	}
+	if result.Text != "Answer" || result.StopReason != "end_turn" {
+		t.Errorf("result = %+v", result)
+	}
+	if u := result.Usage; u == nil || u.InputTokens != 20 || u.CacheReadTokens != 5 || u.OutputTokens != 7 {
+		t.Errorf("usage = %+v", result.Usage)
+	}
+	if f.apiKey != "test-key" {
+		t.Errorf("x-api-key = %q", f.apiKey)
+	}
+
+	// The signed blocks go back before the text of the assistant turn, only while thinking is enabled
+	assistant := result.Message
+	assistant.Thinking = append(assistant.Thinking, ThinkingBlock{Type: "thinking", Thinking: "unsigned"})
+	params.Messages = []Message{UserMessage("hi"), assistant, UserMessage("more")}
+	temperature := 0.5
+	params.Temperature = &temperature // not allowed with thinking
+	for _, budget := range []int{2000, 0} {
+		params.ThinkingBudget = budget
+		stream, err := client.Chat(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ConsumeStream(context.Background(), stream); err != nil {
+			t.Fatal(err)
+		}
+		got := f.body["messages"].([]any)[1].(map[string]any)["content"]
+		if budget == 0 {
+			if got != "Answer" {
+				t.Errorf("without thinking, assistant content = %v", got)
+			}
+			continue
+		}
+		blocks, _ := got.([]any)
+		var types []string
+		for _, b := range blocks {
+			types = append(types, b.(map[string]any)["type"].(string))
+		}
+		if fmt.Sprint(types) != "[thinking redacted_thinking text]" {
+			t.Fatalf("assistant content = %v", got)
+		}
+		if th := blocks[0].(map[string]any); th["thinking"] != "Let me think." || th["signature"] != "sig-1" {
+			t.Errorf("thinking block = %v", th)
+		}
+		if f.body["thinking"] == nil || f.body["temperature"] != nil {
+			t.Errorf("thinking = %v, temperature = %v", f.body["thinking"], f.body["temperature"])
+		}
+	}
+}
+
+func TestAnthropicThinkingBudget(t *testing.T) {
+	cases := []struct {
+		budget, maxTokens   int
+		wantBudget, wantMax int
+	}{
+		{0, 0, 0, anthropicDefaultMaxTokens},
+		{0, 800, 0, 800},
+		{2000, 16000, 2000, 16000},
+		{2000, 1500, 2000, 2000 + anthropicDefaultMaxTokens},
+		{500, 800, anthropicMinThinkingBudget, anthropicMinThinkingBudget + anthropicDefaultMaxTokens}, // clamped budget exceeds max_tokens
+		{500, 4000, anthropicMinThinkingBudget, 4000},
+	}
+	for _, c := range cases {
+		req := (&AnthropicClient{}).buildRequest(ChatParams{Model: "claude", ThinkingBudget: c.budget, MaxTokens: c.maxTokens})
+		var budget int
+		if th, ok := req["thinking"].(map[string]any); ok {
+			budget = th["budget_tokens"].(int)
+		}
+		if budget != c.wantBudget || req["max_tokens"] != c.wantMax {
+			t.Errorf("budget %d, max %d: sent budget_tokens %d, max_tokens %v; want %d, %d", c.budget, c.maxTokens, budget, req["max_tokens"], c.wantBudget, c.wantMax)
+		}
+		if budget > 0 && req["max_tokens"].(int) <= budget {
+			t.Errorf("budget %d, max %d: max_tokens must exceed budget_tokens", c.budget, c.maxTokens)
+		}
+	}
+}
+
+func TestAnthropicCacheBreakpoints(t *testing.T) {
+	tools := []ToolDef{
+		{Name: "exec", Parameters: json.RawMessage(`{"type":"object"}`)},
+		{Name: "read_file", Parameters: json.RawMessage(`{"type":"object"}`)},
+	}
+	cached := func(block map[string]any) bool {
+		cc, ok := block["cache_control"].(map[string]any)
+		return ok && cc["type"] == "ephemeral"
+	}
+	countCached := func(messages []map[string]any) int {
+		n := 0
+		for _, m := range messages {
+			if blocks, ok := m["content"].([]map[string]any); ok {
+				for _, b := range blocks {
+					if cached(b) {
+						n++
+					}
+				}
+			}
+		}
+		return n
+	}
+
+	// A tool loop: the last user turn is the tool result
+	req := (&AnthropicClient{}).buildRequest(ChatParams{
+		Model:  "claude",
+		System: "be brief",
+		Tools:  tools,
+		Messages: []Message{
+			UserMessage("first"),
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "t1", Name: "exec", Arguments: `{}`}}},
+			{Role: RoleTool, ToolCallID: "t1", Content: "ok"},
+		},
+	})
+	if system := req["system"].([]map[string]any); len(system) != 1 || !cached(system[0]) {
+		t.Errorf("system = %v", system)
+	}
+	sent := req["tools"].([]map[string]any)
+	if cached(sent[0]) || !cached(sent[1]) {
+		t.Errorf("tools = %v, want a breakpoint on the last one only", sent)
+	}
+	messages := req["messages"].([]map[string]any)
+	last := messages[2]["content"].([]map[string]any)
+	if last[0]["type"] != "tool_result" || !cached(last[0]) || countCached(messages) != 1 {
+		t.Errorf("messages = %v, want one breakpoint, on the tool result", messages)
+	}
+
+	// A plain user turn is converted to a text block carrying the breakpoint
+	req = (&AnthropicClient{}).buildRequest(ChatParams{
+		Model:    "claude",
+		Messages: []Message{UserMessage("first"), AssistantMessage("answer"), UserMessage("second")},
+	})
+	messages = req["messages"].([]map[string]any)
+	if messages[0]["content"] != "first" {
+		t.Errorf("earlier user turn = %v", messages[0]["content"])
+	}
+	last = messages[2]["content"].([]map[string]any)
+	if len(last) != 1 || last[0]["text"] != "second" || !cached(last[0]) {
+		t.Errorf("last user turn = %v", last)
+	}
+	if req["system"] != nil || req["tools"] != nil {
+		t.Errorf("system = %v, tools = %v, want none", req["system"], req["tools"])
+	}
+}
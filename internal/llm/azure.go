@@ -0,0 +1,163 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AzureDefaultAPIVersion = "2024-10-21"
+	azureDefaultAuthority  = "https://login.microsoftonline.com"
+	azureCognitiveScope    = "https://cognitiveservices.azure.com/.default"
+	azureTokenRefreshSkew  = 5 * time.Minute
+)
+
+// AzureOptions are the settings of an Azure OpenAI provider.
+type AzureOptions struct {
+	APIVersion  string            // api-version query parameter; "" uses AzureDefaultAPIVersion
+	Deployments map[string]string // model → deployment name; unlisted models are used as deployment names
+	EntraID     *EntraIDCredentials
+}
+
+// EntraIDCredentials authenticate with a Microsoft Entra ID app registration (client credentials) instead of an api-key.
+type EntraIDCredentials struct {
+	TenantID      string
+	ClientID      string
+	ClientSecret  string
+	AuthorityHost string // "" uses https://login.microsoftonline.com (set it for sovereign clouds)
+}
+
+// Deployment returns the deployment serving model.
+func (o *AzureOptions) Deployment(model string) string {
+	if o != nil {
+		if d := o.Deployments[model]; d != "" {
+			return d
+		}
+	}
+	return model
+}
+
+// AzureOpenAIClient implements Client for Azure OpenAI: chat completions on
+// {baseURL}/openai/deployments/{deployment}/chat/completions?api-version=..., authenticated with an
+// api-key header or an Entra ID bearer token. Requests and streams are those of OpenAIClient.
+type AzureOpenAIClient struct {
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	tokens map[EntraIDCredentials]azureToken
+}
+
+type azureToken struct {
+	value   string
+	expires time.Time
+}
+
+func (c *AzureOpenAIClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	if params.BaseURL == "" {
+		return nil, fmt.Errorf("azure-openai: baseURL (https://<resource>.openai.azure.com) is required")
+	}
+	apiVersion := AzureDefaultAPIVersion
+	if params.Azure != nil && params.Azure.APIVersion != "" {
+		apiVersion = params.Azure.APIVersion
+	}
+	endpoint := strings.TrimRight(params.BaseURL, "/") + "/openai/deployments/" +
+		url.PathEscape(params.Azure.Deployment(params.Model)) + "/chat/completions?api-version=" + url.QueryEscape(apiVersion)
+
+	body := (&OpenAIClient{}).buildRequest(params)
+	// Azure takes max_completion_tokens for every model since 2024-09-01 and requires it for reasoning models.
+	if v, ok := body["max_tokens"]; ok {
+		delete(body, "max_tokens")
+		body["max_completion_tokens"] = v
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	resp, err := doWithRetry(ctx, c.HTTPClient, params, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if params.Azure != nil && params.Azure.EntraID != nil {
+			token, err := c.entraToken(ctx, *params.Azure.EntraID)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.Header.Set("api-key", params.APIKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 32)
+	go (&OpenAIClient{}).consumeSSE(resp.Body, ch)
+	return ch, nil
+}
+
+// entraToken returns a cached access token for the Cognitive Services scope, fetching a new one
+// with the client credentials grant when none is cached or it expires within azureTokenRefreshSkew.
+func (c *AzureOpenAIClient) entraToken(ctx context.Context, cred EntraIDCredentials) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[cred]; ok && time.Until(t.expires) > azureTokenRefreshSkew {
+		return t.value, nil
+	}
+
+	authority := cred.AuthorityHost
+	if authority == "" {
+		authority = azureDefaultAuthority
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {cred.ClientID},
+		"client_secret": {cred.ClientSecret},
+		"scope":         {azureCognitiveScope},
+	}
+	tokenURL := strings.TrimRight(authority, "/") + "/" + url.PathEscape(cred.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("entra id token: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", &TransportError{Err: fmt.Errorf("entra id token: %w", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(resp.Body)
+		// Rejected credentials are an auth problem for the provider, whatever status the token endpoint used.
+		status := resp.StatusCode
+		if status == http.StatusBadRequest {
+			status = http.StatusUnauthorized
+		}
+		return "", fmt.Errorf("entra id token: %w", NewAPIError(status, string(errBody)))
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.AccessToken == "" {
+		return "", fmt.Errorf("entra id token: invalid response")
+	}
+
+	if c.tokens == nil {
+		c.tokens = make(map[EntraIDCredentials]azureToken)
+	}
+	c.tokens[cred] = azureToken{value: out.AccessToken, expires: time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)}
+	return out.AccessToken, nil
+}
@@ -0,0 +1,84 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAzureOpenAIClient(t *testing.T) {
+	var tokenRequests int
+	var got struct {
+		path, query, apiKey, auth string
+		body                      map[string]any
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tenant-1/oauth2/v2.0/token" {
+			tokenRequests++
+			r.ParseForm()
+			if r.Form.Get("client_secret") != "s3cret" || r.Form.Get("scope") != "https://cognitiveservices.azure.com/.default" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad secret"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"entra-token","expires_in":3600}`)
+			return
+		}
+		got.path, got.query = r.URL.Path, r.URL.RawQuery
+		got.apiKey, got.auth = r.Header.Get("api-key"), r.Header.Get("Authorization")
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &got.body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client := NewClient("azure-openai", srv.Client())
+	chat := func(params ChatParams) (*StreamResult, error) {
+		stream, err := client.Chat(context.Background(), params)
+		if err != nil {
+			return nil, err
+		}
+		return ConsumeStream(context.Background(), stream)
+	}
+
+	azure := &AzureOptions{Deployments: map[string]string{"gpt-4o": "prod-4o"}}
+	result, err := chat(ChatParams{BaseURL: srv.URL, APIKey: "k", Model: "gpt-4o", MaxTokens: 100, Azure: azure})
+	if err != nil || result.Text != "hi" {
+		t.Fatalf("result = %+v, err = %v", result, err)
+	}
+	if got.path != "/openai/deployments/prod-4o/chat/completions" || got.query != "api-version="+AzureDefaultAPIVersion {
+		t.Errorf("url = %s?%s", got.path, got.query)
+	}
+	if got.apiKey != "k" || got.auth != "" {
+		t.Errorf("api-key = %q, Authorization = %q", got.apiKey, got.auth)
+	}
+	if got.body["max_completion_tokens"] != float64(100) || got.body["max_tokens"] != nil {
+		t.Errorf("body = %v", got.body)
+	}
+
+	// Unmapped models are their own deployment; Entra ID tokens are fetched once and reused
+	azure = &AzureOptions{APIVersion: "2025-01-01-preview", EntraID: &EntraIDCredentials{TenantID: "tenant-1", ClientID: "app", ClientSecret: "s3cret", AuthorityHost: srv.URL}}
+	for range 2 {
+		if _, err := chat(ChatParams{BaseURL: srv.URL, Model: "o4-mini", Azure: azure}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got.path != "/openai/deployments/o4-mini/chat/completions" || got.query != "api-version=2025-01-01-preview" {
+		t.Errorf("url = %s?%s", got.path, got.query)
+	}
+	if got.auth != "Bearer entra-token" || got.apiKey != "" || tokenRequests != 1 {
+		t.Errorf("Authorization = %q, api-key = %q, token requests = %d", got.auth, got.apiKey, tokenRequests)
+	}
+
+	bad := *azure.EntraID
+	bad.ClientSecret = "wrong"
+	_, err = chat(ChatParams{BaseURL: srv.URL, Model: "o4-mini", Azure: &AzureOptions{EntraID: &bad}})
+	if ErrorKindOf(err) != ErrorKindAuth {
+		t.Errorf("err = %v (kind %q)", err, ErrorKindOf(err))
+	}
+}
@@ -0,0 +1,439 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CassetteKindLLM is the record kind of one provider exchange (one Chat call).
+const CassetteKindLLM = "llm"
+
+// ErrCassetteExhausted is returned when a replay asks for more records than were recorded.
+var ErrCassetteExhausted = errors.New("cassette exhausted")
+
+// ErrReplayDiverged is returned when a replayed run asks for a different model than the recording.
+var ErrReplayDiverged = errors.New("replay diverged from recording")
+
+// Cassette is the recording of one agent run: an append-only JSONL file of records.
+// RecordingClient adds one "llm" record per Chat call with the raw request and response bodies;
+// callers may add records of their own kinds (the agent loop stores the run input, tool results and outcome).
+// A cassette opened with OpenCassette is read-only and serves records back in order, per kind.
+type Cassette struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File // nil when opened for replay
+	records []CassetteRecord
+	next    map[string]int // replay cursor per kind
+}
+
+// CassetteRecord is one line of a cassette.
+type CassetteRecord struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+
+	// Kind "llm"
+	Provider   string          `json:"provider,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Client     string          `json:"client,omitempty"`     // format of Response: the client type (openai, anthropic, ...), or "events" for clients without HTTP
+	Request    json.RawMessage `json:"request,omitempty"`    // body of the last HTTP request sent
+	Status     int             `json:"status,omitempty"`     // HTTP status of the last response, 0 if none
+	Response   string          `json:"response,omitempty"`   // full response body as received (SSE, NDJSON or error body)
+	Error      string          `json:"error,omitempty"`      // error returned by Chat itself; stream errors stay in Response
+	ErrorType  string          `json:"errorType,omitempty"`  // "api" | "transport" | "canceled" | "other"
+	DurationMs int64           `json:"durationMs,omitempty"` // until the stream ended
+
+	// Other kinds
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// CreateCassette creates a new cassette file for recording; it fails if path already exists.
+func CreateCassette(path string) (*Cassette, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Cassette{path: path, f: f}, nil
+}
+
+// OpenCassette loads a recorded cassette for replay.
+func OpenCassette(path string) (*Cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Cassette{path: path, next: make(map[string]int)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec CassetteRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		c.records = append(c.records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Cassette) Path() string { return c.path }
+
+// Append writes a record (Time defaults to now).
+func (c *Cassette) Append(rec CassetteRecord) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return fmt.Errorf("cassette %s is not open for recording", c.path)
+	}
+	c.records = append(c.records, rec)
+	_, err = c.f.Write(append(line, '\n'))
+	return err
+}
+
+// AppendData writes a record of the given kind with v as its JSON data.
+func (c *Cassette) AppendData(kind string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Append(CassetteRecord{Kind: kind, Data: data})
+}
+
+// Next returns the next unread record of the given kind, or ErrCassetteExhausted.
+func (c *Cassette) Next(kind string) (*CassetteRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next == nil {
+		c.next = make(map[string]int)
+	}
+	for i := c.next[kind]; i < len(c.records); i++ {
+		if c.records[i].Kind == kind {
+			c.next[kind] = i + 1
+			rec := c.records[i]
+			return &rec, nil
+		}
+	}
+	c.next[kind] = len(c.records)
+	return nil, fmt.Errorf("%w: no %s record left", ErrCassetteExhausted, kind)
+}
+
+// NextData decodes the data of the next record of the given kind into v.
+func (c *Cassette) NextData(kind string, v any) error {
+	rec, err := c.Next(kind)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(rec.Data, v)
+}
+
+// Count returns how many records of the given kind the cassette holds.
+func (c *Cassette) Count(kind string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, rec := range c.records {
+		if rec.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cassette) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f = nil
+	return err
+}
+
+// RecordingClient wraps a Client and records every Chat call into a cassette.
+// For HTTP clients it stores the raw request body and the full response stream as received;
+// for other clients (mock) it stores the stream events.
+type RecordingClient struct {
+	Client   Client
+	Type     string // client type (provider type), tells ReplayClient how to parse the recorded response
+	Cassette *Cassette
+}
+
+func NewRecordingClient(client Client, clientType string, c *Cassette) *RecordingClient {
+	return &RecordingClient{Client: client, Type: clientType, Cassette: c}
+}
+
+func (r *RecordingClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	capture := &exchangeCapture{}
+	start := time.Now()
+	stream, err := r.Client.Chat(withCapture(ctx, capture), params)
+	if err != nil {
+		r.save(params, capture, nil, err, start)
+		return nil, err
+	}
+
+	out := make(chan StreamEvent, 32)
+	go func() {
+		defer close(out)
+		var events []StreamEvent
+		for ev := range stream {
+			events = append(events, ev)
+			select {
+			case out <- ev:
+			case <-ctx.Done(): // keep draining so the client finishes and the response is complete
+			}
+		}
+		r.save(params, capture, events, nil, start)
+	}()
+	return out, nil
+}
+
+func (r *RecordingClient) save(params ChatParams, capture *exchangeCapture, events []StreamEvent, chatErr error, start time.Time) {
+	rec := CassetteRecord{
+		Kind:       CassetteKindLLM,
+		Provider:   params.Provider,
+		Model:      params.Model,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	capture.mu.Lock()
+	if capture.sent {
+		rec.Client = r.Type
+		rec.Request = capture.request
+		rec.Status = capture.status
+		rec.Response = capture.response.String()
+	} else {
+		rec.Client = "events"
+		rec.Response = encodeCassetteEvents(events)
+	}
+	capture.mu.Unlock()
+
+	if chatErr != nil {
+		rec.Error = chatErr.Error()
+		var apiErr *APIError
+		var transportErr *TransportError
+		switch {
+		case errors.As(chatErr, &apiErr):
+			rec.ErrorType = "api"
+			rec.Status, rec.Response = apiErr.StatusCode, apiErr.Body
+		case errors.As(chatErr, &transportErr):
+			rec.ErrorType = "transport"
+		case errors.Is(chatErr, context.Canceled), errors.Is(chatErr, context.DeadlineExceeded):
+			rec.ErrorType = "canceled"
+		default:
+			rec.ErrorType = "other"
+		}
+	}
+	if err := r.Cassette.Append(rec); err != nil {
+		// Recording is best effort; never fail the run because of it.
+		slog.Warn("cassette write failed", "path", r.Cassette.Path(), "error", err)
+	}
+}
+
+// ReplayClient implements Client by serving a cassette's "llm" records in order. Recorded responses are
+// parsed by the same stream decoders the live clients use, so a replay sees exactly the events the run saw.
+type ReplayClient struct {
+	Cassette *Cassette
+}
+
+func NewReplayClient(c *Cassette) *ReplayClient {
+	return &ReplayClient{Cassette: c}
+}
+
+func (r *ReplayClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	rec, err := r.Cassette.Next(CassetteKindLLM)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Provider != params.Provider || rec.Model != params.Model {
+		return nil, fmt.Errorf("%w: run called %s/%s, recording has %s/%s",
+			ErrReplayDiverged, params.Provider, params.Model, rec.Provider, rec.Model)
+	}
+	if err := rec.replayError(); err != nil {
+		return nil, err
+	}
+
+	body := io.NopCloser(strings.NewReader(rec.Response))
+	ch := make(chan StreamEvent, 32)
+	switch rec.Client {
+	case "anthropic":
+		go (&AnthropicClient{}).consumeSSE(body, ch, params.ResponseSchema)
+	case "gemini":
+		go (&GeminiClient{}).consumeSSE(body, ch)
+	case "ollama":
+		go (&OllamaClient{}).consumeNDJSON(body, ch)
+	case "openai-responses":
+		go (&ResponsesClient{}).consumeSSE(body, ch, func(string, Message) {})
+	case "events":
+		go consumeCassetteEvents(body, ch)
+	case "openai", "azure-openai", "":
+		go (&OpenAIClient{}).consumeSSE(body, ch)
+	default:
+		return nil, fmt.Errorf("replay: unknown recorded client %q", rec.Client)
+	}
+	return ch, nil
+}
+
+// replayError rebuilds the error a recorded Chat call returned, nil if it returned a stream.
+func (rec *CassetteRecord) replayError() error {
+	switch rec.ErrorType {
+	case "":
+		return nil
+	case "api":
+		return NewAPIError(rec.Status, rec.Response)
+	case "transport":
+		return &TransportError{Err: errors.New(strings.TrimPrefix(rec.Error, "http request: "))}
+	case "canceled":
+		return context.Canceled
+	}
+	return errors.New(rec.Error)
+}
+
+// exchangeCapture collects the raw HTTP exchange of one Chat call; doWithRetry fills it when the
+// request context carries one (see withCapture). Only the last attempt is kept.
+type exchangeCapture struct {
+	mu       sync.Mutex
+	sent     bool
+	request  []byte
+	status   int
+	response bytes.Buffer
+}
+
+type captureKey struct{}
+
+func withCapture(ctx context.Context, c *exchangeCapture) context.Context {
+	return context.WithValue(ctx, captureKey{}, c)
+}
+
+func captureFrom(ctx context.Context) *exchangeCapture {
+	c, _ := ctx.Value(captureKey{}).(*exchangeCapture)
+	return c
+}
+
+// begin records the request body of a new attempt and clears the previous attempt's response.
+func (c *exchangeCapture) begin(req *http.Request) {
+	var body []byte
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = true
+	c.request = body
+	c.status = 0
+	c.response.Reset()
+}
+
+// finish records the response status and error body of an attempt.
+func (c *exchangeCapture) finish(status int, errBody []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.response.Write(errBody)
+}
+
+// tee returns a body that copies everything the client reads into the capture.
+func (c *exchangeCapture) tee(body io.ReadCloser) io.ReadCloser {
+	return &teeBody{ReadCloser: body, c: c}
+}
+
+type teeBody struct {
+	io.ReadCloser
+	c *exchangeCapture
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.c.mu.Lock()
+		t.c.response.Write(p[:n])
+		t.c.mu.Unlock()
+	}
+	return n, err
+}
+
+// cassetteEvent is a StreamEvent as stored in "events" recordings; Error shadows the interface field.
+type cassetteEvent struct {
+	StreamEvent
+	Error *cassetteError `json:",omitempty"`
+}
+
+type cassetteError struct {
+	Status  int    `json:"status,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// encodeCassetteEvents renders stream events as SSE, one event per block.
+func encodeCassetteEvents(events []StreamEvent) string {
+	var sb strings.Builder
+	for _, ev := range events {
+		ce := cassetteEvent{StreamEvent: ev}
+		ce.StreamEvent.Error = nil
+		if ev.Error != nil {
+			ce.Error = &cassetteError{Message: ev.Error.Error()}
+			var apiErr *APIError
+			if errors.As(ev.Error, &apiErr) {
+				ce.Error.Status, ce.Error.Body = apiErr.StatusCode, apiErr.Body
+			}
+		}
+		data, _ := json.Marshal(ce)
+		fmt.Fprintf(&sb, "event: %s\ndata: %s\n\n", ev.Type, data)
+	}
+	return sb.String()
+}
+
+func consumeCassetteEvents(body io.ReadCloser, out chan<- StreamEvent) {
+	defer close(out)
+	defer body.Close()
+	for event := range ParseSSE(body) {
+		if event.Err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("read stream: %w", event.Err)}
+			return
+		}
+		var ce cassetteEvent
+		if err := json.Unmarshal([]byte(event.Data), &ce); err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("replay: bad recorded event: %w", err)}
+			return
+		}
+		ev := ce.StreamEvent
+		if ce.Error != nil {
+			if ce.Error.Body != "" {
+				ev.Error = NewAPIError(ce.Error.Status, ce.Error.Body)
+			} else {
+				ev.Error = errors.New(ce.Error.Message)
+			}
+		}
+		out <- ev
+	}
+}
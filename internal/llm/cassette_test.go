@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplayHTTP(t *testing.T) {
+	f := &fakeGemini{chunks: []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":3}}`,
+	}}
+	params := newFakeGemini(t, f)
+	params.Provider = "gemini"
+	params.Messages = []Message{UserMessage("hi")}
+
+	path := filepath.Join(t.TempDir(), "run_1.jsonl")
+	cassette, err := CreateCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecordingClient(NewGeminiClient(), "gemini", cassette)
+	stream, err := rec.Chat(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.status = 400
+	if _, err := rec.Chat(context.Background(), params); ErrorKindOf(err) != ErrorKindInvalidRequest {
+		t.Fatalf("recorded error = %v", err)
+	}
+	cassette.Close()
+
+	replay, err := OpenCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := replay.Next(CassetteKindLLM)
+	if first.Client != "gemini" || first.Status != 200 || !strings.Contains(string(first.Request), `"hi"`) || !strings.Contains(first.Response, "data: ") {
+		t.Errorf("recorded exchange = %+v", first)
+	}
+
+	replay, _ = OpenCassette(path)
+	client := NewReplayClient(replay)
+	stream, err = client.Chat(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != live.Text || got.StopReason != live.StopReason || *got.Usage != *live.Usage {
+		t.Errorf("replayed %+v, live %+v", got, live)
+	}
+	if _, err := client.Chat(context.Background(), params); ErrorKindOf(err) != ErrorKindInvalidRequest {
+		t.Errorf("replayed error = %v", err)
+	}
+	if _, err := client.Chat(context.Background(), params); !errors.Is(err, ErrCassetteExhausted) {
+		t.Errorf("past the end: %v", err)
+	}
+
+	other, _ := OpenCassette(path)
+	params.Model = "gemini-2.5-pro"
+	if _, err := NewReplayClient(other).Chat(context.Background(), params); !errors.Is(err, ErrReplayDiverged) {
+		t.Errorf("different model: %v", err)
+	}
+}
+
+func TestRecordAndReplayEvents(t *testing.T) {
+	script, err := ParseMockScript([]byte(`
+turns:
+  - {text: "partial", error: {inStream: true, type: overloaded_error, message: Overloaded}}
+  - toolCalls: [{id: call_1, name: echo, arguments: {x: 1}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := NewMockClient()
+	mock.SetScript("mock", script)
+
+	path := filepath.Join(t.TempDir(), "run_2.jsonl")
+	cassette, _ := CreateCassette(path)
+	rec := NewRecordingClient(mock, "mock", cassette)
+	params := ChatParams{Provider: "mock", Model: "m", Messages: []Message{UserMessage("hi")}}
+	for i := 0; i < 2; i++ {
+		stream, err := rec.Chat(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ConsumeStream(context.Background(), stream)
+	}
+	cassette.Close()
+
+	replay, _ := OpenCassette(path)
+	client := NewReplayClient(replay)
+	stream, _ := client.Chat(context.Background(), params)
+	if _, err := ConsumeStream(context.Background(), stream); ErrorKindOf(err) != ErrorKindOverloaded {
+		t.Errorf("replayed stream error = %v", err)
+	}
+	stream, _ = client.Chat(context.Background(), params)
+	got, err := ConsumeStream(context.Background(), stream)
+	if err != nil || len(got.ToolCalls) != 1 || got.ToolCalls[0].Arguments != `{"x":1}` || got.StopReason != "tool_use" {
+		t.Errorf("replayed tool call = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,209 @@
+package llm
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Input modalities a model accepts (ModelInfo.Input).
+const (
+	ModalityText  = "text"
+	ModalityImage = "image"
+	ModalityPDF   = "pdf"
+)
+
+// Sources of a catalog entry, from least to most specific.
+const (
+	ModelSourceBuiltin    = "builtin"
+	ModelSourceDiscovered = "discovered"
+	ModelSourceConfig     = "config"
+)
+
+// ModelInfo describes a model's limits and capabilities. Zero values mean unknown, and callers keep
+// their defaults: an empty Input accepts every attachment, nil Tools and Reasoning allow both.
+type ModelInfo struct {
+	ID              string      `json:"id"`
+	Provider        string      `json:"provider,omitempty"`
+	Source          string      `json:"source,omitempty"` // most specific source that described the model
+	ContextWindow   int         `json:"contextWindow,omitempty"`
+	MaxOutputTokens int         `json:"maxOutputTokens,omitempty"`
+	Input           []string    `json:"input,omitempty"` // ModalityText | ModalityImage | ModalityPDF
+	Tools           *bool       `json:"tools,omitempty"`
+	Reasoning       *bool       `json:"reasoning,omitempty"` // extended thinking / reasoning effort
+	Price           *ModelPrice `json:"price,omitempty"`
+}
+
+// Accepts reports whether the model takes input of the given modality; unknown models accept everything.
+func (m ModelInfo) Accepts(modality string) bool {
+	return len(m.Input) == 0 || slices.Contains(m.Input, modality)
+}
+
+// SupportsTools reports whether tool definitions may be sent; true unless known otherwise.
+func (m ModelInfo) SupportsTools() bool { return m.Tools == nil || *m.Tools }
+
+// SupportsReasoning reports whether thinking may be requested; true unless known otherwise.
+func (m ModelInfo) SupportsReasoning() bool { return m.Reasoning == nil || *m.Reasoning }
+
+// overlay returns m with the known fields of o.
+func (m ModelInfo) overlay(o ModelInfo, source string) ModelInfo {
+	if o.ContextWindow > 0 {
+		m.ContextWindow = o.ContextWindow
+	}
+	if o.MaxOutputTokens > 0 {
+		m.MaxOutputTokens = o.MaxOutputTokens
+	}
+	if len(o.Input) > 0 {
+		m.Input = o.Input
+	}
+	if o.Tools != nil {
+		m.Tools = o.Tools
+	}
+	if o.Reasoning != nil {
+		m.Reasoning = o.Reasoning
+	}
+	m.Source = source
+	return m
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+var (
+	textOnly        = []string{ModalityText}
+	textImage       = []string{ModalityText, ModalityImage}
+	textImagePDF    = []string{ModalityText, ModalityImage, ModalityPDF}
+	yes, no         = boolPtr(true), boolPtr(false)
+	claudeReasoning = ModelInfo{ContextWindow: 200_000, MaxOutputTokens: 64_000, Input: textImagePDF, Tools: yes, Reasoning: yes}
+)
+
+// BuiltinModels holds the limits and capabilities of common models, keyed like Pricing (prefix match,
+// longest wins); discovered models and config models override it.
+var BuiltinModels = map[string]ModelInfo{
+	"claude-opus-4-5":   claudeReasoning,
+	"claude-opus-4":     {ContextWindow: 200_000, MaxOutputTokens: 32_000, Input: textImagePDF, Tools: yes, Reasoning: yes},
+	"claude-sonnet-4":   claudeReasoning,
+	"claude-3-7-sonnet": claudeReasoning,
+	"claude-haiku-4-5":  claudeReasoning,
+	"claude-3-5-haiku":  {ContextWindow: 200_000, MaxOutputTokens: 8192, Input: textImage, Tools: yes, Reasoning: no},
+	"gpt-5":             {ContextWindow: 400_000, MaxOutputTokens: 128_000, Input: textImagePDF, Tools: yes, Reasoning: yes},
+	"gpt-4.1":           {ContextWindow: 1_047_576, MaxOutputTokens: 32_768, Input: textImagePDF, Tools: yes, Reasoning: no},
+	"gpt-4o":            {ContextWindow: 128_000, MaxOutputTokens: 16_384, Input: textImagePDF, Tools: yes, Reasoning: no},
+	"o3":                {ContextWindow: 200_000, MaxOutputTokens: 100_000, Input: textImagePDF, Tools: yes, Reasoning: yes},
+	"o4-mini":           {ContextWindow: 200_000, MaxOutputTokens: 100_000, Input: textImagePDF, Tools: yes, Reasoning: yes},
+	"gemini-2.5":        {ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Input: textImagePDF, Tools: yes, Reasoning: yes},
+	"deepseek-chat":     {ContextWindow: 128_000, MaxOutputTokens: 8192, Input: textOnly, Tools: yes, Reasoning: no},
+	"deepseek-reasoner": {ContextWindow: 128_000, MaxOutputTokens: 64_000, Input: textOnly, Tools: yes, Reasoning: yes},
+	"qwen3":             {ContextWindow: 131_072, Input: textOnly, Tools: yes},
+	"qwen3-vl":          {ContextWindow: 262_144, Input: textImage, Tools: yes},
+	"kimi-k2":           {ContextWindow: 262_144, Input: textOnly, Tools: yes},
+	"glm-4.6":           {ContextWindow: 200_000, MaxOutputTokens: 128_000, Input: textOnly, Tools: yes, Reasoning: yes},
+	"minimax-m2":        {ContextWindow: 204_800, MaxOutputTokens: 128_000, Input: textOnly, Tools: yes, Reasoning: yes},
+}
+
+// Catalog answers what a model can do: the built-in table, overlaid by what the provider's model list
+// reported (discovery) and by config models, the most specific source winning field by field.
+// Prices come from the configured pricing, falling back to DefaultPricing.
+type Catalog struct {
+	mu         sync.RWMutex
+	overrides  map[string]ModelInfo            // config models, keyed like Pricing
+	pricing    Pricing                         // config prices
+	discovered map[string]map[string]ModelInfo // provider → model id → info
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{discovered: make(map[string]map[string]ModelInfo)}
+}
+
+// SetOverrides replaces the config models (config hot reload).
+func (c *Catalog) SetOverrides(models map[string]ModelInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overrides = models
+}
+
+// SetPricing replaces the configured prices; models not in p are priced from DefaultPricing.
+func (c *Catalog) SetPricing(p Pricing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pricing = p
+}
+
+// SetDiscovered replaces the models listed by a provider; nil forgets them.
+func (c *Catalog) SetDiscovered(provider string, models []ModelInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if models == nil {
+		delete(c.discovered, provider)
+		return
+	}
+	byID := make(map[string]ModelInfo, len(models))
+	for _, m := range models {
+		byID[m.ID] = m
+	}
+	c.discovered[provider] = byID
+}
+
+// Lookup returns what is known about a provider's model; ok is false when no source describes it
+// (the returned info then only carries a price, if one is known).
+func (c *Catalog) Lookup(provider, model string) (info ModelInfo, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lookup(provider, model)
+}
+
+func (c *Catalog) lookup(provider, model string) (ModelInfo, bool) {
+	info := ModelInfo{ID: model, Provider: provider}
+	if m, found := lookupModel(BuiltinModels, provider, model); found {
+		info = info.overlay(m, ModelSourceBuiltin)
+	}
+	if m, found := c.discovered[provider][model]; found {
+		info = info.overlay(m, ModelSourceDiscovered)
+	}
+	if m, found := lookupModel(c.overrides, provider, model); found {
+		info = info.overlay(m, ModelSourceConfig)
+	}
+	price, found := c.pricing.Lookup(provider, model)
+	if !found {
+		price, found = DefaultPricing.Lookup(provider, model)
+	}
+	if found {
+		info.Price = &price
+	}
+	return info, info.Source != ""
+}
+
+// List returns the catalog: the built-in and config entries (by key) and every discovered model,
+// sorted by provider and id. A non-empty provider keeps only that provider's discovered models.
+func (c *Catalog) List(provider string) []ModelInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []ModelInfo
+	if provider == "" {
+		seen := make(map[string]bool)
+		for _, table := range []map[string]ModelInfo{BuiltinModels, c.overrides} {
+			for key := range table {
+				if !seen[key] {
+					seen[key] = true
+					info, _ := c.lookup("", key)
+					out = append(out, info)
+				}
+			}
+		}
+	}
+	for p, models := range c.discovered {
+		if provider != "" && p != provider {
+			continue
+		}
+		for id := range models {
+			info, _ := c.lookup(p, id)
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/models" || r.Header.Get("Authorization") != "Bearer k" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":[
+			{"id":"anthropic/claude-sonnet-4.5","context_length":1000000,"architecture":{"input_modalities":["text","image","file"]},"supported_parameters":["tools","reasoning"]},
+			{"id":"acme/text-1","context_length":32768,"top_provider":{"max_completion_tokens":4096},"architecture":{"input_modalities":["text"]},"supported_parameters":["temperature"]}
+		]}`))
+	}))
+	defer srv.Close()
+
+	discovered, err := NewOpenAIClient().DiscoverModels(context.Background(), srv.URL+"/api", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCatalog()
+	c.SetDiscovered("openrouter", discovered)
+	no := false
+	c.SetOverrides(map[string]ModelInfo{"openrouter/acme/text-1": {ContextWindow: 16384}, "deepseek-chat": {Tools: &no}})
+	c.SetPricing(Pricing{"text-1": {Input: 1, Output: 2}})
+
+	// Built-in entry, priced from DefaultPricing
+	info, ok := c.Lookup("anthropic", "claude-sonnet-4-5-20250929")
+	if !ok || info.Source != ModelSourceBuiltin || info.ContextWindow != 200_000 || !info.Accepts(ModalityPDF) || info.Price == nil || info.Price.Input != 3 {
+		t.Errorf("builtin = %+v", info)
+	}
+	// Discovered values override the built-in ones
+	info, _ = c.Lookup("openrouter", "anthropic/claude-sonnet-4.5")
+	if info.Source != ModelSourceDiscovered || info.ContextWindow != 1_000_000 || info.MaxOutputTokens != 64_000 || !info.SupportsTools() {
+		t.Errorf("discovered = %+v", info)
+	}
+	// Config overrides discovery; capabilities not overridden are kept
+	info, _ = c.Lookup("openrouter", "acme/text-1")
+	if info.Source != ModelSourceConfig || info.ContextWindow != 16384 || info.MaxOutputTokens != 4096 ||
+		info.Accepts(ModalityImage) || info.SupportsTools() || info.Price == nil || info.Price.Output != 2 {
+		t.Errorf("override = %+v", info)
+	}
+	info, _ = c.Lookup("deepseek", "deepseek-chat")
+	if info.SupportsTools() || info.ContextWindow != 128_000 {
+		t.Errorf("deepseek-chat = %+v", info)
+	}
+	// Unknown models allow everything
+	if info, ok := c.Lookup("ollama", "llama3.2"); ok || !info.Accepts(ModalityImage) || !info.SupportsTools() || info.ContextWindow != 0 {
+		t.Errorf("unknown = %+v, %v", info, ok)
+	}
+
+	if got := c.List("openrouter"); len(got) != 2 || got[0].ID != "acme/text-1" {
+		t.Errorf("List(openrouter) = %+v", got)
+	}
+	if got := c.List(""); len(got) != len(BuiltinModels)+3 {
+		t.Errorf("List() has %d entries", len(got))
+	}
+}
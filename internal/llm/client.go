@@ -17,79 +17,179 @@ type ChatParams struct {
 	Messages []Message
 	Tools    []ToolDef
 	System   string // system prompt (extracted from messages for Anthropic)
+
+	ToolChoice        ToolChoice // whether and which tool the model must call; "" leaves the provider default (auto)
+	ParallelToolCalls *bool      // false: at most one tool call per reply; nil leaves the provider default
+
+	ThinkingBudget int // extended thinking budget in tokens (Anthropic); 0 disables thinking
+
+	// Generation parameters; zero values / nil are not sent and leave the provider default.
+	MaxTokens   int      // max output tokens (Anthropic defaults to 8192)
+	Temperature *float64 // sampling temperature
+	TopP        *float64 // nucleus sampling
+	Stop        []string // stop sequences
+	Seed        *int     // sampling seed (OpenAI-compatible only)
+
+	Ollama *OllamaOptions // Ollama-only settings (num_ctx, keep_alive, options.*); ignored by other clients
+
+	Azure *AzureOptions // Azure OpenAI only: api-version, deployments and Entra ID credentials
+
+	ChainResponses bool // Responses API only: reuse previous_response_id for known conversation prefixes
+
+	ResponseSchema *ResponseSchema // structured output: constrain the final answer to JSON matching this schema
+
+	Retry   RetryPolicy     // retry policy for rate-limit / overload / 5xx errors; zero value uses defaults
+	OnRetry func(RetryInfo) // optional: called before each retry wait (e.g. to tell the user we're waiting)
+}
+
+// ToolChoice controls tool calling: ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired or the name of
+// the one tool the model must call.
+type ToolChoice string
+
+const (
+	ToolChoiceAuto     ToolChoice = "auto"     // the model decides
+	ToolChoiceNone     ToolChoice = "none"     // answer in text; tools stay defined so earlier tool calls remain valid
+	ToolChoiceRequired ToolChoice = "required" // call at least one tool
+)
+
+// Forced reports whether c makes the model call a tool (required or a specific tool).
+func (c ToolChoice) Forced() bool {
+	return c != "" && c != ToolChoiceAuto && c != ToolChoiceNone
+}
+
+// Tool returns the tool name of a specific choice, or "".
+func (c ToolChoice) Tool() string {
+	if c.Forced() && c != ToolChoiceRequired {
+		return string(c)
+	}
+	return ""
 }
 
 // ConsumeStream reads all events from a stream and returns the accumulated result.
 func ConsumeStream(ctx context.Context, stream <-chan StreamEvent) (*StreamResult, error) {
-	result := &StreamResult{}
-	var textBuilder []byte
-	toolCallArgs := make(map[int]*[]byte) // index → accumulated JSON fragments
-
+	acc := NewStreamAccumulator()
 	for event := range stream {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
+		if err := acc.Add(event); err != nil {
+			return nil, err
+		}
+	}
+	return acc.Result(), nil
+}
+
+// StreamAccumulator assembles stream events into a StreamResult.
+// Used by ConsumeStream and by callers that also forward deltas as they arrive.
+type StreamAccumulator struct {
+	result    StreamResult
+	text      []byte
+	reasoning []byte
+	toolArgs  map[int]*[]byte // index → accumulated JSON fragments
+	thinking  map[int]int     // thinking block index → position in result.Thinking
+}
+
+func NewStreamAccumulator() *StreamAccumulator {
+	return &StreamAccumulator{
+		toolArgs: make(map[int]*[]byte),
+		thinking: make(map[int]int),
+	}
+}
+
+// Add folds one event into the result. It returns the event's error for "error" events.
+func (a *StreamAccumulator) Add(event StreamEvent) error {
+	result := &a.result
+	switch event.Type {
+	case "text_delta":
+		a.text = append(a.text, event.Text...)
+
+	case "reasoning_delta":
+		a.reasoning = append(a.reasoning, event.Text...)
+
+	case "thinking_delta":
+		pos, ok := a.thinking[event.ThinkingIndex]
+		if !ok {
+			pos = len(result.Thinking)
+			a.thinking[event.ThinkingIndex] = pos
+			result.Thinking = append(result.Thinking, ThinkingBlock{Type: "thinking"})
+		}
+		block := &result.Thinking[pos]
+		if event.ThinkingType != "" {
+			block.Type = event.ThinkingType
+		}
+		block.Thinking += event.Text
+		block.Signature += event.Signature
+		block.Data += event.RedactedData
+		if event.ItemID != "" {
+			block.ID = event.ItemID
+		}
 
-		switch event.Type {
-		case "text_delta":
-			textBuilder = append(textBuilder, event.Text...)
-
-		case "tool_call_delta":
-			if _, ok := toolCallArgs[event.ToolCallIndex]; !ok {
-				buf := []byte{}
-				toolCallArgs[event.ToolCallIndex] = &buf
-				result.ToolCalls = append(result.ToolCalls, ToolCall{
-					ID:   event.ToolCallID,
-					Name: event.ToolCallName,
-				})
+	case "tool_call_delta":
+		if _, ok := a.toolArgs[event.ToolCallIndex]; !ok {
+			buf := []byte{}
+			a.toolArgs[event.ToolCallIndex] = &buf
+			result.ToolCalls = append(result.ToolCalls, ToolCall{
+				ID:   event.ToolCallID,
+				Name: event.ToolCallName,
+			})
+		}
+		*a.toolArgs[event.ToolCallIndex] = append(*a.toolArgs[event.ToolCallIndex], event.ToolCallArgs...)
+		// update ID/Name if provided (first chunk usually has them)
+		idx := event.ToolCallIndex
+		if idx < len(result.ToolCalls) {
+			if event.ToolCallID != "" {
+				result.ToolCalls[idx].ID = event.ToolCallID
 			}
-			*toolCallArgs[event.ToolCallIndex] = append(*toolCallArgs[event.ToolCallIndex], event.ToolCallArgs...)
-			// update ID/Name if provided (first chunk usually has them)
-			idx := event.ToolCallIndex
-			if idx < len(result.ToolCalls) {
-				if event.ToolCallID != "" {
-					result.ToolCalls[idx].ID = event.ToolCallID
-				}
-				if event.ToolCallName != "" {
-					result.ToolCalls[idx].Name = event.ToolCallName
-				}
+			if event.ToolCallName != "" {
+				result.ToolCalls[idx].Name = event.ToolCallName
 			}
+			result.ToolCalls[idx].Signature += event.Signature
+		}
 
-		case "usage":
-			result.Usage = event.Usage
+	case "usage":
+		// Providers may report input and output tokens in separate events; sum them.
+		if result.Usage == nil {
+			result.Usage = &Usage{}
+		}
+		if event.Usage != nil {
+			result.Usage.InputTokens += event.Usage.InputTokens
+			result.Usage.OutputTokens += event.Usage.OutputTokens
+			result.Usage.CacheCreationTokens += event.Usage.CacheCreationTokens
+			result.Usage.CacheReadTokens += event.Usage.CacheReadTokens
+		}
 
-		case "error":
-			return nil, event.Error
+	case "error":
+		return event.Error
 
-		case "done":
-			result.StopReason = event.Text
-		}
+	case "done":
+		result.StopReason = event.Text
 	}
+	return nil
+}
 
-	result.Text = string(textBuilder)
+// Result returns the accumulated result with the complete assistant message.
+func (a *StreamAccumulator) Result() *StreamResult {
+	result := a.result
+	result.Text = string(a.text)
+	result.Reasoning = string(a.reasoning)
 
 	// Fill in accumulated tool call arguments
-	for idx, args := range toolCallArgs {
+	result.ToolCalls = append([]ToolCall(nil), a.result.ToolCalls...)
+	for idx, args := range a.toolArgs {
 		if idx < len(result.ToolCalls) {
 			result.ToolCalls[idx].Arguments = string(*args)
 		}
 	}
 
 	// Build the complete assistant message
-	if len(result.ToolCalls) > 0 {
-		result.Message = Message{
-			Role:      RoleAssistant,
-			Content:   result.Text,
-			ToolCalls: result.ToolCalls,
-		}
-	} else {
-		result.Message = Message{
-			Role:    RoleAssistant,
-			Content: result.Text,
-		}
+	result.Message = Message{
+		Role:      RoleAssistant,
+		Content:   result.Text,
+		ToolCalls: result.ToolCalls,
+		Thinking:  result.Thinking,
+		Reasoning: result.Reasoning,
 	}
-
-	return result, nil
+	return &result
 }
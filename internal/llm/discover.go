@@ -0,0 +1,163 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ModelDiscoverer is implemented by clients that can list the models a provider serves.
+type ModelDiscoverer interface {
+	DiscoverModels(ctx context.Context, baseURL, apiKey string) ([]ModelInfo, error)
+}
+
+// DiscoverModels lists GET /v1/models. Plain OpenAI only returns ids; OpenRouter, Groq, vLLM and
+// similar routers also report context length, input modalities and supported parameters.
+func (c *OpenAIClient) DiscoverModels(ctx context.Context, baseURL, apiKey string) ([]ModelInfo, error) {
+	return discoverOpenAIModels(ctx, c.HTTPClient, baseURL, apiKey)
+}
+
+func (c *ResponsesClient) DiscoverModels(ctx context.Context, baseURL, apiKey string) ([]ModelInfo, error) {
+	return discoverOpenAIModels(ctx, c.HTTPClient, baseURL, apiKey)
+}
+
+func discoverOpenAIModels(ctx context.Context, hc *http.Client, baseURL, apiKey string) ([]ModelInfo, error) {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+	var out struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"` // OpenRouter, Together
+			ContextWindow int    `json:"context_window"` // Groq
+			MaxModelLen   int    `json:"max_model_len"`  // vLLM
+			TopProvider   struct {
+				MaxCompletionTokens int `json:"max_completion_tokens"`
+			} `json:"top_provider"`
+			Architecture struct {
+				InputModalities []string `json:"input_modalities"`
+			} `json:"architecture"`
+			SupportedParameters []string `json:"supported_parameters"`
+		} `json:"data"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + apiKey}}
+	if err := getJSON(ctx, hc, strings.TrimRight(baseURL, "/")+"/v1/models", header, &out); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, 0, len(out.Data))
+	for _, d := range out.Data {
+		m := ModelInfo{ID: d.ID, MaxOutputTokens: d.TopProvider.MaxCompletionTokens}
+		m.ContextWindow = max(d.ContextLength, d.ContextWindow, d.MaxModelLen)
+		for _, in := range d.Architecture.InputModalities {
+			switch in {
+			case "text", "image":
+				m.Input = append(m.Input, in)
+			case "file":
+				m.Input = append(m.Input, ModalityPDF)
+			}
+		}
+		if params := d.SupportedParameters; len(params) > 0 {
+			m.Tools = boolPtr(slices.Contains(params, "tools"))
+			m.Reasoning = boolPtr(slices.Contains(params, "reasoning"))
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
+
+// DiscoverModels lists GET /v1/models (ids only).
+func (c *AnthropicClient) DiscoverModels(ctx context.Context, baseURL, apiKey string) ([]ModelInfo, error) {
+	if baseURL == "" {
+		baseURL = anthropicAPIURL
+	}
+	var out struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	header := http.Header{"x-api-key": {apiKey}, "anthropic-version": {anthropicAPIVersion}}
+	if err := getJSON(ctx, c.HTTPClient, strings.TrimRight(baseURL, "/")+"/v1/models?limit=1000", header, &out); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, 0, len(out.Data))
+	for _, d := range out.Data {
+		models = append(models, ModelInfo{ID: d.ID})
+	}
+	return models, nil
+}
+
+// DiscoverModels lists the models that support generateContent, with their token limits.
+func (c *GeminiClient) DiscoverModels(ctx context.Context, baseURL, apiKey string) ([]ModelInfo, error) {
+	endpoint := geminiAPIURL
+	if baseURL != "" {
+		endpoint = baseURL
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1beta") && !strings.HasSuffix(endpoint, "/v1") {
+		endpoint += "/v1beta"
+	}
+	var out struct {
+		Models []struct {
+			Name                       string   `json:"name"` // "models/gemini-2.5-pro"
+			InputTokenLimit            int      `json:"inputTokenLimit"`
+			OutputTokenLimit           int      `json:"outputTokenLimit"`
+			SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			Thinking                   bool     `json:"thinking"`
+		} `json:"models"`
+	}
+	if err := getJSON(ctx, c.HTTPClient, endpoint+"/models?pageSize=1000", http.Header{"x-goog-api-key": {apiKey}}, &out); err != nil {
+		return nil, err
+	}
+	var models []ModelInfo
+	for _, d := range out.Models {
+		if !slices.Contains(d.SupportedGenerationMethods, "generateContent") {
+			continue
+		}
+		m := ModelInfo{ID: strings.TrimPrefix(d.Name, "models/"), ContextWindow: d.InputTokenLimit, MaxOutputTokens: d.OutputTokenLimit}
+		if d.Thinking {
+			m.Reasoning = boolPtr(true)
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
+
+// DiscoverModels lists the locally installed models (ids only).
+func (c *OllamaClient) DiscoverModels(ctx context.Context, baseURL, _ string) ([]ModelInfo, error) {
+	installed, err := c.ListModels(ctx, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, 0, len(installed))
+	for _, m := range installed {
+		models = append(models, ModelInfo{ID: m.Name})
+	}
+	return models, nil
+}
+
+func getJSON(ctx context.Context, hc *http.Client, url string, header http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return &TransportError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(resp.Body)
+		return NewAPIError(resp.StatusCode, string(errBody))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode models: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies a provider error so callers can react without parsing bodies:
+// compaction on context_length, retries on rate_limit/overloaded/server, failover on provider-side failures.
+type ErrorKind string
+
+const (
+	ErrorKindUnknown        ErrorKind = "unknown"
+	ErrorKindContextLength  ErrorKind = "context_length"  // prompt exceeds the model's context window
+	ErrorKindInvalidRequest ErrorKind = "invalid_request" // malformed request (bad tool schema, invalid image, ...)
+	ErrorKindNotFound       ErrorKind = "not_found"       // unknown model or endpoint
+	ErrorKindAuth           ErrorKind = "auth"            // missing/invalid API key or no permission
+	ErrorKindQuota          ErrorKind = "quota"           // billing quota or balance exhausted
+	ErrorKindRateLimit      ErrorKind = "rate_limit"
+	ErrorKindOverloaded     ErrorKind = "overloaded" // provider overloaded or unavailable (503, Anthropic 529)
+	ErrorKindServer         ErrorKind = "server"     // other 5xx and timeouts
+	ErrorKindContentFilter  ErrorKind = "content_filter"
+)
+
+// APIError represents an error reported by the LLM provider, either as an HTTP error response
+// or as an error event inside the stream (StatusCode 0).
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // server-requested wait from Retry-After / rate-limit headers, 0 if none
+
+	Kind    ErrorKind // classified from status and body
+	Type    string    // provider error type or code, e.g. "invalid_request_error", "context_length_exceeded"
+	Message string    // provider error message, empty if the body could not be parsed
+}
+
+// NewAPIError builds an APIError from a status code (0 for stream errors) and the raw error body,
+// parsing OpenAI, Anthropic, Gemini, DeepSeek, MiniMax and Ollama error formats.
+func NewAPIError(statusCode int, body string) *APIError {
+	e := &APIError{StatusCode: statusCode, Body: body}
+	var bizCode int
+	e.Type, e.Message, bizCode = parseErrorBody(body)
+	e.Kind = classifyError(statusCode, e.Type, e.Message, bizCode)
+	return e
+}
+
+func (e *APIError) Error() string {
+	detail := e.Message
+	if detail == "" {
+		detail = e.Body
+	}
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("LLM stream error (%s): %s", e.Kind, detail)
+	}
+	return fmt.Sprintf("LLM API error (status %d, %s): %s", e.StatusCode, e.Kind, detail)
+}
+
+// IsRateLimit returns true if this is a rate limit error.
+func (e *APIError) IsRateLimit() bool { return e.Kind == ErrorKindRateLimit }
+
+// IsOverloaded returns true if the provider reports being overloaded or unavailable (503, Anthropic 529).
+func (e *APIError) IsOverloaded() bool { return e.Kind == ErrorKindOverloaded }
+
+// IsRetryable returns true for errors that may succeed on retry: timeouts, rate limits, overload and 5xx.
+func (e *APIError) IsRetryable() bool {
+	return e.Kind == ErrorKindRateLimit || e.Kind == ErrorKindOverloaded || e.Kind == ErrorKindServer
+}
+
+// IsAuth returns true if this is an authentication error.
+func (e *APIError) IsAuth() bool { return e.Kind == ErrorKindAuth }
+
+// IsContextOverflow returns true if the prompt exceeds the model's context window.
+func (e *APIError) IsContextOverflow() bool { return e.Kind == ErrorKindContextLength }
+
+// ErrorKindOf returns the kind of a provider error anywhere in err's chain, or "" if err is not an APIError.
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ""
+}
+
+// IsContextOverflow reports whether err is a provider context-length error.
+func IsContextOverflow(err error) bool { return ErrorKindOf(err) == ErrorKindContextLength }
+
+// providerErrorBody covers the error envelopes in use:
+// OpenAI/DeepSeek {"error":{"message","type","code"}}, Anthropic {"type":"error","error":{"type","message"}},
+// Gemini {"error":{"code","message","status"}}, Responses stream {"type":"error","code","message"},
+// MiniMax {"base_resp":{"status_code","status_msg"}} and Ollama {"error":"..."}.
+type providerErrorBody struct {
+	Error    json.RawMessage `json:"error"`
+	Type     string          `json:"type"`
+	Code     json.RawMessage `json:"code"`
+	Message  string          `json:"message"`
+	BaseResp *struct {
+		StatusCode int    `json:"status_code"`
+		StatusMsg  string `json:"status_msg"`
+	} `json:"base_resp"`
+}
+
+type providerErrorDetail struct {
+	Message string          `json:"message"`
+	Type    string          `json:"type"`
+	Code    json.RawMessage `json:"code"` // string (OpenAI) or number (Gemini)
+	Status  string          `json:"status"`
+}
+
+// parseErrorBody extracts the error type/code, message and MiniMax business code from an error body.
+func parseErrorBody(body string) (typ, message string, bizCode int) {
+	raw := strings.TrimSpace(body)
+	if strings.HasPrefix(raw, "[") { // Gemini sometimes wraps the error in an array
+		var list []json.RawMessage
+		if json.Unmarshal([]byte(raw), &list) == nil && len(list) > 0 {
+			raw = string(list[0])
+		}
+	}
+	var b providerErrorBody
+	if json.Unmarshal([]byte(raw), &b) != nil {
+		return "", "", 0
+	}
+	if b.BaseResp != nil && b.BaseResp.StatusCode != 0 {
+		return fmt.Sprint(b.BaseResp.StatusCode), b.BaseResp.StatusMsg, b.BaseResp.StatusCode
+	}
+	if len(b.Error) > 0 {
+		var s string
+		if json.Unmarshal(b.Error, &s) == nil {
+			return "", s, 0
+		}
+		var d providerErrorDetail
+		if json.Unmarshal(b.Error, &d) == nil {
+			// The most specific identifier wins: OpenAI code, then Anthropic/OpenAI type, then Gemini status.
+			typ = jsonString(d.Code)
+			if typ == "" {
+				typ = d.Type
+			}
+			if typ == "" {
+				typ = d.Status
+			}
+			return typ, d.Message, 0
+		}
+	}
+	typ = jsonString(b.Code)
+	if typ == "" && b.Type != "error" {
+		typ = b.Type
+	}
+	return typ, b.Message, 0
+}
+
+// jsonString returns a JSON string value, or "" for numbers, null and anything else.
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
+
+// classifyError maps status, provider type/code and message to an ErrorKind.
+// Explicit codes win over message matching, which only disambiguates generic 400s and stream errors.
+func classifyError(status int, typ, message string, bizCode int) ErrorKind {
+	switch bizCode { // MiniMax
+	case 1002:
+		return ErrorKindRateLimit
+	case 1004, 2049:
+		return ErrorKindAuth
+	case 1008:
+		return ErrorKindQuota
+	case 1026, 1027:
+		return ErrorKindContentFilter
+	case 1039:
+		return ErrorKindContextLength
+	case 2013:
+		return ErrorKindInvalidRequest
+	case 1000, 1013:
+		return ErrorKindServer
+	}
+
+	switch strings.ToLower(typ) {
+	case "context_length_exceeded", "request_too_large":
+		return ErrorKindContextLength
+	case "insufficient_quota", "billing_error", "billing_hard_limit_reached":
+		return ErrorKindQuota
+	case "content_filter", "content_policy_violation", "prohibited_content":
+		return ErrorKindContentFilter
+	case "authentication_error", "permission_error", "invalid_api_key", "unauthenticated", "permission_denied":
+		return ErrorKindAuth
+	case "rate_limit_error", "rate_limit_exceeded", "resource_exhausted":
+		return ErrorKindRateLimit
+	case "overloaded_error", "unavailable", "server_is_overloaded", "slow_down":
+		return ErrorKindOverloaded
+	case "model_not_found", "not_found_error", "not_found":
+		return ErrorKindNotFound
+	case "api_error", "server_error", "internal", "deadline_exceeded":
+		return ErrorKindServer
+	}
+
+	switch {
+	case status == 401 || status == 403:
+		return ErrorKindAuth
+	case status == 402:
+		return ErrorKindQuota
+	case status == 413:
+		return ErrorKindContextLength
+	case status == 429:
+		return ErrorKindRateLimit
+	case status == 503 || status == 529:
+		return ErrorKindOverloaded
+	case status == 408 || status >= 500:
+		return ErrorKindServer
+	}
+
+	msg := strings.ToLower(message)
+	switch {
+	case containsAny(msg, contextLengthPhrases):
+		return ErrorKindContextLength
+	case containsAny(msg, contentFilterPhrases):
+		return ErrorKindContentFilter
+	case status == 404:
+		return ErrorKindNotFound
+	case status >= 400 && status < 500:
+		return ErrorKindInvalidRequest
+	}
+	return ErrorKindUnknown
+}
+
+// contextLengthPhrases match context overflow messages that come without a dedicated code, e.g. Anthropic
+// "prompt is too long: 208000 tokens > 200000 maximum" or DeepSeek "This model's maximum context length is ...".
+var contextLengthPhrases = []string{
+	"maximum context length",
+	"context length",
+	"context_length_exceeded",
+	"context window",
+	"prompt is too long",
+	"input is too long",
+	"exceed context limit",
+	"exceeds the context",
+	"exceeds the maximum number of tokens",
+	"input token count",
+	"reduce the length of the messages",
+	"too many tokens",
+}
+
+var contentFilterPhrases = []string{
+	"content management policy",
+	"content_policy",
+	"content policy",
+	"content_filter",
+	"sensitive content",
+}
+
+func containsAny(s string, phrases []string) bool {
+	for _, p := range phrases {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
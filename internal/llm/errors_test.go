@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewAPIErrorKinds(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   ErrorKind
+	}{
+		{"openai context", 400, `{"error":{"message":"This model's maximum context length is 128000 tokens. However, your messages resulted in 130000 tokens.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`, ErrorKindContextLength},
+		{"openai bad tool schema", 400, `{"error":{"message":"Invalid schema for function 'read_file': 'object' is not valid under any of the given schemas.","type":"invalid_request_error","param":"tools[0].function.parameters","code":"invalid_function_parameters"}}`, ErrorKindInvalidRequest},
+		{"openai invalid image", 400, `{"error":{"message":"You uploaded an unsupported image. Please make sure your image is valid.","type":"invalid_request_error","param":null,"code":"invalid_image_format"}}`, ErrorKindInvalidRequest},
+		{"openai quota", 429, `{"error":{"message":"You exceeded your current quota.","type":"insufficient_quota","param":null,"code":"insufficient_quota"}}`, ErrorKindQuota},
+		{"openai rate limit", 429, `{"error":{"message":"Rate limit reached for gpt-4o on tokens per min (TPM)","type":"tokens","code":"rate_limit_exceeded"}}`, ErrorKindRateLimit},
+		{"openai content filter", 400, `{"error":{"message":"The response was filtered due to the prompt triggering Azure OpenAI's content management policy.","type":null,"param":"prompt","code":"content_filter"}}`, ErrorKindContentFilter},
+		{"anthropic prompt too long", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 208310 tokens > 200000 maximum"}}`, ErrorKindContextLength},
+		{"anthropic request too large", 413, `{"type":"error","error":{"type":"request_too_large","message":"Request exceeds the maximum allowed number of bytes."}}`, ErrorKindContextLength},
+		{"anthropic overloaded", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, ErrorKindOverloaded},
+		{"anthropic auth", 401, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, ErrorKindAuth},
+		{"anthropic stream overloaded", 0, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, ErrorKindOverloaded},
+		{"deepseek context", 400, `{"error":{"message":"This model's maximum context length is 65536 tokens. However, you requested 70123 tokens (66027 in the messages, 4096 in the completion).","type":"invalid_request_error","param":null,"code":"invalid_request_error"}}`, ErrorKindContextLength},
+		{"deepseek balance", 402, `{"error":{"message":"Insufficient Balance","type":"unknown_error","param":null,"code":"invalid_request_error"}}`, ErrorKindQuota},
+		{"minimax token limit", 200, `{"base_resp":{"status_code":1039,"status_msg":"token limit exceeded"}}`, ErrorKindContextLength},
+		{"minimax sensitive", 200, `{"base_resp":{"status_code":1026,"status_msg":"input new_sensitive"}}`, ErrorKindContentFilter},
+		{"gemini context", 400, `{"error":{"code":400,"message":"The input token count (1200000) exceeds the maximum number of tokens allowed (1048576).","status":"INVALID_ARGUMENT"}}`, ErrorKindContextLength},
+		{"gemini exhausted", 429, `[{"error":{"code":429,"message":"Resource has been exhausted","status":"RESOURCE_EXHAUSTED"}}]`, ErrorKindRateLimit},
+		{"responses stream context", 0, `{"type":"error","code":"context_length_exceeded","message":"Your input exceeds the context window of this model."}`, ErrorKindContextLength},
+		{"model not found", 404, `{"error":{"message":"The model 'gpt-9' does not exist","type":"invalid_request_error","code":"model_not_found"}}`, ErrorKindNotFound},
+		{"plain 502", 502, `<html>Bad Gateway</html>`, ErrorKindServer},
+		{"ollama", 0, `{"error":"model requires more system memory"}`, ErrorKindUnknown},
+	}
+	for _, c := range cases {
+		if got := NewAPIError(c.status, c.body).Kind; got != c.want {
+			t.Errorf("%s: kind = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAPIErrorRetryAndOverflow(t *testing.T) {
+	overflow := fmt.Errorf("call: %w", NewAPIError(400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`))
+	if !IsContextOverflow(overflow) || IsRetryable(overflow) {
+		t.Errorf("prompt too long: overflow=%v retryable=%v", IsContextOverflow(overflow), IsRetryable(overflow))
+	}
+	badImage := NewAPIError(400, `{"type":"error","error":{"type":"invalid_request_error","message":"messages.0.content.1.image.source.base64: invalid base64 data"}}`)
+	if badImage.IsContextOverflow() || badImage.IsRetryable() {
+		t.Errorf("invalid image classified as %q", badImage.Kind)
+	}
+	if got := badImage.Error(); got != "LLM API error (status 400, invalid_request): messages.0.content.1.image.source.base64: invalid base64 data" {
+		t.Errorf("Error() = %q", got)
+	}
+	if !NewAPIError(503, "").IsRetryable() || NewAPIError(429, `{"error":{"code":"insufficient_quota","message":"quota"}}`).IsRetryable() {
+		t.Error("503 should retry, insufficient_quota should not")
+	}
+}
@@ -0,0 +1,455 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const geminiAPIURL = "https://generativelanguage.googleapis.com"
+
+// GeminiClient implements Client for Google's native Gemini API (generateContent / streamGenerateContent).
+type GeminiClient struct {
+	HTTPClient *http.Client
+}
+
+func NewGeminiClient() *GeminiClient {
+	return &GeminiClient{HTTPClient: http.DefaultClient}
+}
+
+func (c *GeminiClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	endpoint := geminiAPIURL
+	if params.BaseURL != "" {
+		endpoint = params.BaseURL
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1beta") && !strings.HasSuffix(endpoint, "/v1") {
+		endpoint += "/v1beta"
+	}
+	endpoint += "/models/" + url.PathEscape(params.Model) + ":streamGenerateContent?alt=sse"
+
+	// fileData only takes Gemini Files API / GCS URIs: inline web images and PDFs.
+	params.Messages = inlineMedia(ctx, params.Messages, true, true)
+	body := c.buildRequest(params)
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	resp, err := doWithRetry(ctx, c.HTTPClient, params, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-goog-api-key", params.APIKey)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 32)
+	go c.consumeSSE(resp.Body, ch)
+	return ch, nil
+}
+
+var geminiMedia = mediaSupport{pdf: true, imageURLs: true}
+
+func (c *GeminiClient) buildRequest(params ChatParams) map[string]any {
+	// Gemini matches function responses to calls by name, so remember each call ID's tool name.
+	callNames := make(map[string]string)
+	for _, msg := range params.Messages {
+		for _, tc := range msg.ToolCalls {
+			callNames[tc.ID] = tc.Name
+		}
+	}
+
+	contents := make([]map[string]any, 0, len(params.Messages))
+	for _, msg := range params.Messages {
+		switch msg.Role {
+		case RoleSystem:
+			// Gemini handles system via systemInstruction, skip in contents
+			continue
+		case RoleUser:
+			var parts []map[string]any
+			if text := userText(msg, geminiMedia); text != "" {
+				parts = append(parts, map[string]any{"text": text})
+			}
+			for _, doc := range pdfDocuments(msg) {
+				parts = append(parts, map[string]any{
+					"inlineData": map[string]any{"mimeType": doc.MIME, "data": doc.Base64},
+				})
+			}
+			for _, img := range msg.Images {
+				mime := img.MIME
+				if mime == "" {
+					mime = "image/png"
+				}
+				if img.Base64 != "" {
+					parts = append(parts, map[string]any{
+						"inlineData": map[string]any{"mimeType": mime, "data": img.Base64},
+					})
+				} else if img.URL != "" {
+					parts = append(parts, map[string]any{
+						"fileData": map[string]any{"mimeType": mime, "fileUri": img.URL},
+					})
+				}
+			}
+			if len(parts) == 0 {
+				parts = append(parts, map[string]any{"text": ""})
+			}
+			contents = append(contents, map[string]any{"role": "user", "parts": parts})
+		case RoleAssistant:
+			var parts []map[string]any
+			if msg.Content != "" {
+				parts = append(parts, map[string]any{"text": msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				var args any
+				_ = json.Unmarshal([]byte(tc.Arguments), &args)
+				if args == nil {
+					args = map[string]any{}
+				}
+				part := map[string]any{
+					"functionCall": map[string]any{"name": tc.Name, "args": args},
+				}
+				if tc.Signature != "" {
+					part["thoughtSignature"] = tc.Signature
+				}
+				parts = append(parts, part)
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			contents = append(contents, map[string]any{"role": "model", "parts": parts})
+		case RoleTool:
+			part := map[string]any{
+				"functionResponse": map[string]any{
+					"name":     callNames[msg.ToolCallID],
+					"response": geminiFunctionResponse(msg.Content),
+				},
+			}
+			// All responses to one model turn go into a single user turn, in call order.
+			if n := len(contents); n > 0 && contents[n-1]["role"] == "user" && geminiIsFunctionResponseTurn(contents[n-1]) {
+				contents[n-1]["parts"] = append(contents[n-1]["parts"].([]map[string]any), part)
+				continue
+			}
+			contents = append(contents, map[string]any{"role": "user", "parts": []map[string]any{part}})
+		}
+	}
+
+	req := map[string]any{"contents": contents}
+
+	if params.System != "" {
+		req["systemInstruction"] = map[string]any{
+			"parts": []map[string]any{{"text": params.System}},
+		}
+	}
+
+	if len(params.Tools) > 0 {
+		decls := make([]map[string]any, len(params.Tools))
+		for i, t := range params.Tools {
+			decl := map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+			}
+			if schema := geminiSchema(t.Parameters); schema != nil {
+				decl["parameters"] = schema
+			}
+			decls[i] = decl
+		}
+		req["tools"] = []map[string]any{{"functionDeclarations": decls}}
+		// Gemini has no parallel_tool_calls switch; ParallelToolCalls is ignored.
+		switch choice := params.ToolChoice; {
+		case choice == ToolChoiceAuto || choice == ToolChoiceNone:
+			req["toolConfig"] = map[string]any{"functionCallingConfig": map[string]any{"mode": strings.ToUpper(string(choice))}}
+		case choice.Forced():
+			config := map[string]any{"mode": "ANY"}
+			if name := choice.Tool(); name != "" {
+				config["allowedFunctionNames"] = []string{name}
+			}
+			req["toolConfig"] = map[string]any{"functionCallingConfig": config}
+		}
+	}
+
+	gen := map[string]any{}
+	if params.MaxTokens > 0 {
+		gen["maxOutputTokens"] = params.MaxTokens
+	}
+	if params.Temperature != nil {
+		gen["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		gen["topP"] = *params.TopP
+	}
+	if len(params.Stop) > 0 {
+		gen["stopSequences"] = params.Stop
+	}
+	if params.Seed != nil {
+		gen["seed"] = *params.Seed
+	}
+	if params.ThinkingBudget > 0 {
+		gen["thinkingConfig"] = map[string]any{
+			"thinkingBudget":  params.ThinkingBudget,
+			"includeThoughts": true,
+		}
+	}
+	// Native JSON mode cannot be combined with function calling; with tools the answer is
+	// steered by the system prompt and validated by the caller instead.
+	if rs := params.ResponseSchema; rs != nil && len(params.Tools) == 0 {
+		var schema any
+		if err := json.Unmarshal(rs.Schema, &schema); err == nil {
+			gen["responseMimeType"] = "application/json"
+			gen["responseSchema"] = sanitizeGeminiSchema(schema)
+		}
+	}
+	if len(gen) > 0 {
+		req["generationConfig"] = gen
+	}
+
+	return req
+}
+
+func geminiIsFunctionResponseTurn(content map[string]any) bool {
+	parts, _ := content["parts"].([]map[string]any)
+	if len(parts) == 0 {
+		return false
+	}
+	_, ok := parts[0]["functionResponse"]
+	return ok
+}
+
+// geminiFunctionResponse wraps a tool result as the JSON object Gemini requires:
+// JSON objects are passed through, anything else becomes {"result": ...}.
+func geminiFunctionResponse(content string) map[string]any {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(content), &obj); err == nil && obj != nil {
+		return obj
+	}
+	return map[string]any{"result": content}
+}
+
+// geminiSchemaKeys are the JSON Schema keywords Gemini's OpenAPI-subset Schema accepts.
+var geminiSchemaKeys = map[string]bool{
+	"type": true, "format": true, "title": true, "description": true, "nullable": true, "enum": true,
+	"items": true, "minItems": true, "maxItems": true, "properties": true, "required": true,
+	"minProperties": true, "maxProperties": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "anyOf": true, "default": true, "example": true, "propertyOrdering": true,
+}
+
+// geminiSchema converts a tool's JSON Schema into Gemini's Schema subset. It returns nil when the
+// tool takes no parameters, since Gemini rejects OBJECT schemas with empty properties.
+func geminiSchema(raw json.RawMessage) map[string]any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil
+	}
+	out, _ := sanitizeGeminiSchema(schema).(map[string]any)
+	if props, _ := out["properties"].(map[string]any); len(props) == 0 {
+		return nil
+	}
+	return out
+}
+
+func sanitizeGeminiSchema(v any) any {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	out := make(map[string]any, len(m))
+	for k, val := range m {
+		if !geminiSchemaKeys[k] {
+			continue
+		}
+		switch k {
+		case "type":
+			// ["string", "null"] → "string" + nullable
+			if types, ok := val.([]any); ok {
+				for _, t := range types {
+					if t == "null" {
+						out["nullable"] = true
+					} else if _, set := out["type"]; !set {
+						out["type"] = t
+					}
+				}
+				continue
+			}
+			out[k] = val
+		case "properties":
+			props, _ := val.(map[string]any)
+			if len(props) == 0 {
+				continue
+			}
+			clean := make(map[string]any, len(props))
+			for name, p := range props {
+				clean[name] = sanitizeGeminiSchema(p)
+			}
+			out[k] = clean
+		case "items":
+			out[k] = sanitizeGeminiSchema(val)
+		case "anyOf":
+			list, _ := val.([]any)
+			clean := make([]any, len(list))
+			for i, s := range list {
+				clean[i] = sanitizeGeminiSchema(s)
+			}
+			out[k] = clean
+		default:
+			out[k] = val
+		}
+	}
+	return out
+}
+
+// geminiCallSeq makes generated tool call IDs unique within the process.
+var geminiCallSeq atomic.Uint64
+
+func (c *GeminiClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent) {
+	defer close(out)
+	defer body.Close()
+
+	// usageMetadata is cumulative and repeated on every chunk; report the last one when the stream ends.
+	var usage *Usage
+	var finishReason string
+	var toolIndex int
+	thinkingStarted := false
+
+	for event := range ParseSSE(body) {
+		if event.Err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("read stream: %w", event.Err)}
+			return
+		}
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("parse chunk: %w", err)}
+			return
+		}
+		if chunk.Error != nil {
+			out <- StreamEvent{Type: "error", Error: NewAPIError(chunk.Error.Code, event.Data)}
+			return
+		}
+		if chunk.UsageMetadata != nil {
+			usage = chunk.UsageMetadata.toUsage()
+		}
+		if len(chunk.Candidates) == 0 {
+			if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+				finishReason = strings.ToLower(chunk.PromptFeedback.BlockReason)
+			}
+			continue
+		}
+
+		cand := chunk.Candidates[0]
+		for _, part := range cand.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				id := part.FunctionCall.ID
+				if id == "" {
+					id = fmt.Sprintf("call_%d_%d", time.Now().UnixMilli(), geminiCallSeq.Add(1))
+				}
+				args := part.FunctionCall.Args
+				if len(args) == 0 || string(args) == "null" {
+					args = json.RawMessage("{}")
+				}
+				out <- StreamEvent{
+					Type:          "tool_call_delta",
+					ToolCallIndex: toolIndex,
+					ToolCallID:    id,
+					ToolCallName:  part.FunctionCall.Name,
+					ToolCallArgs:  string(args),
+					Signature:     part.ThoughtSignature,
+				}
+				toolIndex++
+			case part.Thought:
+				ev := StreamEvent{Type: "thinking_delta", Text: part.Text}
+				if !thinkingStarted {
+					ev.ThinkingType = "thinking"
+					thinkingStarted = true
+				}
+				out <- ev
+			case part.Text != "":
+				out <- StreamEvent{Type: "text_delta", Text: part.Text}
+			}
+		}
+		if cand.FinishReason != "" {
+			finishReason = geminiStopReason(cand.FinishReason)
+		}
+	}
+
+	if usage != nil {
+		out <- StreamEvent{Type: "usage", Usage: usage}
+	}
+	if finishReason != "" {
+		out <- StreamEvent{Type: "done", Text: finishReason}
+	}
+}
+
+// geminiStopReason maps Gemini finish reasons to the stop reasons the rest of aido understands.
+func geminiStopReason(reason string) string {
+	switch reason {
+	case "STOP":
+		return "end_turn"
+	case "MAX_TOKENS":
+		return "max_tokens"
+	}
+	return strings.ToLower(reason)
+}
+
+// Gemini streaming response types
+
+type geminiResponse struct {
+	Candidates     []geminiCandidate `json:"candidates"`
+	UsageMetadata  *geminiUsage      `json:"usageMetadata,omitempty"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error,omitempty"`
+}
+
+type geminiCandidate struct {
+	Content struct {
+		Parts []geminiPart `json:"parts"`
+	} `json:"content"`
+	FinishReason string `json:"finishReason"`
+}
+
+type geminiPart struct {
+	Text             string `json:"text"`
+	Thought          bool   `json:"thought"`
+	ThoughtSignature string `json:"thoughtSignature"`
+	FunctionCall     *struct {
+		ID   string          `json:"id"`
+		Name string          `json:"name"`
+		Args json.RawMessage `json:"args"`
+	} `json:"functionCall,omitempty"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+}
+
+// toUsage converts to Usage: cached tokens move out of InputTokens, thoughts count as output.
+func (u *geminiUsage) toUsage() *Usage {
+	return &Usage{
+		InputTokens:     u.PromptTokenCount - u.CachedContentTokenCount,
+		OutputTokens:    u.CandidatesTokenCount + u.ThoughtsTokenCount,
+		CacheReadTokens: u.CachedContentTokenCount,
+	}
+}
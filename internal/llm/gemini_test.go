@@ -0,0 +1,233 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeGemini serves the given SSE chunks and records the last request it received.
+type fakeGemini struct {
+	chunks []string
+	status int
+
+	path   string
+	apiKey string
+	body   map[string]any
+}
+
+func (f *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.path = r.URL.Path + "?" + r.URL.RawQuery
+	f.apiKey = r.Header.Get("x-goog-api-key")
+	raw, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(raw, &f.body)
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, `{"error":{"code":400,"message":"bad request","status":"INVALID_ARGUMENT"}}`)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, c := range f.chunks {
+		fmt.Fprintf(w, "data: %s\r\n\r\n", c)
+	}
+}
+
+func newFakeGemini(t *testing.T, f *fakeGemini) ChatParams {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return ChatParams{
+		Model:   "gemini-2.5-flash",
+		APIKey:  "test-key",
+		BaseURL: srv.URL,
+		Retry:   RetryPolicy{MaxRetries: -1},
+	}
+}
+
+func TestGeminiStreamsTextAndUsage(t *testing.T) {
+	f := &fakeGemini{chunks: []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}],"usageMetadata":{"promptTokenCount":10}}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":3,"thoughtsTokenCount":2,"cachedContentTokenCount":4}}`,
+	}}
+	params := newFakeGemini(t, f)
+	params.System = "be brief"
+	params.Messages = []Message{UserMessage("hi")}
+
+	stream, err := NewGeminiClient().Chat(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	result, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("ConsumeStream: %v", err)
+	}
+
+	if f.path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent?alt=sse" {
+		t.Errorf("path = %q", f.path)
+	}
+	if f.apiKey != "test-key" {
+		t.Errorf("x-goog-api-key = %q", f.apiKey)
+	}
+	if got := f.body["systemInstruction"]; got == nil {
+		t.Errorf("systemInstruction missing from request")
+	}
+	if result.Text != "Hello" {
+		t.Errorf("text = %q, want %q", result.Text, "Hello")
+	}
+	if result.StopReason != "end_turn" {
+		t.Errorf("stop reason = %q, want end_turn", result.StopReason)
+	}
+	want := Usage{InputTokens: 6, OutputTokens: 5, CacheReadTokens: 4}
+	if result.Usage == nil || *result.Usage != want {
+		t.Errorf("usage = %+v, want %+v", result.Usage, want)
+	}
+}
+
+func TestGeminiFunctionCall(t *testing.T) {
+	f := &fakeGemini{chunks: []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"thought":true,"text":"need weather"},{"functionCall":{"name":"get_weather","args":{"city":"Paris"}},"thoughtSignature":"sig-1"}]},"finishReason":"STOP"}]}`,
+	}}
+	params := newFakeGemini(t, f)
+	params.Messages = []Message{UserMessage("weather in Paris?")}
+
+	stream, err := NewGeminiClient().Chat(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	result, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("ConsumeStream: %v", err)
+	}
+
+	if len(result.ToolCalls) != 1 {
+		t.Fatalf("tool calls = %d, want 1", len(result.ToolCalls))
+	}
+	tc := result.ToolCalls[0]
+	if tc.Name != "get_weather" || tc.Arguments != `{"city":"Paris"}` || tc.Signature != "sig-1" {
+		t.Errorf("tool call = %+v", tc)
+	}
+	if tc.ID == "" {
+		t.Errorf("tool call ID not generated")
+	}
+	if len(result.Thinking) != 1 || result.Thinking[0].Thinking != "need weather" {
+		t.Errorf("thinking = %+v", result.Thinking)
+	}
+	if result.Text != "" {
+		t.Errorf("thought text leaked into reply: %q", result.Text)
+	}
+}
+
+func TestGeminiBuildRequest(t *testing.T) {
+	params := ChatParams{
+		Messages: []Message{
+			UserMessageWithImages("what is this?", []ImageData{{Base64: "AAAA", MIME: "image/jpeg"}, {URL: "gs://bucket/cat.png"}}),
+			{Role: RoleAssistant, ToolCalls: []ToolCall{
+				{ID: "c1", Name: "lookup", Arguments: `{"q":"cat"}`, Signature: "sig"},
+				{ID: "c2", Name: "search", Arguments: `{}`},
+			}},
+			ToolResultMessage("c1", `{"found":true}`),
+			ToolResultMessage("c2", "plain text"),
+		},
+		Tools: []ToolDef{
+			{Name: "lookup", Parameters: json.RawMessage(`{"type":"object","additionalProperties":false,"properties":{"q":{"type":["string","null"]}},"required":["q"]}`)},
+			{Name: "now", Parameters: json.RawMessage(`{"type":"object","properties":{}}`)},
+		},
+		MaxTokens: 100,
+	}
+	req := NewGeminiClient().buildRequest(params)
+	raw, _ := json.Marshal(req)
+	var got struct {
+		Contents []struct {
+			Role  string           `json:"role"`
+			Parts []map[string]any `json:"parts"`
+		} `json:"contents"`
+		Tools []struct {
+			FunctionDeclarations []map[string]any `json:"functionDeclarations"`
+		} `json:"tools"`
+		GenerationConfig map[string]any `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Contents) != 3 {
+		t.Fatalf("contents = %d, want 3 (user, model, merged function responses): %s", len(got.Contents), raw)
+	}
+	user := got.Contents[0]
+	if len(user.Parts) != 3 || user.Parts[1]["inlineData"] == nil || user.Parts[2]["fileData"] == nil {
+		t.Errorf("user parts = %v", user.Parts)
+	}
+	model := got.Contents[1]
+	if model.Role != "model" || model.Parts[0]["thoughtSignature"] != "sig" {
+		t.Errorf("model turn = %+v", model)
+	}
+	responses := got.Contents[2]
+	if responses.Role != "user" || len(responses.Parts) != 2 {
+		t.Fatalf("function responses = %+v", responses)
+	}
+	first := responses.Parts[0]["functionResponse"].(map[string]any)
+	second := responses.Parts[1]["functionResponse"].(map[string]any)
+	if first["name"] != "lookup" || second["name"] != "search" {
+		t.Errorf("function response names = %v, %v", first["name"], second["name"])
+	}
+	if r := second["response"].(map[string]any); r["result"] != "plain text" {
+		t.Errorf("non-JSON tool result not wrapped: %v", r)
+	}
+
+	decls := got.Tools[0].FunctionDeclarations
+	schema := decls[0]["parameters"].(map[string]any)
+	if _, ok := schema["additionalProperties"]; ok {
+		t.Errorf("unsupported schema keyword kept: %v", schema)
+	}
+	q := schema["properties"].(map[string]any)["q"].(map[string]any)
+	if q["type"] != "string" || q["nullable"] != true {
+		t.Errorf("nullable type not converted: %v", q)
+	}
+	if _, ok := decls[1]["parameters"]; ok {
+		t.Errorf("empty object schema should be omitted: %v", decls[1])
+	}
+	if got.GenerationConfig["maxOutputTokens"] != float64(100) {
+		t.Errorf("generationConfig = %v", got.GenerationConfig)
+	}
+}
+
+func TestGeminiAPIError(t *testing.T) {
+	f := &fakeGemini{status: http.StatusBadRequest}
+	params := newFakeGemini(t, f)
+	params.Messages = []Message{UserMessage("hi")}
+
+	_, err := NewGeminiClient().Chat(context.Background(), params)
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Body, "INVALID_ARGUMENT") {
+		t.Errorf("APIError = %+v", apiErr)
+	}
+}
+
+func TestGeminiMaxTokensStopReason(t *testing.T) {
+	f := &fakeGemini{chunks: []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"partial"}]},"finishReason":"MAX_TOKENS"}]}`,
+	}}
+	params := newFakeGemini(t, f)
+	params.Messages = []Message{UserMessage("long essay")}
+
+	stream, err := NewGeminiClient().Chat(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	result, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("ConsumeStream: %v", err)
+	}
+	if result.StopReason != "max_tokens" {
+		t.Errorf("stop reason = %q, want max_tokens", result.StopReason)
+	}
+}
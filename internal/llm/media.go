@@ -0,0 +1,309 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	MaxMediaBytes        = 32 << 20  // largest image or document fetched from a URL (Anthropic's PDF request limit)
+	MaxDocumentTextBytes = 256 << 10 // text documents are truncated beyond this when inlined
+	maxMediaCacheBytes   = 64 << 20  // fetched media kept in memory so history images are not re-downloaded every turn
+)
+
+// mediaHTTPClient fetches media URLs that come from users and channels. It only connects to public
+// addresses: the check runs on the resolved IP of every connection, so hostnames resolving to internal
+// hosts and redirects to them are refused too. Proxy settings are ignored, as a proxy would hide the target.
+var mediaHTTPClient = newMediaHTTPClient(false)
+
+func newMediaHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = publicAddressOnly
+	}
+	return &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ForceAttemptHTTP2:   true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// sharedAddressSpace is 100.64.0.0/10 (carrier-grade NAT), where some clouds serve instance metadata.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddressOnly is a net.Dialer Control func refusing loopback, private, link-local (cloud metadata),
+// shared, unspecified and multicast addresses.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("refusing to fetch media from non-public address %s", ip)
+	}
+	return nil
+}
+
+// FetchMedia returns the bytes and MIME type behind an http(s) or base64 data: URL, failing if the
+// content exceeds limit bytes. The MIME type comes from the data URL or the Content-Type header.
+func FetchMedia(ctx context.Context, rawURL string, limit int64) ([]byte, string, error) {
+	if strings.HasPrefix(rawURL, "data:") {
+		meta, data, ok := strings.Cut(strings.TrimPrefix(rawURL, "data:"), ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil, "", fmt.Errorf("unsupported data URL (base64 required)")
+		}
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode data URL: %w", err)
+		}
+		if int64(len(b)) > limit {
+			return nil, "", fmt.Errorf("data URL too large (%d bytes, max %d)", len(b), limit)
+		}
+		return b, strings.TrimSuffix(meta, ";base64"), nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, "", fmt.Errorf("cannot fetch %q: only http(s) and data URLs are supported", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := mediaHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetch %s: status %d", u.Redacted(), resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return nil, "", fmt.Errorf("fetch %s: too large (%d bytes, max %d)", u.Redacted(), resp.ContentLength, limit)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch %s: %w", u.Redacted(), err)
+	}
+	if int64(len(b)) > limit {
+		return nil, "", fmt.Errorf("fetch %s: too large (max %d bytes)", u.Redacted(), limit)
+	}
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(b))
+	}
+	return b, mimeType, nil
+}
+
+type cachedMedia struct {
+	base64 string
+	mime   string
+}
+
+var mediaCache = struct {
+	sync.Mutex
+	entries map[string]cachedMedia
+	size    int
+}{entries: make(map[string]cachedMedia)}
+
+// fetchMediaBase64 fetches a URL as base64, from the in-memory cache when it was fetched before.
+func fetchMediaBase64(ctx context.Context, rawURL string) (string, string, error) {
+	if strings.HasPrefix(rawURL, "data:") {
+		b, mimeType, err := FetchMedia(ctx, rawURL, MaxMediaBytes)
+		return base64.StdEncoding.EncodeToString(b), mimeType, err
+	}
+	mediaCache.Lock()
+	m, ok := mediaCache.entries[rawURL]
+	mediaCache.Unlock()
+	if ok {
+		return m.base64, m.mime, nil
+	}
+	b, mimeType, err := FetchMedia(ctx, rawURL, MaxMediaBytes)
+	if err != nil {
+		return "", "", err
+	}
+	m = cachedMedia{base64: base64.StdEncoding.EncodeToString(b), mime: mimeType}
+	mediaCache.Lock()
+	if mediaCache.size+len(m.base64) > maxMediaCacheBytes {
+		mediaCache.entries = make(map[string]cachedMedia)
+		mediaCache.size = 0
+	}
+	mediaCache.entries[rawURL] = m
+	mediaCache.size += len(m.base64)
+	mediaCache.Unlock()
+	return m.base64, m.mime, nil
+}
+
+// inlineMedia returns messages with URL images (images) and URL PDFs (documents) replaced by inline
+// base64 data, for providers that cannot fetch URLs themselves. Media that cannot be fetched keep
+// their URL; clients then render them as a note (see userText). The input slice is not modified.
+func inlineMedia(ctx context.Context, messages []Message, images, documents bool) []Message {
+	out := messages
+	copied := false
+	for i, msg := range messages {
+		if msg.Role != RoleUser {
+			continue
+		}
+		var newImages []ImageData
+		var newDocs []DocumentData
+		if images {
+			for j, img := range msg.Images {
+				if img.Base64 != "" || img.URL == "" {
+					continue
+				}
+				data, mimeType, err := fetchMediaBase64(ctx, img.URL)
+				if err != nil {
+					slog.Warn("image could not be fetched", "error", err)
+					continue
+				}
+				if newImages == nil {
+					newImages = append([]ImageData(nil), msg.Images...)
+				}
+				if !strings.HasPrefix(mimeType, "image/") {
+					mimeType = img.MIME
+				}
+				newImages[j] = ImageData{Base64: data, MIME: mimeType}
+			}
+		}
+		if documents {
+			for j, doc := range msg.Documents {
+				if !doc.IsPDF() || doc.Base64 != "" || doc.URL == "" {
+					continue
+				}
+				data, _, err := fetchMediaBase64(ctx, doc.URL)
+				if err != nil {
+					slog.Warn("document could not be fetched", "name", doc.Name, "error", err)
+					continue
+				}
+				if newDocs == nil {
+					newDocs = append([]DocumentData(nil), msg.Documents...)
+				}
+				newDocs[j].Base64 = data
+				newDocs[j].URL = ""
+			}
+		}
+		if newImages == nil && newDocs == nil {
+			continue
+		}
+		if !copied {
+			out = append([]Message(nil), messages...)
+			copied = true
+		}
+		if newImages != nil {
+			out[i].Images = newImages
+		}
+		if newDocs != nil {
+			out[i].Documents = newDocs
+		}
+	}
+	return out
+}
+
+// mediaSupport describes which attachments a provider takes natively.
+type mediaSupport struct {
+	pdf       bool // inline PDF documents (base64)
+	imageURLs bool // images by URL, not only inline base64
+}
+
+// userText renders the text of a user message: its content followed by its text documents, and a note
+// for each attachment the provider cannot take natively, so the model knows it was sent.
+func userText(msg Message, support mediaSupport) string {
+	var sb strings.Builder
+	sb.WriteString(msg.Content)
+	add := func(s string) {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(s)
+	}
+	for _, doc := range msg.Documents {
+		name := doc.Name
+		if name == "" {
+			name = "attachment"
+		}
+		switch {
+		case doc.Text != "":
+			text := doc.Text
+			if doc.Truncated {
+				text += fmt.Sprintf("\n[... truncated at %d KB]", MaxDocumentTextBytes>>10)
+			}
+			add(fmt.Sprintf("<document name=%q>\n%s\n</document>", name, text))
+		case doc.IsPDF() && support.pdf && doc.Base64 != "":
+			// sent as a document block
+		case doc.IsPDF() && support.pdf:
+			add(fmt.Sprintf("[Attached PDF %s could not be loaded]", name))
+		case doc.IsPDF():
+			add(fmt.Sprintf("[Attached PDF %s: this model cannot read PDF files]", name))
+		default:
+			add(fmt.Sprintf("[Attached file %s (%s): content not readable]", name, doc.MIME))
+		}
+	}
+	if !support.imageURLs {
+		for _, img := range msg.Images {
+			if img.Base64 == "" && img.URL != "" {
+				add("[Attached image could not be loaded: " + redactURL(img.URL) + "]")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// pdfDocuments returns the message's PDFs that carry inline data, in order.
+func pdfDocuments(msg Message) []DocumentData {
+	var docs []DocumentData
+	for _, doc := range msg.Documents {
+		if doc.IsPDF() && doc.Base64 != "" {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// pdfFilename returns the document's name for providers that require one.
+func pdfFilename(doc DocumentData) string {
+	if doc.Name != "" {
+		return doc.Name
+	}
+	return "document.pdf"
+}
+
+func redactURL(raw string) string {
+	if strings.HasPrefix(raw, "data:") {
+		return "data URL"
+	}
+	if u, err := url.Parse(raw); err == nil {
+		u.RawQuery = "" // signed URLs carry credentials in the query
+		return u.Redacted()
+	}
+	return raw
+}
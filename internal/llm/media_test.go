@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestInlineMedia(t *testing.T) {
+	pdf := []byte("%PDF-1.4 test")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cat.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png bytes"))
+		case "/report.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(pdf)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	saved := mediaHTTPClient
+	mediaHTTPClient = newMediaHTTPClient(true) // the test server listens on loopback
+	defer func() { mediaHTTPClient = saved }()
+
+	msg := UserMessageWithImages("look", []ImageData{{URL: srv.URL + "/cat.png"}, {URL: srv.URL + "/missing.png"}})
+	msg.Documents = []DocumentData{{Name: "report.pdf", MIME: "application/pdf", URL: srv.URL + "/report.pdf"}}
+	in := []Message{msg}
+
+	out := inlineMedia(context.Background(), in, true, true)
+	if in[0].Images[0].Base64 != "" || in[0].Documents[0].Base64 != "" {
+		t.Fatal("input messages were modified")
+	}
+	got := out[0]
+	if got.Images[0].Base64 != base64.StdEncoding.EncodeToString([]byte("png bytes")) || got.Images[0].MIME != "image/png" {
+		t.Errorf("image = %+v", got.Images[0])
+	}
+	if got.Images[1].URL == "" {
+		t.Errorf("unfetchable image should keep its URL: %+v", got.Images[1])
+	}
+	if got.Documents[0].Base64 != base64.StdEncoding.EncodeToString(pdf) || got.Documents[0].URL != "" {
+		t.Errorf("document = %+v", got.Documents[0])
+	}
+
+	text := userText(got, anthropicMedia)
+	if !strings.HasPrefix(text, "look") || !strings.Contains(text, "could not be loaded: "+srv.URL+"/missing.png") || strings.Contains(text, "report.pdf") {
+		t.Errorf("userText = %q", text)
+	}
+	if text := userText(got, mediaSupport{}); !strings.Contains(text, "cannot read PDF files") {
+		t.Errorf("userText without PDF support = %q", text)
+	}
+}
+
+func TestFetchMediaRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	for _, u := range []string{
+		srv.URL + "/cat.png",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/a.png",
+		"http://100.100.100.200/latest/meta-data/",
+		"http://[::1]:1/a.png",
+		"http://[::ffff:127.0.0.1]:1/a.png",
+	} {
+		if _, _, err := FetchMedia(context.Background(), u, MaxMediaBytes); err == nil || !strings.Contains(err.Error(), "non-public address") {
+			t.Errorf("%s: err = %v", u, err)
+		}
+	}
+
+	// Redirects are checked the same way, even when the first hop is allowed.
+	client := newMediaHTTPClient(true)
+	client.Transport.(*http.Transport).DialContext = (&net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		if strings.HasPrefix(address, "127.0.0.1:") {
+			return nil
+		}
+		return publicAddressOnly(network, address, c)
+	}}).DialContext
+	saved := mediaHTTPClient
+	mediaHTTPClient = client
+	defer func() { mediaHTTPClient = saved }()
+	if _, _, err := FetchMedia(context.Background(), srv.URL+"/redirect", MaxMediaBytes); err == nil || !strings.Contains(err.Error(), "non-public address 169.254.169.254") {
+		t.Errorf("redirect: err = %v", err)
+	}
+}
+
+func TestDocumentRequests(t *testing.T) {
+	msg := UserMessage("summarize")
+	msg.Documents = []DocumentData{
+		{Name: "report.pdf", MIME: "application/pdf", Base64: "JVBERi0="},
+		{Name: "notes.txt", MIME: "text/plain", Text: "hello", Truncated: true},
+	}
+	params := ChatParams{Model: "m", Messages: []Message{msg}}
+
+	body := (&AnthropicClient{}).buildRequest(params)
+	content := body["messages"].([]map[string]any)[0]["content"].([]map[string]any)
+	if len(content) != 2 || content[0]["type"] != "document" || content[0]["title"] != "report.pdf" {
+		t.Fatalf("anthropic content = %+v", content)
+	}
+	if text := content[1]["text"].(string); !strings.Contains(text, "<document name=\"notes.txt\">\nhello\n[... truncated") {
+		t.Errorf("anthropic text = %q", text)
+	}
+
+	params.BaseURL = "https://api.openai.com/v1"
+	body = (&OpenAIClient{}).buildRequest(params)
+	parts := body["messages"].([]map[string]any)[0]["content"].([]map[string]any)
+	var file map[string]any
+	for _, p := range parts {
+		if p["type"] == "file" {
+			file = p["file"].(map[string]any)
+		}
+	}
+	if file == nil || file["filename"] != "report.pdf" || file["file_data"] != "data:application/pdf;base64,JVBERi0=" {
+		t.Errorf("openai content = %+v", parts)
+	}
+}
@@ -0,0 +1,282 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MockClient implements Client by replaying scripted assistant turns instead of calling a provider,
+// so the agent loop, gateway and bridges can run offline and in tests.
+// Scripts are registered per provider name (providers with type: mock).
+type MockClient struct {
+	mu      sync.Mutex
+	scripts map[string]*MockScript
+	calls   []ChatParams
+}
+
+func NewMockClient() *MockClient {
+	return &MockClient{scripts: make(map[string]*MockScript)}
+}
+
+// MockScript is a list of assistant turns, consumed in order (one per Chat call).
+// YAML or JSON, e.g.:
+//
+//	loop: true
+//	turns:
+//	  - match: "(?i)weather"
+//	    toolCalls:
+//	      - name: web_search
+//	        arguments: {query: "weather today"}
+//	  - text: "Sunny, 25°C."
+//	    usage: {input: 1200, output: 12}
+//	  - error: {status: 400, type: context_length_exceeded, message: "prompt is too long"}
+type MockScript struct {
+	Loop  bool       `yaml:"loop" json:"loop"` // start over after the last turn instead of failing
+	Turns []MockTurn `yaml:"turns" json:"turns"`
+
+	mu   sync.Mutex
+	next int
+}
+
+// MockTurn is one scripted model response.
+type MockTurn struct {
+	Match      string         `yaml:"match" json:"match"`           // regexp on the latest user message; the turn is skipped if it does not match
+	Text       string         `yaml:"text" json:"text"`             // reply text, sent as one delta unless Deltas is set
+	Deltas     []string       `yaml:"deltas" json:"deltas"`         // reply text as explicit stream fragments
+	Thinking   string         `yaml:"thinking" json:"thinking"`     // reasoning text (thinking_delta)
+	Reasoning  string         `yaml:"reasoning" json:"reasoning"`   // reasoning_content of OpenAI-compatible reasoners (reasoning_delta)
+	ToolCalls  []MockToolCall `yaml:"toolCalls" json:"toolCalls"`   // tool calls requested by the model
+	Usage      *MockUsage     `yaml:"usage" json:"usage"`           // reported token usage; none if omitted
+	StopReason string         `yaml:"stopReason" json:"stopReason"` // default "tool_use" with tool calls, else "end_turn"
+	Error      *MockError     `yaml:"error" json:"error"`           // fail this call instead of (or, inStream, after) replying
+	DelayMs    int            `yaml:"delayMs" json:"delayMs"`       // pause before each stream event
+}
+
+// MockToolCall is a scripted tool call; Arguments may be a JSON string or any YAML/JSON value.
+type MockToolCall struct {
+	ID        string `yaml:"id" json:"id"`
+	Name      string `yaml:"name" json:"name"`
+	Arguments any    `yaml:"arguments" json:"arguments"`
+}
+
+type MockUsage struct {
+	Input      int `yaml:"input" json:"input"`
+	Output     int `yaml:"output" json:"output"`
+	CacheRead  int `yaml:"cacheRead" json:"cacheRead"`
+	CacheWrite int `yaml:"cacheWrite" json:"cacheWrite"`
+}
+
+// MockError is an injected provider error, classified like a real one (see NewAPIError).
+// Body is sent verbatim; otherwise an OpenAI-style body is built from Type and Message.
+type MockError struct {
+	Status   int    `yaml:"status" json:"status"`
+	Type     string `yaml:"type" json:"type"`
+	Message  string `yaml:"message" json:"message"`
+	Body     string `yaml:"body" json:"body"`
+	InStream bool   `yaml:"inStream" json:"inStream"` // stream the turn's text first, then fail with an error event
+}
+
+// LoadMockScript reads a mock script from a YAML or JSON file.
+func LoadMockScript(path string) (*MockScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseMockScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ParseMockScript parses a mock script (YAML, or JSON as a subset of YAML) and validates its match patterns.
+func ParseMockScript(data []byte) (*MockScript, error) {
+	var s MockScript
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse mock script: %w", err)
+	}
+	for i, t := range s.Turns {
+		if t.Match == "" {
+			continue
+		}
+		if _, err := regexp.Compile(t.Match); err != nil {
+			return nil, fmt.Errorf("turn %d: invalid match: %w", i+1, err)
+		}
+	}
+	return &s, nil
+}
+
+// SetScript registers the script replayed for a provider; nil removes it.
+func (c *MockClient) SetScript(provider string, s *MockScript) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s == nil {
+		delete(c.scripts, provider)
+		return
+	}
+	c.scripts[provider] = s
+}
+
+// Calls returns the parameters of every Chat call so far, in order.
+func (c *MockClient) Calls() []ChatParams {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ChatParams(nil), c.calls...)
+}
+
+func (c *MockClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	c.mu.Lock()
+	c.calls = append(c.calls, params)
+	script := c.scripts[params.Provider]
+	c.mu.Unlock()
+	if script == nil {
+		return nil, fmt.Errorf("mock provider %q has no script", params.Provider)
+	}
+
+	turn, err := script.nextTurn(lastUserText(params.Messages))
+	if err != nil {
+		return nil, err
+	}
+	if turn.Error != nil && !turn.Error.InStream {
+		return nil, turn.Error.apiError()
+	}
+
+	ch := make(chan StreamEvent, 32)
+	go turn.stream(ctx, ch)
+	return ch, nil
+}
+
+// nextTurn returns the next turn whose match fits userText, consuming it and any turns skipped before it.
+func (s *MockScript) nextTurn(userText string) (*MockTurn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pass := 0; pass < 2; pass++ {
+		for ; s.next < len(s.Turns); s.next++ {
+			t := &s.Turns[s.next]
+			if t.Match != "" && !regexp.MustCompile(t.Match).MatchString(userText) {
+				continue
+			}
+			s.next++
+			return t, nil
+		}
+		if !s.Loop {
+			break
+		}
+		s.next = 0
+	}
+	return nil, fmt.Errorf("mock script exhausted: no turn left for %q", truncateText(userText, 80))
+}
+
+func (t *MockTurn) stream(ctx context.Context, out chan<- StreamEvent) {
+	defer close(out)
+	send := func(ev StreamEvent) bool {
+		if t.DelayMs > 0 {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(time.Duration(t.DelayMs) * time.Millisecond):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case out <- ev:
+			return true
+		}
+	}
+
+	if t.Thinking != "" && !send(StreamEvent{Type: "thinking_delta", ThinkingType: "thinking", Text: t.Thinking}) {
+		return
+	}
+	if t.Reasoning != "" && !send(StreamEvent{Type: "reasoning_delta", Text: t.Reasoning}) {
+		return
+	}
+	deltas := t.Deltas
+	if len(deltas) == 0 && t.Text != "" {
+		deltas = []string{t.Text}
+	}
+	for _, d := range deltas {
+		if !send(StreamEvent{Type: "text_delta", Text: d}) {
+			return
+		}
+	}
+	if t.Error != nil {
+		send(StreamEvent{Type: "error", Error: t.Error.apiError()})
+		return
+	}
+	for i, tc := range t.ToolCalls {
+		id := tc.ID
+		if id == "" {
+			id = fmt.Sprintf("call_mock_%d", i+1)
+		}
+		if !send(StreamEvent{Type: "tool_call_delta", ToolCallIndex: i, ToolCallID: id, ToolCallName: tc.Name, ToolCallArgs: tc.argumentsJSON()}) {
+			return
+		}
+	}
+	if u := t.Usage; u != nil {
+		usage := &Usage{InputTokens: u.Input, OutputTokens: u.Output, CacheReadTokens: u.CacheRead, CacheCreationTokens: u.CacheWrite}
+		if !send(StreamEvent{Type: "usage", Usage: usage}) {
+			return
+		}
+	}
+	stop := t.StopReason
+	if stop == "" {
+		stop = "end_turn"
+		if len(t.ToolCalls) > 0 {
+			stop = "tool_use"
+		}
+	}
+	send(StreamEvent{Type: "done", Text: stop})
+}
+
+func (tc MockToolCall) argumentsJSON() string {
+	switch a := tc.Arguments.(type) {
+	case nil:
+		return "{}"
+	case string:
+		return a
+	}
+	b, err := json.Marshal(tc.Arguments)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (e *MockError) apiError() *APIError {
+	body := e.Body
+	if body == "" {
+		b, _ := json.Marshal(map[string]any{"error": map[string]string{"type": e.Type, "message": e.Message}})
+		body = string(b)
+	}
+	status := e.Status
+	if e.InStream {
+		status = 0
+	}
+	return NewAPIError(status, body)
+}
+
+// lastUserText returns the content of the latest user message.
+func lastUserText(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func truncateText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "..."
+}
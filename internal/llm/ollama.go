@@ -0,0 +1,335 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const ollamaAPIURL = "http://localhost:11434"
+
+// OllamaClient implements Client for Ollama's native /api/chat endpoint (streaming NDJSON).
+type OllamaClient struct {
+	HTTPClient *http.Client
+}
+
+func NewOllamaClient() *OllamaClient {
+	return &OllamaClient{HTTPClient: http.DefaultClient}
+}
+
+// OllamaOptions are Ollama-specific request settings, passed through ChatParams.Ollama.
+type OllamaOptions struct {
+	NumCtx    int            // options.num_ctx; 0 uses the model default
+	KeepAlive string         // keep_alive, e.g. "10m" or "-1" (keep loaded)
+	Options   map[string]any // extra options.* (num_gpu, repeat_penalty, ...); override everything else
+}
+
+// OllamaModel is one model reported by /api/tags (installed) or /api/ps (loaded).
+type OllamaModel struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Size      int64     `json:"size"`
+	SizeVRAM  int64     `json:"size_vram,omitempty"`  // /api/ps only
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // /api/ps only
+}
+
+// ollamaBaseURL returns the server root, tolerating base URLs copied from the OpenAI-compatible setup (".../v1").
+func ollamaBaseURL(baseURL string) string {
+	if baseURL == "" {
+		baseURL = ollamaAPIURL
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	return strings.TrimSuffix(baseURL, "/v1")
+}
+
+func (c *OllamaClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	endpoint := ollamaBaseURL(params.BaseURL) + "/api/chat"
+	params.Messages = inlineMedia(ctx, params.Messages, true, false)
+	body := c.buildRequest(params)
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	resp, err := doWithRetry(ctx, c.HTTPClient, params, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if params.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+params.APIKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 32)
+	go c.consumeNDJSON(resp.Body, ch)
+	return ch, nil
+}
+
+func (c *OllamaClient) buildRequest(params ChatParams) map[string]any {
+	callNames := make(map[string]string)
+	messages := make([]map[string]any, 0, len(params.Messages)+1)
+
+	if params.System != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": params.System})
+	}
+
+	for _, msg := range params.Messages {
+		m := map[string]any{"role": msg.Role, "content": msg.Content}
+		switch msg.Role {
+		case RoleUser:
+			// Ollama only accepts inline base64 images (URL images are fetched in Chat) and no PDFs.
+			m["content"] = userText(msg, mediaSupport{})
+			var images []string
+			for _, img := range msg.Images {
+				if img.Base64 != "" {
+					images = append(images, img.Base64)
+				}
+			}
+			if len(images) > 0 {
+				m["images"] = images
+			}
+		case RoleAssistant:
+			if len(msg.ToolCalls) > 0 {
+				tcs := make([]map[string]any, len(msg.ToolCalls))
+				for i, tc := range msg.ToolCalls {
+					callNames[tc.ID] = tc.Name
+					var args any
+					_ = json.Unmarshal([]byte(tc.Arguments), &args)
+					if args == nil {
+						args = map[string]any{}
+					}
+					tcs[i] = map[string]any{
+						"function": map[string]any{"name": tc.Name, "arguments": args},
+					}
+				}
+				m["tool_calls"] = tcs
+			}
+		case RoleTool:
+			if name := callNames[msg.ToolCallID]; name != "" {
+				m["tool_name"] = name
+			}
+		}
+		messages = append(messages, m)
+	}
+
+	req := map[string]any{
+		"model":    params.Model,
+		"messages": messages,
+		"stream":   true,
+	}
+
+	// Ollama has no tool_choice: "none" leaves the tools out, forced choices are up to the model.
+	if len(params.Tools) > 0 && params.ToolChoice != ToolChoiceNone {
+		tools := make([]map[string]any, len(params.Tools))
+		for i, t := range params.Tools {
+			tools[i] = map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name":        t.Name,
+					"description": t.Description,
+					"parameters":  json.RawMessage(t.Parameters),
+				},
+			}
+		}
+		req["tools"] = tools
+	}
+
+	if params.ThinkingBudget > 0 {
+		req["think"] = true
+	}
+	if rs := params.ResponseSchema; rs != nil {
+		req["format"] = rs.Schema
+	}
+
+	options := map[string]any{}
+	if params.MaxTokens > 0 {
+		options["num_predict"] = params.MaxTokens
+	}
+	if params.Temperature != nil {
+		options["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		options["top_p"] = *params.TopP
+	}
+	if len(params.Stop) > 0 {
+		options["stop"] = params.Stop
+	}
+	if params.Seed != nil {
+		options["seed"] = *params.Seed
+	}
+	if o := params.Ollama; o != nil {
+		if o.NumCtx > 0 {
+			options["num_ctx"] = o.NumCtx
+		}
+		for k, v := range o.Options {
+			options[k] = v
+		}
+		if o.KeepAlive != "" {
+			// A bare number is seconds (e.g. -1 keeps the model loaded); anything else is a duration string.
+			if n, err := strconv.Atoi(o.KeepAlive); err == nil {
+				req["keep_alive"] = n
+			} else {
+				req["keep_alive"] = o.KeepAlive
+			}
+		}
+	}
+	if len(options) > 0 {
+		req["options"] = options
+	}
+
+	return req
+}
+
+// ollamaCallSeq makes generated tool call IDs unique within the process.
+var ollamaCallSeq atomic.Uint64
+
+func (c *OllamaClient) consumeNDJSON(body io.ReadCloser, out chan<- StreamEvent) {
+	defer close(out)
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var toolIndex int
+	thinkingStarted := false
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("parse chunk: %w", err)}
+			return
+		}
+		if chunk.Error != "" {
+			out <- StreamEvent{Type: "error", Error: NewAPIError(0, string(line))}
+			return
+		}
+
+		if chunk.Message.Thinking != "" {
+			ev := StreamEvent{Type: "thinking_delta", Text: chunk.Message.Thinking}
+			if !thinkingStarted {
+				ev.ThinkingType = "thinking"
+				thinkingStarted = true
+			}
+			out <- ev
+		}
+		if chunk.Message.Content != "" {
+			out <- StreamEvent{Type: "text_delta", Text: chunk.Message.Content}
+		}
+		// Ollama sends each tool call complete, with arguments as a JSON object and usually no ID.
+		for _, tc := range chunk.Message.ToolCalls {
+			args := tc.Function.Arguments
+			if len(args) == 0 || string(args) == "null" {
+				args = json.RawMessage("{}")
+			}
+			out <- StreamEvent{
+				Type:          "tool_call_delta",
+				ToolCallIndex: toolIndex,
+				ToolCallID:    fmt.Sprintf("call_%d_%d", time.Now().UnixMilli(), ollamaCallSeq.Add(1)),
+				ToolCallName:  tc.Function.Name,
+				ToolCallArgs:  string(args),
+			}
+			toolIndex++
+		}
+
+		if chunk.Done {
+			if chunk.PromptEvalCount > 0 || chunk.EvalCount > 0 {
+				out <- StreamEvent{
+					Type:  "usage",
+					Usage: &Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount},
+				}
+			}
+			reason := chunk.DoneReason
+			if reason == "" {
+				reason = "stop"
+			}
+			out <- StreamEvent{Type: "done", Text: reason}
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		out <- StreamEvent{Type: "error", Error: fmt.Errorf("read stream: %w", err)}
+	}
+}
+
+// ListModels returns the models installed on the Ollama server (/api/tags).
+func (c *OllamaClient) ListModels(ctx context.Context, baseURL string) ([]OllamaModel, error) {
+	return c.getModels(ctx, ollamaBaseURL(baseURL)+"/api/tags")
+}
+
+// RunningModels returns the models currently loaded in memory (/api/ps).
+func (c *OllamaClient) RunningModels(ctx context.Context, baseURL string) ([]OllamaModel, error) {
+	return c.getModels(ctx, ollamaBaseURL(baseURL)+"/api/ps")
+}
+
+func (c *OllamaClient) getModels(ctx context.Context, endpoint string) ([]OllamaModel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, &TransportError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(resp.Body)
+		return nil, NewAPIError(resp.StatusCode, string(errBody))
+	}
+	var out struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode models: %w", err)
+	}
+	return out.Models, nil
+}
+
+// HasOllamaModel reports whether name is among models. A name without a tag matches ":latest".
+func HasOllamaModel(models []OllamaModel, name string) bool {
+	for _, m := range models {
+		if m.Name == name || m.Model == name {
+			return true
+		}
+		if !strings.Contains(name, ":") && (m.Name == name+":latest" || m.Model == name+":latest") {
+			return true
+		}
+	}
+	return false
+}
+
+// Ollama streaming response types
+
+type ollamaChunk struct {
+	Message struct {
+		Content   string `json:"content"`
+		Thinking  string `json:"thinking"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string          `json:"name"`
+				Arguments json.RawMessage `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
@@ -0,0 +1,176 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeOllama serves the given NDJSON lines on /api/chat and records the last request.
+type fakeOllama struct {
+	lines []string
+
+	path string
+	auth string
+	body map[string]any
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.path = r.URL.Path
+	f.auth = r.Header.Get("Authorization")
+	raw, _ := io.ReadAll(r.Body)
+	f.body = nil
+	_ = json.Unmarshal(raw, &f.body)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, l := range f.lines {
+		fmt.Fprintln(w, l)
+	}
+}
+
+func newFakeOllama(t *testing.T, f *fakeOllama) ChatParams {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return ChatParams{
+		Model:   "qwen3",
+		BaseURL: srv.URL + "/v1", // copied from the OpenAI-compatible setup
+		Retry:   RetryPolicy{MaxRetries: -1},
+	}
+}
+
+func chatOllama(t *testing.T, params ChatParams) (*StreamResult, error) {
+	t.Helper()
+	stream, err := NewClient("ollama", http.DefaultClient).Chat(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ConsumeStream(context.Background(), stream)
+}
+
+func TestOllamaStream(t *testing.T) {
+	f := &fakeOllama{lines: []string{
+		`{"message":{"role":"assistant","content":"","thinking":"Hmm, "}}`,
+		`{"message":{"role":"assistant","content":"","thinking":"weather."}}`,
+		`{"message":{"role":"assistant","content":"Checking."}}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"weather","arguments":{"city":"Paris"}}},{"function":{"name":"time"}}]}}`,
+		`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":42,"eval_count":9}`,
+	}}
+	params := newFakeOllama(t, f)
+	params.Messages = []Message{UserMessage("weather in Paris?")}
+	result, err := chatOllama(t, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.path != "/api/chat" {
+		t.Errorf("path = %q", f.path)
+	}
+	if result.Text != "Checking." || result.StopReason != "stop" {
+		t.Errorf("result = %+v", result)
+	}
+	if len(result.Thinking) != 1 || result.Thinking[0].Thinking != "Hmm, weather." {
+		t.Errorf("thinking = %+v", result.Thinking)
+	}
+	if len(result.ToolCalls) != 2 {
+		t.Fatalf("tool calls = %+v", result.ToolCalls)
+	}
+	tc0, tc1 := result.ToolCalls[0], result.ToolCalls[1]
+	if tc0.Name != "weather" || tc0.Arguments != `{"city":"Paris"}` || tc1.Name != "time" || tc1.Arguments != "{}" {
+		t.Errorf("tool calls = %+v", result.ToolCalls)
+	}
+	// Ollama sends no IDs: they are generated, unique
+	if !strings.HasPrefix(tc0.ID, "call_") || tc0.ID == tc1.ID {
+		t.Errorf("tool call IDs = %q, %q", tc0.ID, tc1.ID)
+	}
+	if u := result.Usage; u == nil || u.InputTokens != 42 || u.OutputTokens != 9 {
+		t.Errorf("usage = %+v", result.Usage)
+	}
+}
+
+func TestOllamaDoneReason(t *testing.T) {
+	f := &fakeOllama{lines: []string{
+		`{"message":{"content":"cut"}}`,
+		`{"message":{"content":""},"done":true,"done_reason":"length","eval_count":3}`,
+	}}
+	params := newFakeOllama(t, f)
+	params.Messages = []Message{UserMessage("long story")}
+	result, err := chatOllama(t, params)
+	if err != nil || result.StopReason != "length" || result.Text != "cut" {
+		t.Fatalf("result = %+v, err = %v", result, err)
+	}
+
+	f.lines = []string{`{"message":{"content":"hi"}}`, `{"done":true}`}
+	if result, err = chatOllama(t, params); err != nil || result.StopReason != "stop" || result.Usage != nil {
+		t.Errorf("without done_reason: result = %+v, err = %v", result, err)
+	}
+
+	f.lines = []string{`{"error":"model \"qwen3\" not found, try pulling it first"}`}
+	var apiErr *APIError
+	if _, err = chatOllama(t, params); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("error chunk: err = %v", err)
+	} else if !errors.As(err, &apiErr) {
+		t.Errorf("error chunk: err = %T, want *APIError", err)
+	}
+}
+
+func TestOllamaBuildRequest(t *testing.T) {
+	temperature := 0.2
+	params := ChatParams{
+		Model:       "qwen3",
+		System:      "be brief",
+		MaxTokens:   256,
+		Temperature: &temperature,
+		Tools:       []ToolDef{{Name: "weather", Description: "Weather.", Parameters: json.RawMessage(`{"type":"object"}`)}},
+		Messages: []Message{
+			UserMessageWithImages("what is this?", []ImageData{{Base64: "aW1n", MIME: "image/png"}}),
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`}}},
+			{Role: RoleTool, ToolCallID: "call_1", Content: "sunny"},
+		},
+		Ollama: &OllamaOptions{NumCtx: 8192, KeepAlive: "-1", Options: map[string]any{"num_gpu": 1, "temperature": 0.7}},
+	}
+	req := (&OllamaClient{}).buildRequest(params)
+	b, _ := json.Marshal(req)
+	var got map[string]any
+	_ = json.Unmarshal(b, &got)
+
+	messages := got["messages"].([]any)
+	if len(messages) != 4 || messages[0].(map[string]any)["role"] != "system" {
+		t.Fatalf("messages = %v", messages)
+	}
+	if user := messages[1].(map[string]any); fmt.Sprint(user["images"]) != "[aW1n]" {
+		t.Errorf("user = %v", user)
+	}
+	call := messages[2].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)["function"].(map[string]any)
+	if call["name"] != "weather" || call["arguments"].(map[string]any)["city"] != "Paris" {
+		t.Errorf("assistant tool call = %v", call)
+	}
+	// Tool results carry the name of the tool they answer
+	if tool := messages[3].(map[string]any); tool["role"] != "tool" || tool["tool_name"] != "weather" || tool["content"] != "sunny" {
+		t.Errorf("tool result = %v", tool)
+	}
+	if tools := got["tools"].([]any); len(tools) != 1 {
+		t.Errorf("tools = %v", tools)
+	}
+	// Extra options override the generic settings
+	opts := got["options"].(map[string]any)
+	if opts["num_ctx"] != float64(8192) || opts["num_predict"] != float64(256) || opts["temperature"] != 0.7 || opts["num_gpu"] != float64(1) {
+		t.Errorf("options = %v", opts)
+	}
+
+	// keep_alive: a bare number is sent as a number, anything else as a duration string
+	for keepAlive, want := range map[string]any{"-1": -1, "300": 300, "10m": "10m"} {
+		params.Ollama = &OllamaOptions{KeepAlive: keepAlive}
+		if got := (&OllamaClient{}).buildRequest(params)["keep_alive"]; got != want {
+			t.Errorf("keep_alive %q = %#v, want %#v", keepAlive, got, want)
+		}
+	}
+	params.ToolChoice = ToolChoiceNone
+	if req := (&OllamaClient{}).buildRequest(params); req["tools"] != nil {
+		t.Errorf("tool choice none: tools = %v", req["tools"])
+	}
+}
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -27,27 +28,26 @@ func (c *OpenAIClient) Chat(ctx context.Context, params ChatParams) (<-chan Stre
 	}
 	baseURL = strings.TrimRight(baseURL, "/") + "/v1"
 
+	if openAIMedia(params.BaseURL).pdf {
+		params.Messages = inlineMedia(ctx, params.Messages, false, true)
+	}
 	body := c.buildRequest(params)
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/chat/completions", bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+params.APIKey)
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := doWithRetry(ctx, c.HTTPClient, params, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/chat/completions", bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+params.APIKey)
+		return req, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("http request: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		errBody, _ := io.ReadAll(resp.Body)
-		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(errBody)}
+		return nil, err
 	}
 
 	ch := make(chan StreamEvent, 32)
@@ -55,7 +55,23 @@ func (c *OpenAIClient) Chat(ctx context.Context, params ChatParams) (<-chan Stre
 	return ch, nil
 }
 
+// isOpenAIEndpoint reports whether baseURL points at OpenAI's own API (empty means the default).
+func isOpenAIEndpoint(baseURL string) bool {
+	return baseURL == "" || strings.Contains(baseURL, "api.openai.com")
+}
+
+// openAIMedia returns what an OpenAI-compatible endpoint accepts: image URLs everywhere, PDF file
+// parts only on OpenAI itself and OpenRouter; other backends get a note instead.
+func openAIMedia(baseURL string) mediaSupport {
+	return mediaSupport{
+		pdf:       isOpenAIEndpoint(baseURL) || strings.Contains(baseURL, "openrouter.ai"),
+		imageURLs: true,
+	}
+}
+
 func (c *OpenAIClient) buildRequest(params ChatParams) map[string]any {
+	media := openAIMedia(params.BaseURL)
+
 	messages := make([]map[string]any, 0, len(params.Messages))
 
 	// System prompt as first message
@@ -88,9 +104,20 @@ func (c *OpenAIClient) buildRequest(params ChatParams) map[string]any {
 			if msg.Content != "" {
 				m["content"] = msg.Content
 			}
-		} else if len(msg.Images) > 0 {
+		} else if len(msg.Images) > 0 || len(msg.Documents) > 0 {
 			content := []map[string]any{
-				{"type": "text", "text": msg.Content},
+				{"type": "text", "text": userText(msg, media)},
+			}
+			if media.pdf {
+				for _, doc := range pdfDocuments(msg) {
+					content = append(content, map[string]any{
+						"type": "file",
+						"file": map[string]any{
+							"filename":  pdfFilename(doc),
+							"file_data": "data:" + doc.MIME + ";base64," + doc.Base64,
+						},
+					})
+				}
 			}
 			for _, img := range msg.Images {
 				if img.Base64 != "" {
@@ -120,9 +147,40 @@ func (c *OpenAIClient) buildRequest(params ChatParams) map[string]any {
 	}
 
 	req := map[string]any{
-		"model":    params.Model,
-		"messages": messages,
-		"stream":   true,
+		"model":          params.Model,
+		"messages":       messages,
+		"stream":         true,
+		"stream_options": map[string]any{"include_usage": true},
+	}
+	if params.MaxTokens > 0 {
+		// OpenAI itself only accepts max_completion_tokens for reasoning models; compatible servers expect max_tokens.
+		if isOpenAIEndpoint(params.BaseURL) {
+			req["max_completion_tokens"] = params.MaxTokens
+		} else {
+			req["max_tokens"] = params.MaxTokens
+		}
+	}
+	if params.Temperature != nil {
+		req["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		req["top_p"] = *params.TopP
+	}
+	if len(params.Stop) > 0 {
+		req["stop"] = params.Stop
+	}
+	if params.Seed != nil {
+		req["seed"] = *params.Seed
+	}
+	if rs := params.ResponseSchema; rs != nil {
+		req["response_format"] = map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   rs.SchemaName(),
+				"schema": rs.Schema,
+				"strict": rs.Strict,
+			},
+		}
 	}
 
 	if len(params.Tools) > 0 {
@@ -138,6 +196,14 @@ func (c *OpenAIClient) buildRequest(params ChatParams) map[string]any {
 			}
 		}
 		req["tools"] = tools
+		if name := params.ToolChoice.Tool(); name != "" {
+			req["tool_choice"] = map[string]any{"type": "function", "function": map[string]any{"name": name}}
+		} else if params.ToolChoice != "" {
+			req["tool_choice"] = string(params.ToolChoice)
+		}
+		if params.ParallelToolCalls != nil {
+			req["parallel_tool_calls"] = *params.ParallelToolCalls
+		}
 	}
 
 	return req
@@ -147,27 +213,37 @@ func (c *OpenAIClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent) {
 	defer close(out)
 	defer body.Close()
 
+	// Usage may arrive on the finish chunk, on a trailing usage-only chunk (stream_options.include_usage),
+	// or cumulatively on every chunk; keep the latest and report it once when the stream ends.
+	var usage *Usage
+	var finishReason string
 	for event := range ParseSSE(body) {
+		if event.Err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("read stream: %w", event.Err)}
+			return
+		}
 		var chunk openAIChunk
 		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
 			out <- StreamEvent{Type: "error", Error: fmt.Errorf("parse chunk: %w", err)}
 			return
 		}
+		if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+			// Mid-stream failures (e.g. DeepSeek overload, proxy-side context limits) arrive as {"error":{...}}.
+			out <- StreamEvent{Type: "error", Error: NewAPIError(0, event.Data)}
+			return
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage.toUsage()
+		}
 
 		if len(chunk.Choices) == 0 {
-			// Usage-only chunk (some providers send this at the end)
-			if chunk.Usage != nil {
-				out <- StreamEvent{
-					Type:  "usage",
-					Usage: &Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens},
-				}
-			}
 			continue
 		}
 
 		delta := chunk.Choices[0].Delta
-		finishReason := chunk.Choices[0].FinishReason
-
+		if r := cmp.Or(delta.ReasoningContent, delta.Reasoning); r != "" {
+			out <- StreamEvent{Type: "reasoning_delta", Text: r}
+		}
 		if delta.Content != "" {
 			out <- StreamEvent{Type: "text_delta", Text: delta.Content}
 		}
@@ -182,24 +258,25 @@ func (c *OpenAIClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent) {
 			}
 		}
 
-		if finishReason != "" {
-			if chunk.Usage != nil {
-				out <- StreamEvent{
-					Type:  "usage",
-					Usage: &Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens},
-				}
-			}
-			out <- StreamEvent{Type: "done", Text: finishReason}
-			return
+		if fr := chunk.Choices[0].FinishReason; fr != "" {
+			finishReason = fr
 		}
 	}
+
+	if usage != nil {
+		out <- StreamEvent{Type: "usage", Usage: usage}
+	}
+	if finishReason != "" {
+		out <- StreamEvent{Type: "done", Text: finishReason}
+	}
 }
 
 // OpenAI streaming response types
 
 type openAIChunk struct {
-	Choices []openAIChoice `json:"choices"`
-	Usage   *openAIUsage   `json:"usage,omitempty"`
+	Choices []openAIChoice  `json:"choices"`
+	Usage   *openAIUsage    `json:"usage,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
 }
 
 type openAIChoice struct {
@@ -208,8 +285,10 @@ type openAIChoice struct {
 }
 
 type openAIDelta struct {
-	Content   string             `json:"content"`
-	ToolCalls []openAIToolCallDelta `json:"tool_calls"`
+	Content          string                `json:"content"`
+	ReasoningContent string                `json:"reasoning_content"` // DeepSeek, Qwen, vLLM, Kimi
+	Reasoning        string                `json:"reasoning"`         // OpenRouter, Groq, Ollama
+	ToolCalls        []openAIToolCallDelta `json:"tool_calls"`
 }
 
 type openAIToolCallDelta struct {
@@ -224,25 +303,23 @@ type openAIFunctionCallDelta struct {
 }
 
 type openAIUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-}
-
-// APIError represents an HTTP error from the LLM provider.
-type APIError struct {
-	StatusCode int
-	Body       string
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"` // DeepSeek
 }
 
-func (e *APIError) Error() string {
-	return fmt.Sprintf("LLM API error (status %d): %s", e.StatusCode, e.Body)
+// toUsage converts to Usage, moving cached prompt tokens out of InputTokens.
+func (u *openAIUsage) toUsage() *Usage {
+	cached := u.PromptCacheHitTokens
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		cached = u.PromptTokensDetails.CachedTokens
+	}
+	return &Usage{
+		InputTokens:     u.PromptTokens - cached,
+		OutputTokens:    u.CompletionTokens,
+		CacheReadTokens: cached,
+	}
 }
-
-// IsRateLimit returns true if this is a rate limit error.
-func (e *APIError) IsRateLimit() bool { return e.StatusCode == 429 }
-
-// IsAuth returns true if this is an authentication error.
-func (e *APIError) IsAuth() bool { return e.StatusCode == 401 || e.StatusCode == 403 }
-
-// IsContextOverflow returns true if context is too long.
-func (e *APIError) IsContextOverflow() bool { return e.StatusCode == 400 }
@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIReasoning(t *testing.T) {
+	stream := `data: {"choices":[{"delta":{"role":"assistant","reasoning_content":"Let me "}}]}
+
+data: {"choices":[{"delta":{"reasoning":"think."}}]}
+
+data: {"choices":[{"delta":{"content":"42"},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+	ch := make(chan StreamEvent, 32)
+	go (&OpenAIClient{}).consumeSSE(io.NopCloser(strings.NewReader(stream)), ch)
+	result, err := ConsumeStream(context.Background(), ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message.Reasoning != "Let me think." || result.Text != "42" {
+		t.Errorf("result = %+v", result.Message)
+	}
+
+	// Reasoning is not sent back to the model
+	req := (&OpenAIClient{}).buildRequest(ChatParams{Messages: []Message{UserMessage("?"), result.Message, UserMessage("why?")}})
+	for _, m := range req["messages"].([]map[string]any) {
+		if _, ok := m["reasoning_content"]; ok {
+			t.Errorf("message replays reasoning: %v", m)
+		}
+	}
+}
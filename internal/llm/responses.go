@@ -0,0 +1,470 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxResponseChains bounds the previous_response_id cache; it is simply reset when full.
+const maxResponseChains = 1024
+
+// ResponsesClient implements Client for OpenAI's Responses API (/v1/responses).
+// The JSONL transcript stays the source of truth: every request is rendered from ChatParams.Messages,
+// and previous_response_id is only used as an optimisation when the rendered input extends a
+// conversation prefix this client has already sent.
+type ResponsesClient struct {
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	chains map[string]string // hash of model + rendered input prefix → response ID that ends there
+}
+
+func NewResponsesClient() *ResponsesClient {
+	return &ResponsesClient{HTTPClient: http.DefaultClient, chains: make(map[string]string)}
+}
+
+func (c *ResponsesClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	baseURL := params.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+	endpoint := strings.TrimRight(baseURL, "/") + "/v1/responses"
+
+	input := responsesInput(inlineMedia(ctx, params.Messages, false, true))
+	prefixes := responsesPrefixHashes(params.Model, input)
+
+	send := func(previousID string, items []map[string]any) (*http.Response, error) {
+		body := c.buildRequest(params, previousID, items)
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+		return doWithRetry(ctx, c.HTTPClient, params, func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
+			if err != nil {
+				return nil, fmt.Errorf("create request: %w", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+params.APIKey)
+			return req, nil
+		})
+	}
+
+	var resp *http.Response
+	var err error
+	if previousID, n := c.lookupChain(params, prefixes); previousID != "" {
+		resp, err = send(previousID, input[n:])
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusNotFound) {
+			// Expired or unknown response ID: forget it and send the full input instead.
+			slog.Info("previous_response_id rejected, resending full input", "model", params.Model, "error", err)
+			c.forgetChain(prefixes[n-1])
+			resp, err = send("", input)
+		}
+	} else {
+		resp, err = send("", input)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 32)
+	go c.consumeSSE(resp.Body, ch, func(responseID string, msg Message) {
+		if !params.ChainResponses || responseID == "" {
+			return
+		}
+		// Remember which response ends the conversation input + this reply, as the next request will render it.
+		full := append(append([]map[string]any(nil), input...), responsesAssistantItems(msg)...)
+		hashes := responsesPrefixHashes(params.Model, full)
+		c.rememberChain(hashes[len(hashes)-1], responseID)
+	})
+	return ch, nil
+}
+
+// lookupChain returns the stored response ID for the longest rendered-input prefix that leaves at least
+// one new item to send, and that prefix's length. Returns "" when chaining is off or nothing matches.
+func (c *ResponsesClient) lookupChain(params ChatParams, prefixes []string) (string, int) {
+	if !params.ChainResponses {
+		return "", 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n := len(prefixes) - 1; n >= 1; n-- {
+		if id, ok := c.chains[prefixes[n-1]]; ok {
+			return id, n
+		}
+	}
+	return "", 0
+}
+
+func (c *ResponsesClient) rememberChain(prefixHash, responseID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chains == nil || len(c.chains) >= maxResponseChains {
+		c.chains = make(map[string]string)
+	}
+	c.chains[prefixHash] = responseID
+}
+
+func (c *ResponsesClient) forgetChain(prefixHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.chains, prefixHash)
+}
+
+// responsesPrefixHashes returns h[i] = hash of model + items[:i+1], computed as a rolling chain.
+func responsesPrefixHashes(model string, items []map[string]any) []string {
+	hashes := make([]string, len(items))
+	prev := sha256.Sum256([]byte(model))
+	for i, item := range items {
+		b, _ := json.Marshal(item)
+		h := sha256.New()
+		h.Write(prev[:])
+		h.Write(b)
+		copy(prev[:], h.Sum(nil))
+		hashes[i] = hex.EncodeToString(prev[:])
+	}
+	return hashes
+}
+
+func (c *ResponsesClient) buildRequest(params ChatParams, previousID string, input []map[string]any) map[string]any {
+	req := map[string]any{
+		"model":  params.Model,
+		"input":  input,
+		"stream": true,
+		// Chaining needs the response stored server-side; otherwise keep nothing and carry reasoning ourselves.
+		"store": params.ChainResponses,
+	}
+	if previousID != "" {
+		req["previous_response_id"] = previousID
+	}
+	if !params.ChainResponses {
+		req["include"] = []string{"reasoning.encrypted_content"}
+	}
+	if params.System != "" {
+		// instructions are not inherited through previous_response_id, so they are always sent.
+		req["instructions"] = params.System
+	}
+
+	if len(params.Tools) > 0 {
+		tools := make([]map[string]any, len(params.Tools))
+		for i, t := range params.Tools {
+			tools[i] = map[string]any{
+				"type":        "function",
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  json.RawMessage(t.Parameters),
+			}
+		}
+		req["tools"] = tools
+		if name := params.ToolChoice.Tool(); name != "" {
+			req["tool_choice"] = map[string]any{"type": "function", "name": name}
+		} else if params.ToolChoice != "" {
+			req["tool_choice"] = string(params.ToolChoice)
+		}
+		if params.ParallelToolCalls != nil {
+			req["parallel_tool_calls"] = *params.ParallelToolCalls
+		}
+	}
+
+	if params.ThinkingBudget > 0 {
+		req["reasoning"] = map[string]any{
+			"effort":  responsesEffort(params.ThinkingBudget),
+			"summary": "auto",
+		}
+	}
+	if params.MaxTokens > 0 {
+		req["max_output_tokens"] = params.MaxTokens
+	}
+	if params.Temperature != nil {
+		req["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		req["top_p"] = *params.TopP
+	}
+	if rs := params.ResponseSchema; rs != nil {
+		req["text"] = map[string]any{
+			"format": map[string]any{
+				"type":   "json_schema",
+				"name":   rs.SchemaName(),
+				"schema": rs.Schema,
+				"strict": rs.Strict,
+			},
+		}
+	}
+	return req
+}
+
+// responsesEffort maps a thinking budget in tokens onto the Responses API's reasoning effort levels.
+func responsesEffort(budget int) string {
+	switch {
+	case budget < 4096:
+		return "low"
+	case budget < 16384:
+		return "medium"
+	}
+	return "high"
+}
+
+var responsesMedia = mediaSupport{pdf: true, imageURLs: true}
+
+// responsesInput renders transcript messages as Responses API input items.
+func responsesInput(messages []Message) []map[string]any {
+	items := make([]map[string]any, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case RoleSystem:
+			// System prompt goes in the top-level instructions field
+			continue
+		case RoleUser:
+			content := []map[string]any{{"type": "input_text", "text": userText(msg, responsesMedia)}}
+			for _, doc := range pdfDocuments(msg) {
+				content = append(content, map[string]any{
+					"type":      "input_file",
+					"filename":  pdfFilename(doc),
+					"file_data": "data:" + doc.MIME + ";base64," + doc.Base64,
+				})
+			}
+			for _, img := range msg.Images {
+				if img.Base64 != "" {
+					mime := img.MIME
+					if mime == "" {
+						mime = "image/png"
+					}
+					content = append(content, map[string]any{
+						"type":      "input_image",
+						"image_url": "data:" + mime + ";base64," + img.Base64,
+					})
+				} else if img.URL != "" {
+					content = append(content, map[string]any{"type": "input_image", "image_url": img.URL})
+				}
+			}
+			items = append(items, map[string]any{"role": "user", "content": content})
+		case RoleAssistant:
+			items = append(items, responsesAssistantItems(msg)...)
+		case RoleTool:
+			items = append(items, map[string]any{
+				"type":    "function_call_output",
+				"call_id": msg.ToolCallID,
+				"output":  msg.Content,
+			})
+		}
+	}
+	return items
+}
+
+// responsesAssistantItems renders one assistant message: reasoning items (only those we can replay,
+// i.e. with encrypted content), then the text message, then function calls.
+func responsesAssistantItems(msg Message) []map[string]any {
+	var items []map[string]any
+	for _, b := range msg.Thinking {
+		if b.Type != "reasoning" || b.ID == "" || b.Data == "" {
+			continue
+		}
+		summary := []map[string]any{}
+		if b.Thinking != "" {
+			summary = append(summary, map[string]any{"type": "summary_text", "text": b.Thinking})
+		}
+		items = append(items, map[string]any{
+			"type":              "reasoning",
+			"id":                b.ID,
+			"summary":           summary,
+			"encrypted_content": b.Data,
+		})
+	}
+	if msg.Content != "" {
+		items = append(items, map[string]any{
+			"type":    "message",
+			"role":    "assistant",
+			"content": []map[string]any{{"type": "output_text", "text": msg.Content}},
+		})
+	}
+	for _, tc := range msg.ToolCalls {
+		args := tc.Arguments
+		if args == "" {
+			args = "{}"
+		}
+		items = append(items, map[string]any{
+			"type":      "function_call",
+			"call_id":   tc.ID,
+			"name":      tc.Name,
+			"arguments": args,
+		})
+	}
+	return items
+}
+
+// consumeSSE translates Responses API stream events into StreamEvents. onComplete is called with the
+// response ID and the assembled assistant message once the response completes successfully.
+func (c *ResponsesClient) consumeSSE(body io.ReadCloser, out chan<- StreamEvent, onComplete func(responseID string, msg Message)) {
+	defer close(out)
+	defer body.Close()
+
+	// Mirror what the caller accumulates so the reply can be rendered for the chain cache.
+	acc := NewStreamAccumulator()
+	emit := func(ev StreamEvent) {
+		_ = acc.Add(ev)
+		out <- ev
+	}
+
+	toolIndex := make(map[int]int)     // output_index → tool call index
+	thinkingIndex := make(map[int]int) // output_index → thinking block index
+
+	for event := range ParseSSE(body) {
+		if event.Err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("read stream: %w", event.Err)}
+			return
+		}
+		var ev responsesEvent
+		if err := json.Unmarshal([]byte(event.Data), &ev); err != nil {
+			out <- StreamEvent{Type: "error", Error: fmt.Errorf("parse event: %w", err)}
+			return
+		}
+		typ := ev.Type
+		if typ == "" {
+			typ = event.Event
+		}
+
+		switch typ {
+		case "response.output_text.delta":
+			emit(StreamEvent{Type: "text_delta", Text: ev.Delta})
+
+		case "response.output_item.added":
+			switch ev.Item.Type {
+			case "function_call":
+				idx := len(toolIndex)
+				toolIndex[ev.OutputIndex] = idx
+				emit(StreamEvent{
+					Type:          "tool_call_delta",
+					ToolCallIndex: idx,
+					ToolCallID:    ev.Item.CallID,
+					ToolCallName:  ev.Item.Name,
+				})
+			case "reasoning":
+				idx := len(thinkingIndex)
+				thinkingIndex[ev.OutputIndex] = idx
+				emit(StreamEvent{
+					Type:          "thinking_delta",
+					ThinkingIndex: idx,
+					ThinkingType:  "reasoning",
+					ItemID:        ev.Item.ID,
+				})
+			}
+
+		case "response.function_call_arguments.delta":
+			if idx, ok := toolIndex[ev.OutputIndex]; ok {
+				emit(StreamEvent{Type: "tool_call_delta", ToolCallIndex: idx, ToolCallArgs: ev.Delta})
+			}
+
+		case "response.reasoning_summary_text.delta":
+			if idx, ok := thinkingIndex[ev.OutputIndex]; ok {
+				emit(StreamEvent{Type: "thinking_delta", ThinkingIndex: idx, Text: ev.Delta})
+			}
+
+		case "response.reasoning_summary_part.done":
+			// Separate summary parts so they do not run together.
+			if idx, ok := thinkingIndex[ev.OutputIndex]; ok {
+				emit(StreamEvent{Type: "thinking_delta", ThinkingIndex: idx, Text: "\n\n"})
+			}
+
+		case "response.output_item.done":
+			if ev.Item.Type == "reasoning" && ev.Item.EncryptedContent != "" {
+				if idx, ok := thinkingIndex[ev.OutputIndex]; ok {
+					emit(StreamEvent{Type: "thinking_delta", ThinkingIndex: idx, RedactedData: ev.Item.EncryptedContent})
+				}
+			}
+
+		case "response.completed", "response.incomplete":
+			r := ev.Response
+			if r.Usage != nil {
+				emit(StreamEvent{Type: "usage", Usage: r.Usage.toUsage()})
+			}
+			stopReason := "stop"
+			if r.Status == "incomplete" {
+				stopReason = "incomplete"
+				if r.IncompleteDetails != nil && r.IncompleteDetails.Reason == "max_output_tokens" {
+					stopReason = "max_tokens"
+				}
+			}
+			emit(StreamEvent{Type: "done", Text: stopReason})
+			if r.Status == "completed" && onComplete != nil {
+				onComplete(r.ID, acc.Result().Message)
+			}
+			return
+
+		case "response.failed":
+			body := `{"error":{"message":"response failed"}}`
+			if e := ev.Response.Error; e != nil {
+				b, _ := json.Marshal(map[string]any{"error": e})
+				body = string(b)
+			}
+			out <- StreamEvent{Type: "error", Error: NewAPIError(0, body)}
+			return
+
+		case "error":
+			out <- StreamEvent{Type: "error", Error: NewAPIError(0, event.Data)}
+			return
+		}
+	}
+}
+
+// Responses API streaming event types
+
+type responsesEvent struct {
+	Type        string `json:"type"`
+	OutputIndex int    `json:"output_index"`
+	Delta       string `json:"delta"`
+	Item        struct {
+		Type             string `json:"type"`
+		ID               string `json:"id"`
+		CallID           string `json:"call_id"`
+		Name             string `json:"name"`
+		EncryptedContent string `json:"encrypted_content"`
+	} `json:"item"`
+	Response struct {
+		ID                string          `json:"id"`
+		Status            string          `json:"status"`
+		Usage             *responsesUsage `json:"usage"`
+		IncompleteDetails *struct {
+			Reason string `json:"reason"`
+		} `json:"incomplete_details"`
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"response"`
+	// For "error" events
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type responsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	InputTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+}
+
+// toUsage converts to Usage, moving cached input tokens out of InputTokens.
+func (u *responsesUsage) toUsage() *Usage {
+	cached := 0
+	if u.InputTokensDetails != nil {
+		cached = u.InputTokensDetails.CachedTokens
+	}
+	return &Usage{
+		InputTokens:     u.InputTokens - cached,
+		OutputTokens:    u.OutputTokens,
+		CacheReadTokens: cached,
+	}
+}
@@ -0,0 +1,229 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeResponses answers each /v1/responses request with the SSE events returned by respond and records
+// the request bodies. A request chained on a response ID listed in expired gets a 400.
+type fakeResponses struct {
+	respond func(n int) []string // events (JSON data) for the n-th (1-based) successful response
+	expired map[string]bool
+
+	requests []map[string]any
+	served   int
+}
+
+func (f *fakeResponses) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	var body map[string]any
+	_ = json.Unmarshal(raw, &body)
+	f.requests = append(f.requests, body)
+	if r.URL.Path != "/v1/responses" {
+		http.NotFound(w, r)
+		return
+	}
+	if id, _ := body["previous_response_id"].(string); f.expired[id] {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":{"message":"Previous response with id '%s' not found.","type":"invalid_request_error","param":"previous_response_id"}}`, id)
+		return
+	}
+	f.served++
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, data := range f.respond(f.served) {
+		var ev struct{ Type string }
+		_ = json.Unmarshal([]byte(data), &ev)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	}
+}
+
+func newFakeResponses(t *testing.T, f *fakeResponses) (Client, ChatParams) {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return NewClient("openai-responses", srv.Client()), ChatParams{
+		Model:   "gpt-5",
+		APIKey:  "test-key",
+		BaseURL: srv.URL,
+		Retry:   RetryPolicy{MaxRetries: -1},
+	}
+}
+
+func chatResponses(t *testing.T, client Client, params ChatParams) (*StreamResult, error) {
+	t.Helper()
+	stream, err := client.Chat(context.Background(), params)
+	if err != nil {
+		return nil, err
+	}
+	return ConsumeStream(context.Background(), stream)
+}
+
+// textResponse is a completed response with the given ID and text.
+func textResponse(id, text string) []string {
+	return []string{
+		`{"type":"response.output_item.added","output_index":0,"item":{"type":"message","id":"msg_` + id + `"}}`,
+		`{"type":"response.output_text.delta","output_index":0,"delta":"` + text + `"}`,
+		`{"type":"response.completed","response":{"id":"` + id + `","status":"completed","usage":{"input_tokens":10,"output_tokens":2}}}`,
+	}
+}
+
+func TestResponsesStream(t *testing.T) {
+	f := &fakeResponses{respond: func(int) []string {
+		return []string{
+			`{"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}`,
+			`{"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1"}}`,
+			`{"type":"response.reasoning_summary_text.delta","output_index":0,"delta":"Thinking it over."}`,
+			`{"type":"response.reasoning_summary_part.done","output_index":0}`,
+			`{"type":"response.output_item.done","output_index":0,"item":{"type":"reasoning","id":"rs_1","encrypted_content":"enc-1"}}`,
+			`{"type":"response.output_item.added","output_index":1,"item":{"type":"message","id":"msg_1"}}`,
+			`{"type":"response.output_text.delta","output_index":1,"delta":"Let me "}`,
+			`{"type":"response.output_text.delta","output_index":1,"delta":"check."}`,
+			`{"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","call_id":"call_1","name":"weather"}}`,
+			`{"type":"response.function_call_arguments.delta","output_index":2,"delta":"{\"city\":"}`,
+			`{"type":"response.function_call_arguments.delta","output_index":2,"delta":"\"Paris\"}"}`,
+			`{"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":100,"output_tokens":20,"input_tokens_details":{"cached_tokens":60}}}}`,
+		}
+	}}
+	client, params := newFakeResponses(t, f)
+	params.System = "be brief"
+	params.ThinkingBudget = 8000
+	params.Tools = []ToolDef{{Name: "weather", Parameters: json.RawMessage(`{"type":"object"}`)}}
+	params.Messages = []Message{UserMessage("weather in Paris?")}
+	result, err := chatResponses(t, client, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Text != "Let me check." || result.StopReason != "stop" {
+		t.Errorf("result = %+v", result)
+	}
+	if len(result.ToolCalls) != 1 || result.ToolCalls[0].ID != "call_1" || result.ToolCalls[0].Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool calls = %+v", result.ToolCalls)
+	}
+	want := ThinkingBlock{Type: "reasoning", ID: "rs_1", Thinking: "Thinking it over.\n\n", Data: "enc-1"}
+	if len(result.Thinking) != 1 || result.Thinking[0] != want {
+		t.Errorf("thinking = %+v", result.Thinking)
+	}
+	if u := result.Usage; u == nil || u.InputTokens != 40 || u.CacheReadTokens != 60 || u.OutputTokens != 20 {
+		t.Errorf("usage = %+v", result.Usage)
+	}
+
+	req := f.requests[0]
+	if req["instructions"] != "be brief" || req["store"] != false || req["previous_response_id"] != nil {
+		t.Errorf("request = %v", req)
+	}
+	if fmt.Sprint(req["include"]) != "[reasoning.encrypted_content]" || req["reasoning"].(map[string]any)["effort"] != "medium" {
+		t.Errorf("include = %v, reasoning = %v", req["include"], req["reasoning"])
+	}
+
+	// The reply goes back as reasoning, message and function_call items
+	params.Messages = append(params.Messages, result.Message, Message{Role: RoleTool, ToolCallID: "call_1", Content: "sunny"})
+	if _, err := chatResponses(t, client, params); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, item := range f.requests[1]["input"].([]any) {
+		m := item.(map[string]any)
+		typ, _ := m["type"].(string)
+		if typ == "" {
+			typ = m["role"].(string) // user messages carry only a role
+		}
+		types = append(types, typ)
+	}
+	if got := strings.Join(types, ","); got != "user,reasoning,message,function_call,function_call_output" {
+		t.Errorf("input items = %s", got)
+	}
+}
+
+func TestResponsesStopReasons(t *testing.T) {
+	var events []string
+	f := &fakeResponses{respond: func(int) []string { return events }}
+	client, params := newFakeResponses(t, f)
+	params.Messages = []Message{UserMessage("hi")}
+
+	events = []string{
+		`{"type":"response.output_text.delta","delta":"par"}`,
+		`{"type":"response.incomplete","response":{"id":"resp_1","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"}}}`,
+	}
+	if result, err := chatResponses(t, client, params); err != nil || result.StopReason != "max_tokens" || result.Text != "par" {
+		t.Errorf("incomplete: result = %+v, err = %v", result, err)
+	}
+
+	events = []string{`{"type":"response.failed","response":{"id":"resp_2","status":"failed","error":{"code":"server_error","message":"boom"}}}`}
+	if _, err := chatResponses(t, client, params); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("failed: err = %v", err)
+	}
+}
+
+func TestResponsesChaining(t *testing.T) {
+	f := &fakeResponses{
+		respond: func(n int) []string { return textResponse(fmt.Sprintf("resp_%d", n), fmt.Sprintf("answer %d", n)) },
+		expired: map[string]bool{},
+	}
+	client, params := newFakeResponses(t, f)
+	params.ChainResponses = true
+	params.System = "be brief"
+
+	turn := func(text string) *StreamResult {
+		t.Helper()
+		params.Messages = append(params.Messages, UserMessage(text))
+		result, err := chatResponses(t, client, params)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		params.Messages = append(params.Messages, result.Message)
+		return result
+	}
+	last := func() (previousID any, inputItems int) {
+		req := f.requests[len(f.requests)-1]
+		return req["previous_response_id"], len(req["input"].([]any))
+	}
+
+	turn("one")
+	if id, n := last(); id != nil || n != 1 || f.requests[0]["store"] != true {
+		t.Fatalf("first request: previous_response_id %v, %d items, store %v", id, n, f.requests[0]["store"])
+	}
+
+	// The next turn extends the stored conversation: only the new user message is sent
+	turn("two")
+	if id, n := last(); id != "resp_1" || n != 1 {
+		t.Errorf("second request: previous_response_id %v, %d items", id, n)
+	}
+	if f.requests[1]["instructions"] != "be brief" {
+		t.Errorf("instructions not resent: %v", f.requests[1])
+	}
+
+	// An expired response ID falls back to resending the full input
+	f.expired["resp_2"] = true
+	if r := turn("three"); r.Text != "answer 3" {
+		t.Errorf("after fallback: %+v", r)
+	}
+	if len(f.requests) != 4 {
+		t.Fatalf("requests = %d, want the rejected one and its resend", len(f.requests))
+	}
+	if id, n := last(); id != nil || n != 5 {
+		t.Errorf("resend: previous_response_id %v, %d items", id, n)
+	}
+
+	// The resent response is chained on again
+	turn("four")
+	if id, n := last(); id != "resp_3" || n != 1 {
+		t.Errorf("after resend: previous_response_id %v, %d items", id, n)
+	}
+
+	// A transcript that differs from what was sent (e.g. after compaction) is sent in full
+	params.Messages = []Message{SystemMessage("summary"), UserMessage("five")}
+	if _, err := chatResponses(t, client, params); err != nil {
+		t.Fatal(err)
+	}
+	if id, n := last(); id != nil || n != 1 {
+		t.Errorf("diverged transcript: previous_response_id %v, %d items", id, n)
+	}
+}
@@ -0,0 +1,284 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxRetries   = 3
+	DefaultInitialDelay = time.Second
+	DefaultMaxDelay     = 30 * time.Second
+)
+
+// RetryPolicy controls how failed provider requests are retried.
+// Zero values fall back to the defaults; a negative MaxRetries disables retries.
+type RetryPolicy struct {
+	MaxRetries   int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// RetryInfo describes one pending retry, passed to ChatParams.OnRetry before waiting.
+type RetryInfo struct {
+	Attempt    int           // 1-based retry number
+	MaxRetries int           // total retries allowed
+	Delay      time.Duration // how long we wait before the next attempt
+	Err        error         // the error that triggered the retry
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxRetries == 0 {
+		p.MaxRetries = DefaultMaxRetries
+	}
+	if p.MaxRetries < 0 {
+		p.MaxRetries = 0
+	}
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = DefaultInitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultMaxDelay
+	}
+	return p
+}
+
+// backoff returns a jittered exponential delay for the given 1-based attempt,
+// uniformly distributed in [d/2, d] where d = InitialDelay * 2^(attempt-1), capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// doWithRetry sends the request built by newRequest, retrying transport errors and
+// retryable API errors (rate limit, overload, 5xx) according to params.Retry.
+// newRequest is called once per attempt so the body can be re-read.
+// On success the caller owns resp.Body.
+func doWithRetry(ctx context.Context, hc *http.Client, params ChatParams, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	policy := params.Retry.withDefaults()
+	capture := captureFrom(ctx)
+
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		if capture != nil {
+			capture.begin(req)
+		}
+
+		var lastErr error
+		var retryAfter time.Duration
+		resp, err := hc.Do(req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = &TransportError{Err: err}
+		case resp.StatusCode != http.StatusOK:
+			errBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if capture != nil {
+				capture.finish(resp.StatusCode, errBody)
+			}
+			apiErr := NewAPIError(resp.StatusCode, string(errBody))
+			apiErr.RetryAfter = parseRetryAfter(resp.Header, time.Now())
+			if !apiErr.IsRetryable() {
+				return nil, apiErr
+			}
+			lastErr = apiErr
+			retryAfter = apiErr.RetryAfter
+		default:
+			if capture != nil {
+				capture.finish(resp.StatusCode, nil)
+				resp.Body = capture.tee(resp.Body)
+			}
+			return resp, nil
+		}
+
+		// The server's requested wait is a lower bound: retrying sooner would only be refused again,
+		// so a wait beyond MaxDelay fails now (and lets the caller fail over) instead.
+		if attempt >= policy.MaxRetries || retryAfter > policy.MaxDelay {
+			return nil, lastErr
+		}
+
+		delay := max(policy.backoff(attempt+1), retryAfter)
+		if params.OnRetry != nil {
+			params.OnRetry(RetryInfo{
+				Attempt:    attempt + 1,
+				MaxRetries: policy.MaxRetries,
+				Delay:      delay,
+				Err:        lastErr,
+			})
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter reads the server's requested wait from Retry-After / retry-after-ms,
+// or from exhausted anthropic-ratelimit-* / x-ratelimit-* reset headers. Returns 0 if none.
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			return time.Duration(secs * float64(time.Second))
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			if d := t.Sub(now); d > 0 {
+				return d
+			}
+		}
+	}
+
+	// Anthropic: anthropic-ratelimit-<limit>-remaining / -reset (RFC 3339 timestamp).
+	var wait time.Duration
+	for _, limit := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		prefix := "anthropic-ratelimit-" + limit
+		if h.Get(prefix+"-remaining") != "0" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, h.Get(prefix+"-reset")); err == nil {
+			if d := t.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	// OpenAI: x-ratelimit-remaining-<limit> / x-ratelimit-reset-<limit> (Go-style duration like "6m0s").
+	for _, limit := range []string{"requests", "tokens"} {
+		if h.Get("x-ratelimit-remaining-"+limit) != "0" {
+			continue
+		}
+		if d, err := time.ParseDuration(strings.TrimSpace(h.Get("x-ratelimit-reset-" + limit))); err == nil && d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// TransportError wraps a network-level failure (connection refused, reset, DNS, ...).
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string { return "http request: " + e.Err.Error() }
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// IsRetryable reports whether err is worth retrying or failing over on:
+// transport errors, stalled streams and rate-limit / overload / 5xx API errors.
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.IsRetryable()
+	}
+	var tErr *TransportError
+	return errors.As(err, &tErr) || errors.Is(err, ErrStreamStalled)
+}
+
+// StallRetryClient re-sends a request whose stream stalls (ErrStreamStalled) before any text, thinking
+// or tool call reached the caller, up to params.Retry's MaxRetries times with the policy's backoff; each
+// retry is reported through params.OnRetry. Events before the first content (e.g. usage) are held back
+// and dropped with a stalled attempt, so a retried turn is not counted twice. A stall after content has
+// been streamed is passed on as the stream's error.
+type StallRetryClient struct {
+	Client Client
+}
+
+func NewStallRetryClient(client Client) *StallRetryClient {
+	return &StallRetryClient{Client: client}
+}
+
+func (c *StallRetryClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	stream, err := c.Client.Chat(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	policy := params.Retry.withDefaults()
+
+	out := make(chan StreamEvent, 32)
+	send := func(ev StreamEvent) {
+		select {
+		case out <- ev:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer close(out)
+		for attempt := 1; ; attempt++ {
+			var stalled error
+			var held []StreamEvent // events of this attempt before its first content
+			streamed := false
+			for ev := range stream {
+				switch ev.Type {
+				case "text_delta", "thinking_delta", "reasoning_delta", "tool_call_delta":
+					if !streamed {
+						streamed = true
+						for _, h := range held {
+							send(h)
+						}
+						held = nil
+					}
+				case "error":
+					if !streamed && attempt <= policy.MaxRetries && errors.Is(ev.Error, ErrStreamStalled) {
+						stalled = ev.Error
+						continue
+					}
+				}
+				if streamed {
+					send(ev)
+				} else {
+					held = append(held, ev)
+				}
+			}
+			if stalled == nil {
+				for _, h := range held {
+					send(h)
+				}
+				return
+			}
+
+			delay := policy.backoff(attempt)
+			if params.OnRetry != nil {
+				params.OnRetry(RetryInfo{Attempt: attempt, MaxRetries: policy.MaxRetries, Delay: delay, Err: stalled})
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				send(StreamEvent{Type: "error", Error: ctx.Err()})
+				return
+			case <-timer.C:
+			}
+			if stream, err = c.Client.Chat(ctx, params); err != nil {
+				send(StreamEvent{Type: "error", Error: err})
+				return
+			}
+		}
+	}()
+	return out, nil
+}
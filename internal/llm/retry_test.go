@@ -0,0 +1,245 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusServer answers each request with the next status of the list (the last one repeats);
+// headers are sent with every non-200 answer.
+func statusServer(t *testing.T, headers map[string]string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		status := statuses[min(n, len(statuses))-1]
+		if status != http.StatusOK {
+			for k, v := range headers {
+				w.Header().Set(k, v)
+			}
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":{"message":"status ` + strconv.Itoa(status) + `"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestDoWithRetry(t *testing.T) {
+	fast := RetryPolicy{InitialDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	cases := []struct {
+		name      string
+		statuses  []int
+		headers   map[string]string
+		policy    RetryPolicy
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"rate limit then ok", []int{429, 200}, nil, fast, 2, false},
+		{"overloaded then ok", []int{529, 200}, nil, fast, 2, false},
+		{"server errors then ok", []int{500, 502, 200}, nil, fast, 3, false},
+		{"bad request", []int{400, 200}, nil, fast, 1, true},
+		{"unauthorized", []int{401, 200}, nil, fast, 1, true},
+		{"retries exhausted", []int{503}, nil, RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}, 3, true},
+		{"retries disabled", []int{429, 200}, nil, RetryPolicy{MaxRetries: -1}, 1, true},
+		{"retry-after within max delay", []int{429, 200}, map[string]string{"retry-after-ms": "20"}, fast, 2, false},
+		{"retry-after beyond max delay", []int{429, 200}, map[string]string{"Retry-After": "120"}, fast, 1, true},
+	}
+	for _, c := range cases {
+		srv, calls := statusServer(t, c.headers, c.statuses...)
+		var retries []RetryInfo
+		params := ChatParams{Retry: c.policy, OnRetry: func(info RetryInfo) { retries = append(retries, info) }}
+		resp, err := doWithRetry(context.Background(), srv.Client(), params, func() (*http.Request, error) {
+			return http.NewRequest("POST", srv.URL, nil)
+		})
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v", c.name, err)
+		}
+		if got := calls.Load(); got != c.wantCalls {
+			t.Errorf("%s: calls = %d, want %d", c.name, got, c.wantCalls)
+		}
+		if len(retries) != int(c.wantCalls)-1 {
+			t.Errorf("%s: retries = %+v", c.name, retries)
+		}
+		if c.headers["retry-after-ms"] != "" && (len(retries) != 1 || retries[0].Delay < 20*time.Millisecond) {
+			t.Errorf("%s: retries = %+v, want a wait of at least 20ms", c.name, retries)
+		}
+		var apiErr *APIError
+		if c.headers["Retry-After"] != "" && (!errors.As(err, &apiErr) || apiErr.RetryAfter != 120*time.Second) {
+			t.Errorf("%s: err = %#v, want the API error with its Retry-After", c.name, err)
+		}
+	}
+}
+
+func TestDoWithRetryCancelDuringWait(t *testing.T) {
+	srv, calls := statusServer(t, map[string]string{"Retry-After": "5"}, 429)
+	ctx, cancel := context.WithCancel(context.Background())
+	params := ChatParams{Retry: RetryPolicy{MaxDelay: time.Minute}, OnRetry: func(RetryInfo) { cancel() }}
+	start := time.Now()
+	_, err := doWithRetry(ctx, srv.Client(), params, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", srv.URL, nil)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %s, want immediately", elapsed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d", calls.Load())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"none", nil, 0},
+		{"retry-after-ms", map[string]string{"retry-after-ms": "1500"}, 1500 * time.Millisecond},
+		{"retry-after-ms wins", map[string]string{"retry-after-ms": "200", "Retry-After": "7"}, 200 * time.Millisecond},
+		{"seconds", map[string]string{"Retry-After": "7"}, 7 * time.Second},
+		{"http date", map[string]string{"Retry-After": now.Add(30 * time.Second).Format(http.TimeFormat)}, 30 * time.Second},
+		{"http date in the past", map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, 0},
+		{"garbage", map[string]string{"Retry-After": "soon"}, 0},
+		{"anthropic exhausted", map[string]string{
+			"anthropic-ratelimit-requests-remaining": "0",
+			"anthropic-ratelimit-requests-reset":     now.Add(10 * time.Second).Format(time.RFC3339),
+		}, 10 * time.Second},
+		{"anthropic longest exhausted limit", map[string]string{
+			"anthropic-ratelimit-requests-remaining":     "0",
+			"anthropic-ratelimit-requests-reset":         now.Add(10 * time.Second).Format(time.RFC3339),
+			"anthropic-ratelimit-input-tokens-remaining": "0",
+			"anthropic-ratelimit-input-tokens-reset":     now.Add(40 * time.Second).Format(time.RFC3339),
+		}, 40 * time.Second},
+		{"anthropic not exhausted", map[string]string{
+			"anthropic-ratelimit-tokens-remaining": "5",
+			"anthropic-ratelimit-tokens-reset":     now.Add(10 * time.Second).Format(time.RFC3339),
+		}, 0},
+		{"openai exhausted", map[string]string{
+			"x-ratelimit-remaining-tokens": "0",
+			"x-ratelimit-reset-tokens":     "6m0s",
+		}, 6 * time.Minute},
+		{"openai not exhausted", map[string]string{
+			"x-ratelimit-remaining-requests": "3",
+			"x-ratelimit-reset-requests":     "1s",
+		}, 0},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		for k, v := range c.headers {
+			h.Set(k, v)
+		}
+		if got := parseRetryAfter(h, now); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialDelay: time.Second, MaxDelay: 10 * time.Second}.withDefaults()
+	cases := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{10, 5 * time.Second, 10 * time.Second}, // capped at MaxDelay
+	}
+	for _, c := range cases {
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(c.attempt); d < c.min || d > c.max {
+				t.Fatalf("backoff(%d) = %s, want in [%s, %s]", c.attempt, d, c.min, c.max)
+			}
+		}
+	}
+
+	if got := (RetryPolicy{}).withDefaults(); got.MaxRetries != DefaultMaxRetries || got.MaxDelay != DefaultMaxDelay {
+		t.Errorf("defaults = %+v", got)
+	}
+	if got := (RetryPolicy{MaxRetries: -1}).withDefaults().MaxRetries; got != 0 {
+		t.Errorf("negative MaxRetries = %d, want retries disabled", got)
+	}
+}
+
+// scriptedClient answers the n-th Chat call with the n-th list of events (the last one repeats).
+type scriptedClient struct {
+	attempts [][]StreamEvent
+	calls    int
+}
+
+func (c *scriptedClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	events := c.attempts[min(c.calls, len(c.attempts)-1)]
+	c.calls++
+	out := make(chan StreamEvent, len(events))
+	for _, ev := range events {
+		out <- ev
+	}
+	close(out)
+	return out, nil
+}
+
+func TestStallRetryClient(t *testing.T) {
+	stall := StreamEvent{Type: "error", Error: &StreamStalledError{Idle: time.Second}}
+	usage := StreamEvent{Type: "usage", Usage: &Usage{InputTokens: 100}}
+	inner := &scriptedClient{attempts: [][]StreamEvent{
+		{usage, stall},
+		{usage, {Type: "text_delta", Text: "hi"}, {Type: "usage", Usage: &Usage{OutputTokens: 2}}, {Type: "done"}},
+	}}
+	var retries []RetryInfo
+	params := ChatParams{
+		Retry:   RetryPolicy{InitialDelay: 20 * time.Millisecond, MaxDelay: 20 * time.Millisecond},
+		OnRetry: func(info RetryInfo) { retries = append(retries, info) },
+	}
+	start := time.Now()
+	stream, err := NewStallRetryClient(inner).Chat(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ConsumeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "hi" || result.Usage == nil || result.Usage.InputTokens != 100 || result.Usage.OutputTokens != 2 {
+		t.Errorf("result = %+v, usage = %+v; want the stalled attempt's usage dropped", result, result.Usage)
+	}
+	if len(retries) != 1 || retries[0].Delay < 10*time.Millisecond || retries[0].Delay > 20*time.Millisecond {
+		t.Errorf("retries = %+v, want one with the backoff delay", retries)
+	}
+	if elapsed := time.Since(start); elapsed < retries[0].Delay {
+		t.Errorf("retried after %s, want a wait of %s", elapsed, retries[0].Delay)
+	}
+
+	// Cancelling during the backoff ends the stream with the context's error
+	inner = &scriptedClient{attempts: [][]StreamEvent{{usage, stall}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	params = ChatParams{Retry: RetryPolicy{InitialDelay: time.Minute}, OnRetry: func(RetryInfo) { cancel() }}
+	stream, err = NewStallRetryClient(inner).Chat(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []StreamEvent
+	for ev := range stream {
+		events = append(events, ev)
+	}
+	if inner.calls != 1 {
+		t.Errorf("calls = %d, want no retry after cancel", inner.calls)
+	}
+	for _, ev := range events {
+		if ev.Type == "usage" {
+			t.Errorf("stalled attempt's usage was passed on: %+v", events)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ResponseSchema asks the model for a final answer that is JSON matching Schema (structured output).
+// Clients map it to their native mechanism: OpenAI response_format, Anthropic forced tool use,
+// Gemini responseSchema, Ollama format, Responses API text.format.
+type ResponseSchema struct {
+	Name   string          // schema name, [a-zA-Z0-9_-]; empty uses "response"
+	Schema json.RawMessage // JSON Schema of the answer
+	Strict bool            // OpenAI strict mode (every property required, no additional properties)
+}
+
+// SchemaName returns the schema name, defaulting to "response".
+func (s *ResponseSchema) SchemaName() string {
+	if s.Name == "" {
+		return "response"
+	}
+	return s.Name
+}
+
+// IsObject reports whether the schema's top-level type is object (or unspecified with properties).
+func (s *ResponseSchema) IsObject() bool {
+	var top struct {
+		Type       any            `json:"type"`
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(s.Schema, &top); err != nil {
+		return false
+	}
+	if top.Type == nil {
+		return top.Properties != nil
+	}
+	return top.Type == "object"
+}
+
+// Parse extracts the JSON value from a model answer (tolerating ```json fences and surrounding prose)
+// and validates it against the schema. It returns the compact JSON on success.
+func (s *ResponseSchema) Parse(text string) (json.RawMessage, error) {
+	raw := extractJSON(text)
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, fmt.Errorf("answer is not valid JSON: %w", err)
+	}
+	if err := ValidateJSONSchema(s.Schema, value); err != nil {
+		return nil, err
+	}
+	out, _ := json.Marshal(value)
+	return out, nil
+}
+
+// extractJSON strips Markdown code fences and, if needed, prose around the outermost JSON object or array.
+func extractJSON(text string) string {
+	t := strings.TrimSpace(text)
+	if strings.HasPrefix(t, "```") {
+		t = strings.TrimPrefix(t, "```")
+		if nl := strings.IndexByte(t, '\n'); nl >= 0 {
+			t = t[nl+1:] // drop the language tag line
+		}
+		t = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(t), "```"))
+	}
+	if json.Valid([]byte(t)) {
+		return t
+	}
+	start := strings.IndexAny(t, "{[")
+	end := strings.LastIndexAny(t, "}]")
+	if start >= 0 && end > start {
+		return t[start : end+1]
+	}
+	return t
+}
+
+// ValidateJSONSchema checks value (decoded with encoding/json) against a JSON Schema. It supports the
+// subset used for structured output: type, enum, const, properties, required, additionalProperties,
+// items, min/maxItems, min/maxLength, pattern, minimum/maximum (and exclusive variants), anyOf, oneOf,
+// allOf and local $ref into $defs/definitions. Unknown keywords are ignored.
+func ValidateJSONSchema(schema json.RawMessage, value any) error {
+	var root any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	v := schemaValidator{root: root, active: make(map[string]bool)}
+	return v.validate(root, value, "$")
+}
+
+// CheckJSONSchema reports schemas ValidateJSONSchema cannot use: invalid JSON, $refs that are not
+// local or do not resolve, and $ref cycles that never descend into the value (e.g. {"$ref":"#"}).
+func CheckJSONSchema(schema json.RawMessage) error {
+	var root any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	c := schemaChecker{v: schemaValidator{root: root}, state: make(map[string]int)}
+	return c.walk(root, "#")
+}
+
+type schemaValidator struct {
+	root   any
+	active map[string]bool // $refs being resolved, keyed by ref and value path, to stop cycles
+}
+
+func (v schemaValidator) validate(schema any, value any, path string) error {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			return fmt.Errorf("%s: not allowed", path)
+		}
+		return nil
+	case map[string]any:
+		return v.validateObject(s, value, path)
+	}
+	return nil
+}
+
+func (v schemaValidator) validateObject(s map[string]any, value any, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		key := ref + " " + path
+		if v.active[key] {
+			return fmt.Errorf("%s: $ref cycle through %q", path, ref)
+		}
+		v.active[key] = true
+		defer delete(v.active, key)
+		return v.validate(target, value, path)
+	}
+
+	if t, ok := s["type"]; ok {
+		if err := checkType(t, value, path); err != nil {
+			return err
+		}
+	}
+	if enum, ok := s["enum"].([]any); ok && !containsJSON(enum, value) {
+		return fmt.Errorf("%s: value %s not in enum", path, compactJSON(value))
+	}
+	if c, ok := s["const"]; ok && !equalJSON(c, value) {
+		return fmt.Errorf("%s: value must be %s", path, compactJSON(c))
+	}
+
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := s[key].([]any)
+		if !ok {
+			continue
+		}
+		matched := 0
+		var firstErr error
+		for _, sub := range subs {
+			if err := v.validate(sub, value, path); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				if key == "allOf" {
+					return err
+				}
+				continue
+			}
+			matched++
+		}
+		switch {
+		case key == "anyOf" && matched == 0:
+			return fmt.Errorf("%s: matches none of anyOf (%v)", path, firstErr)
+		case key == "oneOf" && matched != 1:
+			return fmt.Errorf("%s: must match exactly one of oneOf, matched %d", path, matched)
+		}
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		return v.validateProperties(s, val, path)
+	case []any:
+		if n, ok := schemaNumber(s, "minItems"); ok && float64(len(val)) < n {
+			return fmt.Errorf("%s: expected at least %v items, got %d", path, n, len(val))
+		}
+		if n, ok := schemaNumber(s, "maxItems"); ok && float64(len(val)) > n {
+			return fmt.Errorf("%s: expected at most %v items, got %d", path, n, len(val))
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range val {
+				if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(val)))
+		if n, ok := schemaNumber(s, "minLength"); ok && length < n {
+			return fmt.Errorf("%s: shorter than %v characters", path, n)
+		}
+		if n, ok := schemaNumber(s, "maxLength"); ok && length > n {
+			return fmt.Errorf("%s: longer than %v characters", path, n)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err == nil && !re.MatchString(val) {
+				return fmt.Errorf("%s: does not match pattern %q", path, p)
+			}
+		}
+	case float64:
+		if n, ok := schemaNumber(s, "minimum"); ok && val < n {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, val, n)
+		}
+		if n, ok := schemaNumber(s, "maximum"); ok && val > n {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, val, n)
+		}
+		if n, ok := schemaNumber(s, "exclusiveMinimum"); ok && val <= n {
+			return fmt.Errorf("%s: %v must be greater than %v", path, val, n)
+		}
+		if n, ok := schemaNumber(s, "exclusiveMaximum"); ok && val >= n {
+			return fmt.Errorf("%s: %v must be less than %v", path, val, n)
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) validateProperties(s map[string]any, obj map[string]any, path string) error {
+	if req, ok := s["required"].([]any); ok {
+		for _, r := range req {
+			name, _ := r.(string)
+			if _, present := obj[name]; !present {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]any)
+	// Iterate in a stable order so the first reported error is deterministic.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := path + "." + k
+		if ps, ok := props[k]; ok {
+			if err := v.validate(ps, obj[k], child); err != nil {
+				return err
+			}
+			continue
+		}
+		if ap, ok := s["additionalProperties"]; ok {
+			if b, isBool := ap.(bool); isBool && !b {
+				return fmt.Errorf("%s: unexpected property %q", path, k)
+			}
+			if err := v.validate(ap, obj[k], child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) resolveRef(ref string) (any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q (only local refs)", ref)
+	}
+	var cur any = v.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return cur, nil
+}
+
+// schemaChecker walks a schema for CheckJSONSchema. Subschemas are identified by their JSON pointer.
+type schemaChecker struct {
+	v     schemaValidator
+	state map[string]int // 1: on the chain of subschemas applied to the same value, 2: checked
+}
+
+// walk checks every subschema of node: its $ref resolves and no chain of $ref, allOf, anyOf and
+// oneOf (which apply to the same value) leads back to it.
+func (c *schemaChecker) walk(node any, ptr string) error {
+	s, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if err := c.sameValue(s, ptr); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch sub := s[key].(type) {
+		case map[string]any:
+			switch key {
+			case "properties", "$defs", "definitions":
+				names := make([]string, 0, len(sub))
+				for name := range sub {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					if err := c.walk(sub[name], ptr+"/"+key+"/"+escapePointer(name)); err != nil {
+						return err
+					}
+				}
+			case "items", "additionalProperties":
+				if err := c.walk(sub, ptr+"/"+key); err != nil {
+					return err
+				}
+			}
+		case []any:
+			if key == "allOf" || key == "anyOf" || key == "oneOf" {
+				for i, item := range sub {
+					if err := c.walk(item, fmt.Sprintf("%s/%s/%d", ptr, key, i)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sameValue follows the subschemas applied to the same value as s and fails on a loop.
+func (c *schemaChecker) sameValue(s map[string]any, ptr string) error {
+	switch c.state[ptr] {
+	case 1:
+		return fmt.Errorf("invalid schema: $ref cycle at %q", ptr)
+	case 2:
+		return nil
+	}
+	c.state[ptr] = 1
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := c.v.resolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("invalid schema: %w", err)
+		}
+		if t, ok := target.(map[string]any); ok {
+			if err := c.sameValue(t, ref); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+			subs, _ := s[key].([]any)
+			for i, sub := range subs {
+				if m, ok := sub.(map[string]any); ok {
+					if err := c.sameValue(m, fmt.Sprintf("%s/%s/%d", ptr, key, i)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	c.state[ptr] = 2
+	return nil
+}
+
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func checkType(t any, value any, path string) error {
+	var types []string
+	switch tt := t.(type) {
+	case string:
+		types = []string{tt}
+	case []any:
+		for _, x := range tt {
+			if s, ok := x.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	for _, typ := range types {
+		if jsonTypeMatches(typ, value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+}
+
+func jsonTypeMatches(typ string, value any) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaNumber(s map[string]any, key string) (float64, bool) {
+	n, ok := s[key].(float64)
+	return n, ok
+}
+
+func containsJSON(list []any, value any) bool {
+	for _, x := range list {
+		if equalJSON(x, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalJSON(a, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	const person = `{
+		"type": "object",
+		"required": ["name", "role"],
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"role": {"enum": ["admin", "user"]},
+			"grid": {"type": "array", "items": {"type": "array", "items": {"type": "integer"}, "maxItems": 2}},
+			"boss": {"$ref": "#"},
+			"tags": {"$ref": "#/$defs/tags"}
+		},
+		"additionalProperties": false,
+		"$defs": {"tags": {"type": "array", "items": {"type": "string"}}}
+	}`
+	cases := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string // substring; "" means valid
+	}{
+		{"valid", person, `{"name":"ann","role":"admin","grid":[[1,2],[3]],"tags":["a"]}`, ""},
+		{"missing required", person, `{"name":"ann"}`, `$: missing required property "role"`},
+		{"not in enum", person, `{"name":"ann","role":"root"}`, `$.role: value "root" not in enum`},
+		{"additional property", person, `{"name":"ann","role":"user","age":3}`, `unexpected property "age"`},
+		{"nested array item", person, `{"name":"ann","role":"user","grid":[[1],[2,2.5]]}`, `$.grid[1][1]: expected integer, got number`},
+		{"nested array length", person, `{"name":"ann","role":"user","grid":[[1,2,3]]}`, `$.grid[0]: expected at most 2 items`},
+		{"recursive $ref", person, `{"name":"ann","role":"user","boss":{"name":"bob","role":"admin","boss":{"name":""}}}`, `$.boss.boss: missing required property "role"`},
+		{"$defs $ref", person, `{"name":"ann","role":"user","tags":["a",1]}`, `$.tags[1]: expected string, got number`},
+		{"unresolvable $ref", `{"$ref":"#/$defs/nope"}`, `1`, `unresolvable $ref "#/$defs/nope"`},
+		{"remote $ref", `{"$ref":"https://example.com/s.json"}`, `1`, `only local refs`},
+		{"self cycle", `{"$ref":"#"}`, `{}`, `$ref cycle`},
+		{"cycle through defs", `{"$ref":"#/$defs/a","$defs":{"a":{"$ref":"#/$defs/b"},"b":{"anyOf":[{"$ref":"#/$defs/a"}]}}}`, `"x"`, `$ref cycle`},
+	}
+	for _, c := range cases {
+		var value any
+		if err := json.Unmarshal([]byte(c.value), &value); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		err := ValidateJSONSchema(json.RawMessage(c.schema), value)
+		switch {
+		case c.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+			t.Errorf("%s: error = %v, want %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestCheckJSONSchema(t *testing.T) {
+	cases := []struct {
+		schema  string
+		wantErr string
+	}{
+		{`{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#"}}}}`, ""},
+		{`{"anyOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}],"$defs":{"a":{"type":"string"}}}`, ""},
+		{`{"$ref":"#"}`, "$ref cycle"},
+		{`{"allOf":[{"$ref":"#"}]}`, "$ref cycle"},
+		{`{"properties":{"a":{"$ref":"#/$defs/b"}},"$defs":{"b":{"$ref":"#/$defs/c"},"c":{"$ref":"#/$defs/b"}}}`, "$ref cycle"},
+		{`{"properties":{"a":{"$ref":"#/definitions/missing"}}}`, "unresolvable $ref"},
+		{`{"type":`, "invalid schema"},
+	}
+	for _, c := range cases {
+		err := CheckJSONSchema(json.RawMessage(c.schema))
+		switch {
+		case c.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", c.schema, err)
+		case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+			t.Errorf("%s: error = %v, want %q", c.schema, err, c.wantErr)
+		}
+	}
+}
@@ -10,11 +10,13 @@ import (
 type SSEEvent struct {
 	Event string
 	Data  string
+	Err   error // set on the last event when reading the stream failed (e.g. ErrStreamStalled)
 }
 
 // ParseSSE reads an SSE stream and yields events.
 // It handles the standard SSE format: lines prefixed with "data: ".
-// Closes the returned channel when the stream ends or [DONE] is received.
+// Closes the returned channel when the stream ends or [DONE] is received; a read error is sent
+// as a final event with Err set.
 func ParseSSE(reader io.Reader) <-chan SSEEvent {
 	ch := make(chan SSEEvent, 16)
 	go func() {
@@ -58,6 +60,11 @@ func ParseSSE(reader io.Reader) <-chan SSEEvent {
 			// Ignore other lines (comments starting with :, etc.)
 		}
 
+		if err := scanner.Err(); err != nil {
+			ch <- SSEEvent{Err: err}
+			return
+		}
+
 		// Flush any remaining data
 		if len(dataLines) > 0 {
 			data := strings.Join(dataLines, "\n")
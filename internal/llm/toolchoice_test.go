@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToolChoice(t *testing.T) {
+	no := false
+	tools := []ToolDef{{Name: "search", Parameters: json.RawMessage(`{"type":"object"}`)}}
+	schema := &ResponseSchema{Schema: json.RawMessage(`{"type":"object"}`)}
+	cases := []struct {
+		name      string
+		params    ChatParams
+		openai    string // tool_choice, parallel_tool_calls
+		anthropic string // tool_choice
+	}{
+		{"default", ChatParams{Tools: tools}, `null null`, `null`},
+		{"none", ChatParams{Tools: tools, ToolChoice: ToolChoiceNone}, `"none" null`, `{"type":"none"}`},
+		{"required, one at a time", ChatParams{Tools: tools, ToolChoice: ToolChoiceRequired, ParallelToolCalls: &no},
+			`"required" false`, `{"disable_parallel_tool_use":true,"type":"any"}`},
+		{"specific tool", ChatParams{Tools: tools, ToolChoice: "search"},
+			`{"function":{"name":"search"},"type":"function"} null`, `{"name":"search","type":"tool"}`},
+		{"sequential only", ChatParams{Tools: tools, ParallelToolCalls: &no}, `null false`, `{"disable_parallel_tool_use":true,"type":"auto"}`},
+		{"thinking degrades forced choice", ChatParams{Tools: tools, ToolChoice: "search", ThinkingBudget: 2048},
+			`{"function":{"name":"search"},"type":"function"} null`, `{"type":"auto"}`},
+		{"no tools", ChatParams{ToolChoice: ToolChoiceRequired}, `null null`, `null`},
+		{"structured output", ChatParams{Tools: tools, ResponseSchema: schema}, `null null`, `{"type":"any"}`},
+		{"structured output, none", ChatParams{Tools: tools, ResponseSchema: schema, ToolChoice: ToolChoiceNone},
+			`"none" null`, `{"name":"structured_output","type":"tool"}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			openai := (&OpenAIClient{}).buildRequest(tc.params)
+			if got := jsonOf(openai["tool_choice"]) + " " + jsonOf(openai["parallel_tool_calls"]); got != tc.openai {
+				t.Errorf("openai = %s, want %s", got, tc.openai)
+			}
+			anthropic := (&AnthropicClient{}).buildRequest(tc.params)
+			if got := jsonOf(anthropic["tool_choice"]); got != tc.anthropic {
+				t.Errorf("anthropic = %s, want %s", got, tc.anthropic)
+			}
+		})
+	}
+}
+
+func jsonOf(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
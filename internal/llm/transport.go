@@ -0,0 +1,237 @@
+package llm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultConnectTimeout    = 30 * time.Second // dialing and the TLS handshake, when TransportOptions.ConnectTimeout is 0
+	DefaultStreamIdleTimeout = 2 * time.Minute  // longest silence in a response body, when TransportOptions.StreamIdleTimeout is 0
+)
+
+// ErrStreamStalled is wrapped by the read error of a response body that received no bytes for the
+// provider's stream idle timeout; the connection is closed.
+var ErrStreamStalled = errors.New("stream stalled")
+
+// StreamStalledError reports a stalled response body.
+type StreamStalledError struct {
+	Idle time.Duration
+}
+
+func (e *StreamStalledError) Error() string {
+	return fmt.Sprintf("%s: no data for %s", ErrStreamStalled, e.Idle)
+}
+
+func (e *StreamStalledError) Unwrap() error { return ErrStreamStalled }
+
+// TransportOptions configure the HTTP client of one provider.
+type TransportOptions struct {
+	Headers            map[string]string // added to every request; they override the client's own headers
+	Proxy              string            // proxy URL (http, https or socks5); "" uses HTTP(S)_PROXY from the environment, "direct" none
+	ConnectTimeout     time.Duration     // dial + TLS handshake; 0 uses DefaultConnectTimeout
+	RequestTimeout     time.Duration     // whole request including the streamed reply; 0 means no limit
+	StreamIdleTimeout  time.Duration     // abort a response that sends no bytes for this long; 0 uses DefaultStreamIdleTimeout, negative disables
+	CAFile             string            // PEM bundle trusted in addition to the system roots
+	InsecureSkipVerify bool              // skip TLS certificate verification (testing only)
+}
+
+// NewHTTPClient builds an http.Client with its own connection pool for the given options.
+func NewHTTPClient(opts TransportOptions) (*http.Client, error) {
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	switch opts.Proxy {
+	case "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case "direct":
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", opts.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.CAFile != "" || opts.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+		if opts.CAFile != "" {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("read caFile: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("caFile %s: no PEM certificates found", opts.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	var rt http.RoundTripper = transport
+	if len(opts.Headers) > 0 {
+		rt = &headerTransport{base: rt, headers: maps.Clone(opts.Headers)}
+	}
+	idle := opts.StreamIdleTimeout
+	if idle == 0 {
+		idle = DefaultStreamIdleTimeout
+	}
+	if idle > 0 {
+		rt = &idleTimeoutTransport{base: rt, timeout: idle}
+	}
+	return &http.Client{Transport: rt, Timeout: opts.RequestTimeout}, nil
+}
+
+// headerTransport sets fixed headers on every request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *headerTransport) CloseIdleConnections() { closeIdleConnections(t.base) }
+
+// idleTimeoutTransport puts a watchdog on every response body (see idleTimeoutBody).
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, t.timeout)
+	return resp, nil
+}
+
+func (t *idleTimeoutTransport) CloseIdleConnections() { closeIdleConnections(t.base) }
+
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// idleTimeoutBody closes the underlying body when no bytes arrive for timeout, so a read blocked on a
+// stalled stream returns a *StreamStalledError instead of hanging. The timer starts with the response headers.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.stalled.Store(true)
+		body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.stalled.Load() {
+		return n, &StreamStalledError{Idle: b.timeout}
+	}
+	if err != nil {
+		b.timer.Stop()
+	} else if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
+
+// NewClient creates the client for an HTTP provider type ("openai", "openai-responses", "azure-openai",
+// "anthropic", "gemini" or "ollama"; anything else is OpenAI-compatible) on the given http.Client.
+func NewClient(clientType string, httpClient *http.Client) Client {
+	switch clientType {
+	case "anthropic":
+		return &AnthropicClient{HTTPClient: httpClient}
+	case "gemini":
+		return &GeminiClient{HTTPClient: httpClient}
+	case "ollama":
+		return &OllamaClient{HTTPClient: httpClient}
+	case "azure-openai":
+		return &AzureOpenAIClient{HTTPClient: httpClient}
+	case "openai-responses":
+		return &ResponsesClient{HTTPClient: httpClient, chains: make(map[string]string)}
+	}
+	return &OpenAIClient{HTTPClient: httpClient}
+}
+
+// ClientPool keeps one Client per provider, each on its own http.Client so connections are reused
+// across calls. A provider's client is rebuilt when its type or transport options change (config reload).
+type ClientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+}
+
+type pooledClient struct {
+	clientType string
+	opts       TransportOptions
+	http       *http.Client
+	client     Client
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{clients: make(map[string]*pooledClient)}
+}
+
+// Get returns the provider's client, building it on first use or when clientType or opts differ from
+// the ones it was built with.
+func (p *ClientPool) Get(provider, clientType string, opts TransportOptions) (Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c := p.clients[provider]; c != nil {
+		if c.clientType == clientType && reflect.DeepEqual(c.opts, opts) {
+			return c.client, nil
+		}
+		c.http.CloseIdleConnections()
+		delete(p.clients, provider)
+	}
+	httpClient, err := NewHTTPClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", provider, err)
+	}
+	c := &pooledClient{clientType: clientType, opts: opts, http: httpClient, client: NewClient(clientType, httpClient)}
+	p.clients[provider] = c
+	return c.client, nil
+}
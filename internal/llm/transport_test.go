@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Got-Org", r.Header.Get("X-Org"))
+	}))
+	defer srv.Close()
+
+	// The test server's certificate is not trusted without the CA file
+	if resp, err := mustHTTPClient(t, TransportOptions{Proxy: "direct"}).Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("untrusted certificate accepted")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := mustHTTPClient(t, TransportOptions{Proxy: "direct", CAFile: caFile, Headers: map[string]string{"X-Org": "acme"}})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Got-Org"); got != "acme" {
+		t.Errorf("X-Org = %q", got)
+	}
+
+	// Plain HTTP requests go through the proxy with the absolute URL
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	resp, err = mustHTTPClient(t, TransportOptions{Proxy: proxy.URL}).Get("http://llm.internal/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://llm.internal/v1/models" {
+		t.Errorf("proxied = %q", proxied)
+	}
+
+	if _, err := NewHTTPClient(TransportOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("missing caFile accepted")
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	pool := NewClientPool()
+	opts := TransportOptions{Headers: map[string]string{"X-Org": "acme"}}
+	a, _ := pool.Get("corp", "openai", opts)
+	b, _ := pool.Get("corp", "openai", TransportOptions{Headers: map[string]string{"X-Org": "acme"}})
+	if a != b {
+		t.Error("client not reused")
+	}
+	if c, _ := pool.Get("corp", "openai", TransportOptions{Headers: map[string]string{"X-Org": "other"}}); c == a {
+		t.Error("client not rebuilt after the options changed")
+	}
+	if c, _ := pool.Get("corp", "anthropic", opts); c == a {
+		t.Error("client not rebuilt after the type changed")
+	} else if _, ok := c.(*AnthropicClient); !ok {
+		t.Errorf("client = %T", c)
+	}
+	if _, err := pool.Get("bad", "openai", TransportOptions{Proxy: "://"}); err == nil {
+		t.Error("invalid proxy accepted")
+	}
+}
+
+func TestStreamStall(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		n := calls.Add(1)
+		if n == 3 {
+			// The third request streams some text before stalling
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"partial\"}}]}\n\n")
+		}
+		if n == 1 || n == 3 {
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hello\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client := NewStallRetryClient(NewClient("openai", mustHTTPClient(t, TransportOptions{Proxy: "direct", StreamIdleTimeout: 100 * time.Millisecond})))
+	var retries []RetryInfo
+	params := ChatParams{BaseURL: srv.URL, Model: "m", Retry: RetryPolicy{InitialDelay: time.Millisecond}, OnRetry: func(info RetryInfo) { retries = append(retries, info) }}
+	chat := func() (*StreamResult, error) {
+		stream, err := client.Chat(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ConsumeStream(context.Background(), stream)
+	}
+
+	// A stall before any content is retried transparently
+	result, err := chat()
+	if err != nil || result.Text != "hello" {
+		t.Fatalf("result = %+v, err = %v", result, err)
+	}
+	if len(retries) != 1 || !errors.Is(retries[0].Err, ErrStreamStalled) {
+		t.Errorf("retries = %+v", retries)
+	}
+
+	// Once text was streamed the stall is returned
+	_, err = chat()
+	var stalled *StreamStalledError
+	if !errors.As(err, &stalled) || stalled.Idle != 100*time.Millisecond {
+		t.Errorf("err = %v", err)
+	}
+	if calls.Load() != 3 || len(retries) != 1 {
+		t.Errorf("calls = %d, retries = %d", calls.Load(), len(retries))
+	}
+}
+
+func mustHTTPClient(t *testing.T, opts TransportOptions) *http.Client {
+	t.Helper()
+	client, err := NewHTTPClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
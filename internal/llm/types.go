@@ -17,6 +17,28 @@ type Message struct {
 	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
 	ToolCallID string      `json:"tool_call_id,omitempty"`
 	Images     []ImageData `json:"images,omitempty"`
+
+	// Documents are files attached to a user message: PDFs go to the provider as document blocks,
+	// decoded text files are inlined into the message text (see userText).
+	Documents []DocumentData `json:"documents,omitempty"`
+
+	// Thinking holds the assistant's reasoning blocks (Anthropic extended thinking).
+	// They are signed by the provider and must be replayed verbatim on the following tool-use turn.
+	Thinking []ThinkingBlock `json:"thinking,omitempty"`
+
+	// Reasoning is the plain reasoning text OpenAI-compatible reasoners stream as reasoning_content /
+	// reasoning (DeepSeek, Qwen, OpenRouter). It is kept for display only and never sent back:
+	// DeepSeek rejects requests that include it.
+	Reasoning string `json:"reasoning,omitempty"`
+}
+
+// ThinkingBlock is one reasoning block of an assistant message.
+type ThinkingBlock struct {
+	Type      string `json:"type"`                // "thinking" | "redacted_thinking" | "reasoning" (OpenAI Responses API)
+	ID        string `json:"id,omitempty"`        // provider item ID (reasoning)
+	Thinking  string `json:"thinking,omitempty"`  // reasoning text (thinking); reasoning summary (reasoning)
+	Signature string `json:"signature,omitempty"` // provider signature over the block (thinking)
+	Data      string `json:"data,omitempty"`      // encrypted payload (redacted_thinking, reasoning)
 }
 
 type ImageData struct {
@@ -25,11 +47,28 @@ type ImageData struct {
 	MIME   string `json:"mime,omitempty"`
 }
 
+// DocumentData is a file attached to a user message. Binary documents (PDF) carry URL or Base64;
+// text documents carry their decoded content in Text.
+type DocumentData struct {
+	Name      string `json:"name,omitempty"` // file name shown to the model
+	MIME      string `json:"mime,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Base64    string `json:"base64,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // Text was cut at MaxDocumentTextBytes
+}
+
+// IsPDF reports whether the document is a PDF.
+func (d DocumentData) IsPDF() bool { return d.MIME == "application/pdf" }
+
 // ToolCall represents an LLM's request to call a tool.
 type ToolCall struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
 	Arguments string `json:"arguments"` // raw JSON string
+
+	// Signature is an opaque provider token that must be sent back with the call (Gemini thought signature).
+	Signature string `json:"signature,omitempty"`
 }
 
 // ToolDef defines a tool for the LLM.
@@ -41,12 +80,20 @@ type ToolDef struct {
 
 // StreamEvent represents a single event in a streaming LLM response.
 type StreamEvent struct {
-	Type string // "text_delta" | "tool_call_delta" | "usage" | "done" | "error"
+	Type string // "text_delta" | "thinking_delta" | "reasoning_delta" | "tool_call_delta" | "usage" | "done" | "error"
 
-	// For text_delta
+	// For text_delta; for thinking_delta and reasoning_delta, a fragment of reasoning text
 	Text string
 
-	// For tool_call_delta
+	// For thinking_delta: which thinking block of the message this belongs to. The first event of a
+	// block carries ThinkingType; later events carry Text and/or Signature fragments.
+	ThinkingIndex int
+	ThinkingType  string // "thinking" | "redacted_thinking" | "reasoning"
+	Signature     string // signature fragment
+	RedactedData  string // redacted_thinking / reasoning encrypted payload
+	ItemID        string // provider item ID of the block (reasoning)
+
+	// For tool_call_delta (Signature may also be set, see ToolCall.Signature)
 	ToolCallIndex int
 	ToolCallID    string
 	ToolCallName  string
@@ -60,18 +107,31 @@ type StreamEvent struct {
 }
 
 // Usage tracks token consumption.
+// InputTokens counts uncached prompt tokens only (Anthropic semantics); clients for providers that
+// report cached tokens inside the prompt total subtract them, so the three input fields never overlap.
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	CacheCreationTokens int `json:"cache_creation_input_tokens,omitempty"` // prompt tokens written to the cache
+	CacheReadTokens     int `json:"cache_read_input_tokens,omitempty"`     // prompt tokens served from the cache
+}
+
+// TotalInputTokens returns all prompt tokens: uncached + cache writes + cache reads.
+func (u Usage) TotalInputTokens() int {
+	return u.InputTokens + u.CacheCreationTokens + u.CacheReadTokens
 }
 
 // StreamResult is the accumulated result after consuming a full stream.
 type StreamResult struct {
-	Message   Message   // the complete assistant message
-	ToolCalls []ToolCall // parsed tool calls (if any)
-	Text      string     // final text content
-	Usage     *Usage
+	Message    Message    // the complete assistant message
+	ToolCalls  []ToolCall // parsed tool calls (if any)
+	Text       string     // final text content
+	Thinking   []ThinkingBlock
+	Reasoning  string // plain reasoning text (reasoning_delta)
+	Usage      *Usage
 	StopReason string
+	Provider   string // provider that produced this result (set by the caller when falling back)
+	Model      string // model that produced this result
 }
 
 // Helper constructors
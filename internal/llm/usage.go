@@ -1,35 +1,151 @@
 package llm
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-// UsageRecord tracks a single LLM call's token usage.
+// Kinds of LLM calls in the usage log.
+const (
+	UsageKindChat       = "chat"       // agent turns
+	UsageKindCompaction = "compaction" // context summarisation
+)
+
+// UsageRecord tracks a single LLM call's token usage; one line of usage.jsonl.
 type UsageRecord struct {
-	Timestamp    time.Time `json:"timestamp"`
-	Provider     string    `json:"provider"`
-	Model        string    `json:"model"`
-	InputTokens  int       `json:"inputTokens"`
-	OutputTokens int       `json:"outputTokens"`
-	SessionKey   string    `json:"sessionKey,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	Agent            string    `json:"agent,omitempty"`
+	Channel          string    `json:"channel,omitempty"`
+	SessionKey       string    `json:"sessionKey,omitempty"`
+	RunID            string    `json:"runId,omitempty"`
+	Kind             string    `json:"kind,omitempty"` // UsageKindChat | UsageKindCompaction
+	InputTokens      int       `json:"inputTokens"`    // uncached prompt tokens, see Usage
+	OutputTokens     int       `json:"outputTokens"`
+	CacheReadTokens  int       `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int       `json:"cacheWriteTokens,omitempty"`
+	EstCostUSD       float64   `json:"estCostUSD"` // priced when recorded, so later price changes keep history intact
+}
+
+// ModelPrice is a model's price in USD per million tokens. Zero cache prices bill cache tokens as input.
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cacheRead,omitempty"`
+	CacheWrite float64 `json:"cacheWrite,omitempty"`
+}
+
+// Pricing maps "provider/model" or a model name to its price. Model names also match longer names
+// they prefix ("claude-sonnet-4" matches "claude-sonnet-4-5-20250929"); the longest match wins.
+type Pricing map[string]ModelPrice
+
+// DefaultPricing holds list prices of common models (standard tier, late 2025); config pricing overrides it.
+var DefaultPricing = Pricing{
+	"claude-opus-4-5":       {Input: 5, Output: 25, CacheRead: 0.5, CacheWrite: 6.25},
+	"claude-opus-4":         {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4":       {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-7-sonnet":     {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-haiku-4-5":      {Input: 1, Output: 5, CacheRead: 0.1, CacheWrite: 1.25},
+	"claude-3-5-haiku":      {Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"gpt-5":                 {Input: 1.25, Output: 10, CacheRead: 0.125},
+	"gpt-5-mini":            {Input: 0.25, Output: 2, CacheRead: 0.025},
+	"gpt-5-nano":            {Input: 0.05, Output: 0.4, CacheRead: 0.005},
+	"gpt-4.1":               {Input: 2, Output: 8, CacheRead: 0.5},
+	"gpt-4.1-mini":          {Input: 0.4, Output: 1.6, CacheRead: 0.1},
+	"gpt-4.1-nano":          {Input: 0.1, Output: 0.4, CacheRead: 0.025},
+	"gpt-4o":                {Input: 2.5, Output: 10, CacheRead: 1.25},
+	"gpt-4o-mini":           {Input: 0.15, Output: 0.6, CacheRead: 0.075},
+	"o3":                    {Input: 2, Output: 8, CacheRead: 0.5},
+	"o4-mini":               {Input: 1.1, Output: 4.4, CacheRead: 0.275},
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10, CacheRead: 0.125},
+	"gemini-2.5-flash":      {Input: 0.3, Output: 2.5, CacheRead: 0.03},
+	"gemini-2.5-flash-lite": {Input: 0.1, Output: 0.4, CacheRead: 0.01},
+	"deepseek-chat":         {Input: 0.28, Output: 0.42, CacheRead: 0.028},
+	"deepseek-reasoner":     {Input: 0.28, Output: 0.42, CacheRead: 0.028},
+}
+
+// Lookup returns the price of a model: an exact "provider/model" key first, then the model name, then the
+// longest key that prefixes it. Router model names such as "anthropic/claude-sonnet-4" match by their last segment.
+func (p Pricing) Lookup(provider, model string) (ModelPrice, bool) {
+	return lookupModel(p, provider, model)
 }
 
-// UsageTracker records and aggregates token usage.
+// lookupModel finds a model's entry in a table keyed like Pricing.
+func lookupModel[V any](table map[string]V, provider, model string) (V, bool) {
+	if v, ok := table[provider+"/"+model]; ok {
+		return v, true
+	}
+	if v, ok := table[model]; ok {
+		return v, true
+	}
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	best, found := "", false
+	for key := range table {
+		if strings.HasPrefix(name, strings.ToLower(key)) && len(key) > len(best) {
+			best, found = key, true
+		}
+	}
+	return table[best], found
+}
+
+// Tokens returns all tokens of the call: prompt (cached or not) and output.
+func (rec UsageRecord) Tokens() int {
+	return rec.InputTokens + rec.CacheReadTokens + rec.CacheWriteTokens + rec.OutputTokens
+}
+
+// Cost returns the price of a call's tokens in USD.
+func (m ModelPrice) Cost(rec UsageRecord) float64 {
+	cacheRead, cacheWrite := m.CacheRead, m.CacheWrite
+	if cacheRead == 0 {
+		cacheRead = m.Input
+	}
+	if cacheWrite == 0 {
+		cacheWrite = m.Input
+	}
+	return (float64(rec.InputTokens)*m.Input + float64(rec.OutputTokens)*m.Output +
+		float64(rec.CacheReadTokens)*cacheRead + float64(rec.CacheWriteTokens)*cacheWrite) / 1_000_000
+}
+
+// UsageTracker records every LLM call to usage.jsonl and aggregates the log.
 type UsageTracker struct {
 	mu      sync.Mutex
 	logPath string
 	totals  UsageTotals
+	pricing Pricing
+	hooks   []func(UsageRecord)
 }
 
+// UsageTotals aggregates usage records.
 type UsageTotals struct {
-	InputTokens  int     `json:"inputTokens"`
-	OutputTokens int     `json:"outputTokens"`
-	EstCostUSD   float64 `json:"estCostUSD"`
+	Calls            int     `json:"calls"`
+	InputTokens      int     `json:"inputTokens"`
+	OutputTokens     int     `json:"outputTokens"`
+	CacheReadTokens  int     `json:"cacheReadTokens"`
+	CacheWriteTokens int     `json:"cacheWriteTokens"`
+	EstCostUSD       float64 `json:"estCostUSD"`
+}
+
+func (t *UsageTotals) add(rec UsageRecord) {
+	t.Calls++
+	t.InputTokens += rec.InputTokens
+	t.OutputTokens += rec.OutputTokens
+	t.CacheReadTokens += rec.CacheReadTokens
+	t.CacheWriteTokens += rec.CacheWriteTokens
+	t.EstCostUSD += rec.EstCostUSD
 }
 
 func NewUsageTracker(dataDir string) *UsageTracker {
@@ -39,28 +155,69 @@ func NewUsageTracker(dataDir string) *UsageTracker {
 	}
 }
 
-// Record logs a usage record and updates totals.
-func (t *UsageTracker) Record(rec UsageRecord) {
+// SetPricing sets the configured prices; models not in p are priced from DefaultPricing.
+func (t *UsageTracker) SetPricing(p Pricing) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pricing = p
+}
+
+// Cost returns the estimated cost of a record's tokens; models without a known price cost 0.
+func (t *UsageTracker) Cost(rec UsageRecord) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.price(rec)
+}
+
+func (t *UsageTracker) price(rec UsageRecord) float64 {
+	price, ok := t.pricing.Lookup(rec.Provider, rec.Model)
+	if !ok {
+		price, _ = DefaultPricing.Lookup(rec.Provider, rec.Model)
+	}
+	return price.Cost(rec)
+}
+
+// OnRecord registers fn to be called with every priced record after it is logged.
+func (t *UsageTracker) OnRecord(fn func(UsageRecord)) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	t.hooks = append(t.hooks, fn)
+}
 
-	t.totals.InputTokens += rec.InputTokens
-	t.totals.OutputTokens += rec.OutputTokens
-	t.totals.EstCostUSD += estimateCost(rec.Provider, rec.Model, rec.InputTokens, rec.OutputTokens)
+// Record prices a usage record, logs it, updates totals and calls the OnRecord hooks.
+func (t *UsageTracker) Record(rec UsageRecord) {
+	t.mu.Lock()
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	rec.EstCostUSD = t.price(rec)
+	t.totals.add(rec)
+	t.write(rec)
+	hooks := t.hooks
+	t.mu.Unlock()
 
-	// Append to JSONL log
+	for _, fn := range hooks {
+		fn(rec)
+	}
+}
+
+// write appends a record to the JSONL log; t.mu must be held.
+func (t *UsageTracker) write(rec UsageRecord) {
 	f, err := os.OpenFile(t.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
+		slog.Warn("usage log write failed", "path", t.logPath, "error", err)
 		return
 	}
 	defer f.Close()
 
 	data, _ := json.Marshal(rec)
 	data = append(data, '\n')
-	f.Write(data)
+	if _, err := f.Write(data); err != nil {
+		slog.Warn("usage log write failed", "path", t.logPath, "error", err)
+	}
 }
 
-// Totals returns aggregated usage.
+// Totals returns aggregated usage since the tracker was created.
 func (t *UsageTracker) Totals() UsageTotals {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -74,34 +231,182 @@ func (t *UsageTracker) Status() string {
 		totals.InputTokens, totals.OutputTokens, totals.EstCostUSD)
 }
 
-// estimateCost gives a rough USD cost estimate.
-func estimateCost(provider, model string, input, output int) float64 {
-	// Per-1M token pricing (approximate, 2025 rates)
-	var inPer1M, outPer1M float64
-	switch {
-	case provider == "anthropic" && contains(model, "opus"):
-		inPer1M, outPer1M = 15.0, 75.0
-	case provider == "anthropic" && contains(model, "sonnet"):
-		inPer1M, outPer1M = 3.0, 15.0
-	case provider == "anthropic" && contains(model, "haiku"):
-		inPer1M, outPer1M = 0.25, 1.25
-	case provider == "openai" && contains(model, "gpt-4o"):
-		inPer1M, outPer1M = 2.5, 10.0
-	case provider == "openai" && contains(model, "gpt-4"):
-		inPer1M, outPer1M = 30.0, 60.0
-	case contains(model, "deepseek"):
-		inPer1M, outPer1M = 0.14, 0.28
-	default:
-		inPer1M, outPer1M = 1.0, 3.0 // conservative default
-	}
-	return (float64(input)/1_000_000)*inPer1M + (float64(output)/1_000_000)*outPer1M
-}
-
-func contains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
+// UsageQuery selects and groups records of the usage log.
+type UsageQuery struct {
+	From, To time.Time // [From, To); zero means unbounded
+	Agent    string    // filters; empty matches all
+	Channel  string
+	Model    string
+	GroupBy  []string // "day" (local time), "agent", "channel", "provider", "model", "kind", "session"
+}
+
+// UsageGroups are the valid UsageQuery.GroupBy values.
+var UsageGroups = []string{"day", "agent", "channel", "provider", "model", "kind", "session"}
+
+// UsageGroup is the usage of one combination of group values.
+type UsageGroup struct {
+	Key map[string]string `json:"key"`
+	UsageTotals
+}
+
+// Query aggregates the usage log. Groups are sorted by their key values; totals cover all matching records.
+func (t *UsageTracker) Query(q UsageQuery) ([]UsageGroup, UsageTotals, error) {
+	for _, g := range q.GroupBy {
+		if !slices.Contains(UsageGroups, g) {
+			return nil, UsageTotals{}, fmt.Errorf("invalid group %q (allowed: %s)", g, strings.Join(UsageGroups, ", "))
+		}
+	}
+
+	var totals UsageTotals
+	groups := make(map[string]*UsageGroup)
+	err := t.Scan(func(rec UsageRecord) {
+		if !q.matches(rec) {
+			return
+		}
+		totals.add(rec)
+		key := make(map[string]string, len(q.GroupBy))
+		parts := make([]string, len(q.GroupBy))
+		for i, g := range q.GroupBy {
+			parts[i] = usageGroupValue(rec, g)
+			key[g] = parts[i]
+		}
+		id := strings.Join(parts, "\x00")
+		group, ok := groups[id]
+		if !ok {
+			group = &UsageGroup{Key: key}
+			groups[id] = group
+		}
+		group.add(rec)
+	})
+	if err != nil {
+		return nil, totals, err
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := make([]UsageGroup, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, *groups[id])
+	}
+	return out, totals, nil
+}
+
+// Scan calls fn with every record of the usage log, oldest first.
+func (t *UsageTracker) Scan(fn func(UsageRecord)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, err := os.Open(t.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // torn or foreign line
 		}
+		fn(rec)
+	}
+	return scanner.Err()
+}
+
+func (q UsageQuery) matches(rec UsageRecord) bool {
+	switch {
+	case !q.From.IsZero() && rec.Timestamp.Before(q.From):
+		return false
+	case !q.To.IsZero() && !rec.Timestamp.Before(q.To):
+		return false
+	case q.Agent != "" && rec.Agent != q.Agent:
+		return false
+	case q.Channel != "" && rec.Channel != q.Channel:
+		return false
+	case q.Model != "" && rec.Model != q.Model && rec.Provider+"/"+rec.Model != q.Model:
+		return false
+	}
+	return true
+}
+
+// usageGroupValue returns the value of a record for one of UsageGroups.
+func usageGroupValue(rec UsageRecord, group string) string {
+	switch group {
+	case "day":
+		return rec.Timestamp.Local().Format("2006-01-02")
+	case "agent":
+		return rec.Agent
+	case "channel":
+		return rec.Channel
+	case "provider":
+		return rec.Provider
+	case "model":
+		return rec.Provider + "/" + rec.Model
+	case "kind":
+		return rec.Kind
+	case "session":
+		return rec.SessionKey
 	}
-	return false
+	return ""
+}
+
+// MeteredClient wraps a Client and records the token usage of every Chat call in a UsageTracker.
+// Record carries the attribution (agent, channel, session, kind); provider and model come from the call.
+type MeteredClient struct {
+	Client  Client
+	Tracker *UsageTracker
+	Record  UsageRecord
+}
+
+func NewMeteredClient(client Client, tracker *UsageTracker, rec UsageRecord) *MeteredClient {
+	return &MeteredClient{Client: client, Tracker: tracker, Record: rec}
+}
+
+func (m *MeteredClient) Chat(ctx context.Context, params ChatParams) (<-chan StreamEvent, error) {
+	stream, err := m.Client.Chat(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan StreamEvent, 32)
+	go func() {
+		defer close(out)
+		var usage *Usage
+		for ev := range stream {
+			if ev.Type == "usage" && ev.Usage != nil {
+				// Providers may report input and output tokens in separate events; sum them.
+				if usage == nil {
+					usage = &Usage{}
+				}
+				usage.InputTokens += ev.Usage.InputTokens
+				usage.OutputTokens += ev.Usage.OutputTokens
+				usage.CacheReadTokens += ev.Usage.CacheReadTokens
+				usage.CacheCreationTokens += ev.Usage.CacheCreationTokens
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done(): // keep draining: tokens of an aborted reply are still billed
+			}
+		}
+		if usage == nil {
+			return
+		}
+		rec := m.Record
+		rec.Timestamp = time.Now()
+		rec.Provider = params.Provider
+		rec.Model = params.Model
+		rec.InputTokens = usage.InputTokens
+		rec.OutputTokens = usage.OutputTokens
+		rec.CacheReadTokens = usage.CacheReadTokens
+		rec.CacheWriteTokens = usage.CacheCreationTokens
+		m.Tracker.Record(rec)
+	}()
+	return out, nil
 }
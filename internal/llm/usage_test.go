@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPricingLookup(t *testing.T) {
+	p := Pricing{
+		"claude-sonnet-4":          {Input: 3},
+		"claude-sonnet-4-5":        {Input: 4},
+		"bedrock/claude-sonnet-4":  {Input: 5},
+		"Qwen/Qwen3-235B-A22B":     {Input: 0.2},
+		"qwen3":                    {Input: 0.1},
+		"anthropic/claude-opus-4":  {Input: 15},
+		"claude-opus-4-1-20250805": {Input: 16},
+	}
+	tests := []struct {
+		provider, model string
+		want            float64
+		found           bool
+	}{
+		{"anthropic", "claude-sonnet-4-20250514", 3, true},
+		{"anthropic", "claude-sonnet-4-5-20250929", 4, true},   // longest prefix
+		{"bedrock", "claude-sonnet-4", 5, true},                // provider/model first
+		{"openrouter", "anthropic/claude-sonnet-4-5", 4, true}, // router name: last segment
+		{"siliconflow", "Qwen/Qwen3-235B-A22B", 0.2, true},     // exact model name with a slash
+		{"anthropic", "claude-opus-4-1-20250805", 16, true},
+		{"ollama", "llama3.2", 0, false},
+	}
+	for _, tt := range tests {
+		price, found := p.Lookup(tt.provider, tt.model)
+		if price.Input != tt.want || found != tt.found {
+			t.Errorf("Lookup(%s, %s) = %v, %v; want %v, %v", tt.provider, tt.model, price.Input, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestUsageTracker(t *testing.T) {
+	script, err := ParseMockScript([]byte(`
+loop: true
+turns:
+  - text: hi
+    usage: {input: 1000000, output: 100000, cacheRead: 2000000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := NewMockClient()
+	mock.SetScript("mock", script)
+
+	tracker := NewUsageTracker(t.TempDir())
+	tracker.SetPricing(Pricing{"m": {Input: 1, Output: 10, CacheRead: 0.1}})
+	call := func(rec UsageRecord) {
+		stream, err := NewMeteredClient(mock, tracker, rec).Chat(context.Background(), ChatParams{Provider: "mock", Model: "m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ConsumeStream(context.Background(), stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+	call(UsageRecord{Agent: "default", Channel: "telegram", Kind: UsageKindChat})
+	call(UsageRecord{Agent: "default", Channel: "telegram", Kind: UsageKindCompaction})
+	call(UsageRecord{Agent: "ops", Channel: "feishu", Kind: UsageKindChat})
+
+	if got := tracker.Totals(); got.Calls != 3 || math.Abs(got.EstCostUSD-3*2.2) > 1e-9 {
+		t.Fatalf("totals = %+v", got)
+	}
+
+	groups, totals, err := tracker.Query(UsageQuery{GroupBy: []string{"channel", "kind"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Calls != 3 || len(groups) != 3 {
+		t.Fatalf("groups = %+v, totals = %+v", groups, totals)
+	}
+	if g := groups[1]; g.Key["channel"] != "telegram" || g.Key["kind"] != UsageKindChat || g.CacheReadTokens != 2000000 {
+		t.Errorf("groups[1] = %+v", g)
+	}
+
+	groups, totals, _ = tracker.Query(UsageQuery{Agent: "default", GroupBy: []string{"day"}, From: time.Now().Add(-time.Hour)})
+	if len(groups) != 1 || totals.Calls != 2 || groups[0].Key["day"] != time.Now().Format("2006-01-02") {
+		t.Errorf("by day = %+v", groups)
+	}
+	if _, _, err := tracker.Query(UsageQuery{GroupBy: []string{"team"}}); err == nil {
+		t.Error("unknown group accepted")
+	}
+}
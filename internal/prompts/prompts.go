@@ -38,6 +38,9 @@ type Prompts struct {
 	TruncateBootstrapFmt string
 
 	SummarizePromptTemplate string
+	ContinuePrompt          string // sent after a reply was cut off at max tokens, asking the model to resume
+	StructuredOutputFmt     string // system prompt section when a response schema is set; one %s for the schema
+	StructuredRetryFmt      string // user turn after an answer failed schema validation; one %s for the error
 }
 
 // Get returns prompts for the given locale. Only "en" uses English; empty or unknown defaults to Chinese ("zh").
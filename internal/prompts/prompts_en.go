@@ -42,4 +42,9 @@ Be concise but complete.
 
 Conversation to summarize:
 %s`,
+
+	ContinuePrompt: "Your previous reply was cut off by the length limit. Continue exactly where you left off, without repeating anything already written and without any preamble.",
+
+	StructuredOutputFmt: "\n\n## Output Format\n\nYour final answer must be JSON matching the following JSON Schema, with no other text and no Markdown code fences:\n\n%s\n",
+	StructuredRetryFmt:  "Your previous answer did not match the required JSON Schema: %s. Reply again with only JSON matching the schema, and nothing else.",
 }
@@ -42,4 +42,9 @@ var PromptsZH = &Prompts{
 
 待总结的对话：
 %s`,
+
+	ContinuePrompt: "你的上一条回复因长度限制被截断。请从中断处直接继续，不要重复已输出的内容，也不要添加开场白。",
+
+	StructuredOutputFmt: "\n\n## 输出格式\n\n最终回答必须是符合以下 JSON Schema 的 JSON，不要包含任何其他文字或 Markdown 代码块：\n\n%s\n",
+	StructuredRetryFmt:  "你的上一条回答不符合要求的 JSON Schema：%s。请只输出符合 Schema 的 JSON，不要包含其他文字。",
 }
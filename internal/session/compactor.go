@@ -8,6 +8,7 @@ import (
 
 	"github.com/lhdbsbz/aido/internal/llm"
 	"github.com/lhdbsbz/aido/internal/prompts"
+	"github.com/lhdbsbz/aido/internal/tokenizer"
 )
 
 // Compactor handles session context window management via LLM summarization.
@@ -15,8 +16,11 @@ type Compactor struct {
 	KeepRecentTokens         int     // tokens to keep at the end (default: 20000)
 	ReserveTokens            int     // tokens to reserve for new content (default: 16384)
 	ChunkRatio               float64 // ratio for chunking messages to summarize (default: 0.4)
-	SafetyMargin             float64 // multiplier for token estimation inaccuracy (default: 1.2)
+	SafetyMargin             float64 // multiplier for token estimation inaccuracy until calibrated (default: 1.2)
 	SummarizePromptTemplate  string  // prompt template with one %s for conversation body; empty uses default (en)
+
+	Tokenizer   tokenizer.Tokenizer // counts tokens; nil uses the heuristic
+	Calibration float64             // actual/estimated input tokens of the last LLM call; 0 = not calibrated
 }
 
 func DefaultCompactor() *Compactor {
@@ -28,16 +32,53 @@ func DefaultCompactor() *Compactor {
 	}
 }
 
+func (c *Compactor) tok() tokenizer.Tokenizer {
+	if c.Tokenizer == nil {
+		return tokenizer.Heuristic{}
+	}
+	return c.Tokenizer
+}
+
+// EstimateRequest estimates the input tokens of a call with params' system prompt and tools and the
+// given messages, scaled by the calibration (or the safety margin before the first calibration).
+func (c *Compactor) EstimateRequest(params llm.ChatParams, messages []llm.Message) int {
+	factor := c.Calibration
+	if factor <= 0 {
+		factor = c.SafetyMargin
+	}
+	return int(float64(tokenizer.CountRequest(c.tok(), params.System, params.Tools, messages)) * factor)
+}
+
+// Calibrate records how the provider's reported input tokens compare to the estimate for the same request,
+// so later estimates track the model's real tokenizer. Outliers (e.g. images, provider-side caching quirks) are clamped.
+func (c *Compactor) Calibrate(params llm.ChatParams, usage llm.Usage) {
+	actual := usage.TotalInputTokens()
+	estimated := tokenizer.CountRequest(c.tok(), params.System, params.Tools, params.Messages)
+	if actual <= 0 || estimated <= 0 {
+		return
+	}
+	ratio := float64(actual) / float64(estimated)
+	c.Calibration = min(max(ratio, 0.5), 3.0)
+}
+
 // ShouldCompact checks if the session needs compaction.
-func (c *Compactor) ShouldCompact(messages []llm.Message, contextWindow int) bool {
-	currentTokens := int(float64(EstimateMessagesTokens(messages)) * c.SafetyMargin)
-	return currentTokens > (contextWindow - c.ReserveTokens)
+func (c *Compactor) ShouldCompact(params llm.ChatParams, messages []llm.Message, contextWindow int) bool {
+	return c.EstimateRequest(params, messages) > (contextWindow - c.ReserveTokens)
+}
+
+// messageTokens counts one message for splitting and chunking (uncalibrated; only proportions matter).
+func (c *Compactor) messageTokens(msg llm.Message) int {
+	return tokenizer.CountMessages(c.tok(), []llm.Message{msg})
 }
 
 // Compact performs LLM-based summarization of older messages.
 // Returns the new message list (summary + recent messages).
 func (c *Compactor) Compact(ctx context.Context, client llm.Client, messages []llm.Message, params llm.ChatParams) ([]llm.Message, string, error) {
-	splitIdx := c.findSplitIndex(messages)
+	return c.compact(ctx, client, messages, params, c.KeepRecentTokens)
+}
+
+func (c *Compactor) compact(ctx context.Context, client llm.Client, messages []llm.Message, params llm.ChatParams, keepTokens int) ([]llm.Message, string, error) {
+	splitIdx := c.findSplitIndex(messages, keepTokens)
 	if splitIdx <= 0 {
 		return messages, "", nil // nothing to compress
 	}
@@ -66,17 +107,13 @@ func (c *Compactor) Compact(ctx context.Context, client llm.Client, messages []l
 	return newMessages, fullSummary, nil
 }
 
-// findSplitIndex finds where to split: keep the most recent KeepRecentTokens.
-func (c *Compactor) findSplitIndex(messages []llm.Message) int {
+// findSplitIndex finds where to split: keep the most recent keepTokens, and always the last message.
+func (c *Compactor) findSplitIndex(messages []llm.Message, keepTokens int) int {
 	totalTokens := 0
 	for i := len(messages) - 1; i >= 0; i-- {
-		msgTokens := EstimateTokens(messages[i].Content) + 4
-		for _, tc := range messages[i].ToolCalls {
-			msgTokens += EstimateTokens(tc.Arguments)
-		}
-		totalTokens += msgTokens
-		if totalTokens >= c.KeepRecentTokens {
-			return i + 1
+		totalTokens += c.messageTokens(messages[i])
+		if totalTokens >= keepTokens {
+			return min(i+1, len(messages)-1)
 		}
 	}
 	return 0 // everything fits in the keep window
@@ -88,7 +125,7 @@ func (c *Compactor) chunkMessages(messages []llm.Message) [][]llm.Message {
 		return nil
 	}
 
-	totalTokens := EstimateMessagesTokens(messages)
+	totalTokens := tokenizer.CountMessages(c.tok(), messages)
 	chunkSize := int(float64(totalTokens) * c.ChunkRatio)
 	if chunkSize < 2000 {
 		chunkSize = 2000
@@ -99,7 +136,7 @@ func (c *Compactor) chunkMessages(messages []llm.Message) [][]llm.Message {
 	currentTokens := 0
 
 	for _, msg := range messages {
-		msgTokens := EstimateTokens(msg.Content) + 4
+		msgTokens := c.messageTokens(msg)
 		if currentTokens+msgTokens > chunkSize && len(currentChunk) > 0 {
 			chunks = append(chunks, currentChunk)
 			currentChunk = nil
@@ -137,6 +174,8 @@ func (c *Compactor) summarizeChunk(ctx context.Context, client llm.Client, messa
 		Model:    baseParams.Model,
 		APIKey:   baseParams.APIKey,
 		BaseURL:  baseParams.BaseURL,
+		Retry:    baseParams.Retry,
+		Azure:    baseParams.Azure,
 		Messages: []llm.Message{llm.UserMessage(prompt)},
 	})
 	if err != nil {
@@ -167,8 +206,12 @@ type Manager struct {
 	transcript *Transcript
 }
 
+// NewManager seeds an uncalibrated compactor with the calibration saved for the session.
 func NewManager(store *Store, compactor *Compactor, sessionKey, agentID string) *Manager {
 	transcriptPath := store.TranscriptPath(sessionKey)
+	if compactor.Calibration <= 0 {
+		compactor.Calibration = store.Calibration(sessionKey)
+	}
 	return &Manager{
 		Store:      store,
 		Compactor:  compactor,
@@ -188,12 +231,22 @@ func (m *Manager) Append(msg llm.Message) error {
 	return m.transcript.Append(msg)
 }
 
-func (m *Manager) ShouldCompact(contextWindow int) (bool, error) {
+// ShouldCompact reports whether the transcript, sent with params' system prompt and tools, nears the context window.
+func (m *Manager) ShouldCompact(params llm.ChatParams, contextWindow int) (bool, error) {
 	messages, err := m.transcript.Load()
 	if err != nil {
 		return false, err
 	}
-	return m.Compactor.ShouldCompact(messages, contextWindow), nil
+	return m.Compactor.ShouldCompact(params, messages, contextWindow), nil
+}
+
+// Calibrate feeds the real input token count of an LLM call (params holds the messages sent) back into estimates
+// and keeps the result on the session entry for later runs.
+func (m *Manager) Calibrate(params llm.ChatParams, usage llm.Usage) {
+	m.Compactor.Calibrate(params, usage)
+	if m.Compactor.Calibration > 0 {
+		m.Store.SetCalibration(m.sessionKey, m.Compactor.Calibration)
+	}
 }
 
 func (m *Manager) DoCompact(ctx context.Context, client llm.Client, params llm.ChatParams, contextWindow int) error {
@@ -202,17 +255,33 @@ func (m *Manager) DoCompact(ctx context.Context, client llm.Client, params llm.C
 		return err
 	}
 
-	if !m.Compactor.ShouldCompact(messages, contextWindow) {
+	if !m.Compactor.ShouldCompact(params, messages, contextWindow) {
 		return nil
 	}
+	_, err = m.compact(ctx, client, params, messages, m.Compactor.KeepRecentTokens)
+	return err
+}
 
-	newMessages, summary, err := m.Compactor.Compact(ctx, client, messages, params)
+// ForceCompact compacts regardless of the estimate, for when the provider rejected the prompt as too long.
+// It keeps at most half of the transcript so each attempt shrinks the context; it reports false if
+// there was nothing left to summarize.
+func (m *Manager) ForceCompact(ctx context.Context, client llm.Client, params llm.ChatParams) (bool, error) {
+	messages, err := m.transcript.Load()
 	if err != nil {
-		return fmt.Errorf("compact: %w", err)
+		return false, err
+	}
+	keep := min(m.Compactor.KeepRecentTokens, tokenizer.CountMessages(m.Compactor.tok(), messages)/2)
+	return m.compact(ctx, client, params, messages, keep)
+}
+
+func (m *Manager) compact(ctx context.Context, client llm.Client, params llm.ChatParams, messages []llm.Message, keepTokens int) (bool, error) {
+	newMessages, summary, err := m.Compactor.compact(ctx, client, messages, params, keepTokens)
+	if err != nil {
+		return false, fmt.Errorf("compact: %w", err)
 	}
 
 	if summary == "" {
-		return nil
+		return false, nil
 	}
 
 	// Rewrite transcript: compaction entry + remaining messages
@@ -233,7 +302,7 @@ func (m *Manager) DoCompact(ctx context.Context, client llm.Client, params llm.C
 	}
 
 	if err := m.transcript.Rewrite(entries); err != nil {
-		return fmt.Errorf("rewrite transcript: %w", err)
+		return false, fmt.Errorf("rewrite transcript: %w", err)
 	}
 
 	// Update metadata
@@ -244,5 +313,5 @@ func (m *Manager) DoCompact(ctx context.Context, client llm.Client, params llm.C
 		entry.UpdatedAt = time.Now()
 	}
 
-	return m.Store.Save()
+	return true, m.Store.Save()
 }
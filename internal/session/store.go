@@ -7,17 +7,25 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/lhdbsbz/aido/internal/llm"
 )
 
 // Entry holds metadata for a single session.
 type Entry struct {
-	SessionKey   string    `json:"sessionKey"`
-	AgentID      string    `json:"agentId"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
-	InputTokens  int       `json:"inputTokens"`
-	OutputTokens int       `json:"outputTokens"`
-	Compactions  int       `json:"compactions"`
+	SessionKey          string    `json:"sessionKey"`
+	AgentID             string    `json:"agentId"`
+	CreatedAt           time.Time `json:"createdAt"`
+	UpdatedAt           time.Time `json:"updatedAt"`
+	InputTokens         int       `json:"inputTokens"`
+	OutputTokens        int       `json:"outputTokens"`
+	CacheReadTokens     int       `json:"cacheReadTokens,omitempty"`     // input tokens served from the provider's prompt cache
+	CacheCreationTokens int       `json:"cacheCreationTokens,omitempty"` // input tokens written to the prompt cache
+	Compactions         int       `json:"compactions"`
+	LastModel           string    `json:"lastModel,omitempty"` // "provider/model" that answered the last LLM call
+	Fallbacks           int       `json:"fallbacks,omitempty"` // LLM calls answered by a fallback model
+	// Compactor's actual/estimated input token ratio, carried into the next run's estimates
+	Calibration float64 `json:"calibration,omitempty"`
 }
 
 // Store manages session metadata and provides session lookup/creation.
@@ -129,17 +137,50 @@ func (s *Store) Delete(sessionKey string) error {
 	return s.Save()
 }
 
-// UpdateUsage adds token usage to a session entry.
-func (s *Store) UpdateUsage(sessionKey string, input, output int) {
+// UpdateUsage adds token usage (including prompt cache reads/writes) to a session entry.
+func (s *Store) UpdateUsage(sessionKey string, u llm.Usage) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if entry, ok := s.sessions[sessionKey]; ok {
-		entry.InputTokens += input
-		entry.OutputTokens += output
+		entry.InputTokens += u.InputTokens
+		entry.OutputTokens += u.OutputTokens
+		entry.CacheReadTokens += u.CacheReadTokens
+		entry.CacheCreationTokens += u.CacheCreationTokens
 		entry.UpdatedAt = time.Now()
 	}
 }
 
+// RecordModel notes which model answered an LLM call and whether it was a fallback.
+func (s *Store) RecordModel(sessionKey, modelRef string, fallback bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.sessions[sessionKey]; ok {
+		entry.LastModel = modelRef
+		if fallback {
+			entry.Fallbacks++
+		}
+	}
+}
+
+// Calibration returns the token estimate calibration saved for a session; 0 if none.
+func (s *Store) Calibration(sessionKey string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if entry, ok := s.sessions[sessionKey]; ok {
+		return entry.Calibration
+	}
+	return 0
+}
+
+// SetCalibration saves the compactor's token estimate calibration for a session.
+func (s *Store) SetCalibration(sessionKey string, factor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.sessions[sessionKey]; ok {
+		entry.Calibration = factor
+	}
+}
+
 // TranscriptPath returns the file path for a session's transcript.
 func (s *Store) TranscriptPath(sessionKey string) string {
 	return filepath.Join(s.baseDir, safeFileName(sessionKey)+".jsonl")
@@ -0,0 +1,187 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pre-tokenizer patterns of OpenAI's encodings, rewritten for RE2: possessive quantifiers are dropped and
+// the `\s+(?!\S)` alternative is emulated in pieces (a run of spaces leaves its last space to the next word).
+var patterns = map[string]string{
+	Cl100kBase: `^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+)`,
+	O200kBase: `^(?:[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+)`,
+}
+
+// maxCachedPieces bounds the per-encoding cache of piece → token count.
+const maxCachedPieces = 1 << 16
+
+// BPE is a byte-level byte-pair-encoding tokenizer (tiktoken-compatible vocabulary and merge order).
+type BPE struct {
+	name    string
+	ranks   map[string]int
+	pattern *regexp.Regexp
+
+	mu    sync.Mutex
+	cache map[string]int
+}
+
+// LoadBPE reads a .tiktoken vocabulary ("<base64 token> <rank>" per line) for a known encoding.
+func LoadBPE(name, path string) (*BPE, error) {
+	pat, ok := patterns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ranks, err := parseRanks(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewBPE(name, ranks, pat)
+}
+
+// NewBPE builds an encoding from merge ranks and an anchored pre-tokenizer pattern.
+func NewBPE(name string, ranks map[string]int, pattern string) (*BPE, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("compile pattern: %w", err)
+	}
+	return &BPE{name: name, ranks: ranks, pattern: re, cache: make(map[string]int)}, nil
+}
+
+func parseRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int, 200_000)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<token> <rank>\"", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) < 256 {
+		return nil, fmt.Errorf("vocabulary has %d tokens, want at least the 256 single bytes", len(ranks))
+	}
+	return ranks, nil
+}
+
+func (b *BPE) Name() string { return b.name }
+
+// Count returns the number of tokens text encodes to (special tokens are treated as plain text).
+func (b *BPE) Count(text string) int {
+	total := 0
+	for _, piece := range b.pieces(text) {
+		total += b.countPiece(piece)
+	}
+	return total
+}
+
+// pieces splits text with the pre-tokenizer; BPE merges never cross piece boundaries.
+func (b *BPE) pieces(text string) []string {
+	var out []string
+	for pos := 0; pos < len(text); {
+		loc := b.pattern.FindStringIndex(text[pos:])
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		end := pos + size
+		if loc != nil && loc[1] > 0 {
+			end = pos + loc[1]
+		}
+		// `\s+(?!\S)`: whitespace followed by a word gives its last character to that word (" foo").
+		if end < len(text) && utf8.RuneCountInString(text[pos:end]) > 1 && isSpaceRun(text[pos:end]) {
+			if next, _ := utf8.DecodeRuneInString(text[end:]); !unicode.IsSpace(next) {
+				_, size := utf8.DecodeLastRuneInString(text[pos:end])
+				end -= size
+			}
+		}
+		out = append(out, text[pos:end])
+		pos = end
+	}
+	return out
+}
+
+// isSpaceRun reports whether s is whitespace not ending in a line break (a match of the `\s+` alternatives).
+func isSpaceRun(s string) bool {
+	last, _ := utf8.DecodeLastRuneInString(s)
+	if last == '\n' || last == '\r' {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BPE) countPiece(piece string) int {
+	if _, ok := b.ranks[piece]; ok {
+		return 1
+	}
+	b.mu.Lock()
+	n, ok := b.cache[piece]
+	b.mu.Unlock()
+	if ok {
+		return n
+	}
+	n = b.mergeCount([]byte(piece))
+	b.mu.Lock()
+	if len(b.cache) >= maxCachedPieces {
+		b.cache = make(map[string]int)
+	}
+	b.cache[piece] = n
+	b.mu.Unlock()
+	return n
+}
+
+// mergeCount applies byte-pair merges in rank order (lowest first) and returns the resulting token count.
+func (b *BPE) mergeCount(piece []byte) int {
+	// parts[i] is the start offset of the i-th current token; the last entry is len(piece).
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	for len(parts) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+2 < len(parts); i++ {
+			if rank, ok := b.ranks[string(piece[parts[i]:parts[i+2]])]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return len(parts) - 1
+}
@@ -0,0 +1,95 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeVocab writes a .tiktoken file with the 256 single bytes followed by merges (in rank order).
+func writeVocab(t *testing.T, dir, name string, merges ...string) {
+	t.Helper()
+	var sb strings.Builder
+	rank := 0
+	for b := 0; b < 256; b++ {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), rank)
+		rank++
+	}
+	for _, m := range merges {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(m)), rank)
+		rank++
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".tiktoken"), []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPiecesCl100k(t *testing.T) {
+	dir := t.TempDir()
+	writeVocab(t, dir, Cl100kBase)
+	enc, err := LoadBPE(Cl100kBase, filepath.Join(dir, Cl100kBase+".tiktoken"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]string{
+		"hello  world 123":  {"hello", " ", " world", " ", "123"},
+		"I'm here.\n\nnext": {"I", "'m", " here", ".\n\n", "next"},
+		"x = 12345;":        {"x", " =", " ", "123", "45", ";"},
+		"trailing   ":       {"trailing", "   "},
+	}
+	for in, want := range cases {
+		if got := enc.pieces(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("pieces(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCountMergesByRank(t *testing.T) {
+	dir := t.TempDir()
+	// "ab" merges before "bc", so "abc" becomes [ab][c]; "abcd" then merges "cd" too.
+	writeVocab(t, dir, Cl100kBase, "ab", "bc", "cd", "abcd")
+	enc, err := LoadBPE(Cl100kBase, filepath.Join(dir, Cl100kBase+".tiktoken"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]int{
+		"":          0,
+		"ab":        1,
+		"abc":       2,
+		"abcd":      1,
+		"abcd abcd": 1 + 2, // " abcd" is one piece: [ ][abcd], there is no merge for " a"
+		"你好":        6,     // 2 CJK chars × 3 UTF-8 bytes, no merges
+	}
+	for in, want := range cases {
+		if got := enc.Count(in); got != want {
+			t.Errorf("Count(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestForModel(t *testing.T) {
+	old := Dir
+	defer func() { Dir = old }()
+	Dir = t.TempDir()
+	writeVocab(t, Dir, O200kBase)
+
+	if got := ForModel("", "openai/gpt-4o-mini").Name(); got != O200kBase {
+		t.Errorf("auto gpt-4o-mini = %s, want %s", got, O200kBase)
+	}
+	if got := ForModel("auto", "claude-sonnet-4-5").Name(); got != "heuristic" {
+		t.Errorf("auto claude = %s, want heuristic", got)
+	}
+	// cl100k_base has no file in Dir, so it falls back to the heuristic.
+	if got := ForModel("", "gpt-4-turbo").Name(); got != "heuristic" {
+		t.Errorf("missing vocabulary = %s, want heuristic", got)
+	}
+	if got := ForModel("heuristic", "gpt-4o").Name(); got != "heuristic" {
+		t.Errorf("explicit heuristic = %s", got)
+	}
+}
@@ -0,0 +1,131 @@
+package tokenizer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// vocabSources are the vocabularies published by OpenAI for tiktoken, with their SHA-256.
+var vocabSources = map[string]struct{ url, sha256 string }{
+	Cl100kBase: {"https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken", "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7"},
+	O200kBase:  {"https://openaipublic.blob.core.windows.net/encodings/o200k_base.tiktoken", "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d"},
+}
+
+// AutoFetch makes Encoding download a vocabulary missing from Dir in the background; the heuristic is
+// used until it is in place. Off by default (no network access unless configured); set once at startup.
+var AutoFetch bool
+
+const fetchTimeout = 2 * time.Minute
+
+// A failed background download is retried after fetchRetryDelay, doubling up to fetchMaxRetryDelay.
+var (
+	fetchRetryDelay    = time.Minute
+	fetchMaxRetryDelay = time.Hour
+)
+
+type fetchState struct {
+	running  bool
+	failures int       // consecutive failed downloads
+	retryAt  time.Time // no new download before this
+}
+
+// Encodings returns the encodings that can be fetched.
+func Encodings() []string {
+	return []string{Cl100kBase, O200kBase}
+}
+
+// Fetch downloads an encoding's vocabulary into Dir as <encoding>.tiktoken, verifying its checksum.
+// An existing file is kept.
+func Fetch(ctx context.Context, name string) error {
+	src, ok := vocabSources[name]
+	if !ok {
+		return fmt.Errorf("unknown encoding %q", name)
+	}
+	path := filepath.Join(Dir, name+".tiktoken")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", src.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch %s: %s", name, resp.Status)
+	}
+
+	if err := os.MkdirAll(Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(Dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("fetch %s: %w", name, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != src.sha256 {
+		return fmt.Errorf("fetch %s: checksum mismatch (got %s)", name, sum)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fetchPending reports whether a background download of the vocabulary is running or waiting to be
+// retried. Called with encMu held.
+func fetchPending(name string) bool {
+	st := fetches[name]
+	return st != nil && (st.running || time.Now().Before(st.retryAt))
+}
+
+// fetchInBackground downloads a missing vocabulary; when done, the next Encoding call loads it.
+// On failure the next Encoding call after the backoff starts a new download. Called with encMu held.
+func fetchInBackground(name string) {
+	st := fetches[name]
+	if st == nil {
+		st = &fetchState{}
+		fetches[name] = st
+	}
+	if st.running {
+		return
+	}
+	st.running = true
+	slog.Info("downloading tokenizer vocabulary", "encoding", name, "dir", Dir)
+	go func() {
+		err := Fetch(context.Background(), name)
+		encMu.Lock()
+		defer encMu.Unlock()
+		st.running = false
+		if err != nil {
+			st.failures++
+			delay := min(fetchRetryDelay<<(st.failures-1), fetchMaxRetryDelay)
+			if delay <= 0 { // shift overflow
+				delay = fetchMaxRetryDelay
+			}
+			st.retryAt = time.Now().Add(delay)
+			slog.Warn("tokenizer vocabulary download failed, using heuristic token estimate", "encoding", name, "error", err, "retryIn", delay)
+			return
+		}
+		slog.Info("tokenizer vocabulary downloaded", "encoding", name)
+		delete(fetches, name)
+	}()
+}
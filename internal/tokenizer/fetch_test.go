@@ -0,0 +1,101 @@
+package tokenizer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	src := t.TempDir()
+	writeVocab(t, src, Cl100kBase, "ab")
+	data, err := os.ReadFile(filepath.Join(src, Cl100kBase+".tiktoken"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vocab := string(data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(vocab))
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256([]byte(vocab))
+	saved, savedDir := vocabSources[Cl100kBase], Dir
+	t.Cleanup(func() { vocabSources[Cl100kBase], Dir = saved, savedDir })
+	Dir = t.TempDir()
+	path := filepath.Join(Dir, Cl100kBase+".tiktoken")
+
+	// A checksum mismatch leaves nothing behind
+	vocabSources[Cl100kBase] = struct{ url, sha256 string }{srv.URL, strings.Repeat("0", 64)}
+	if err := Fetch(context.Background(), Cl100kBase); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v", err)
+	}
+	if entries, _ := os.ReadDir(Dir); len(entries) != 0 {
+		t.Fatalf("left %v", entries)
+	}
+
+	vocabSources[Cl100kBase] = struct{ url, sha256 string }{srv.URL, hex.EncodeToString(sum[:])}
+	if err := Fetch(context.Background(), Cl100kBase); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != vocab {
+		t.Errorf("vocabulary = %q", data)
+	}
+	if _, err := LoadBPE(Cl100kBase, path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFetchInBackgroundRetries(t *testing.T) {
+	src := t.TempDir()
+	writeVocab(t, src, Cl100kBase, "ab")
+	data, err := os.ReadFile(filepath.Join(src, Cl100kBase+".tiktoken"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(data)
+	reset := func() {
+		encMu.Lock()
+		delete(encodings, Cl100kBase)
+		delete(encFailed, Cl100kBase)
+		delete(fetches, Cl100kBase)
+		encMu.Unlock()
+	}
+	reset() // earlier tests may have cached cl100k_base as missing
+	saved, savedDir, savedAuto, savedDelay := vocabSources[Cl100kBase], Dir, AutoFetch, fetchRetryDelay
+	t.Cleanup(func() {
+		vocabSources[Cl100kBase], Dir, AutoFetch, fetchRetryDelay = saved, savedDir, savedAuto, savedDelay
+		reset()
+	})
+	vocabSources[Cl100kBase] = struct{ url, sha256 string }{srv.URL, hex.EncodeToString(sum[:])}
+	Dir, AutoFetch, fetchRetryDelay = t.TempDir(), true, 50*time.Millisecond
+
+	// The first download fails; the heuristic is used until the backoff has passed, then a new
+	// download is started and the vocabulary loaded.
+	deadline := time.Now().Add(5 * time.Second)
+	for Encoding(Cl100kBase) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("vocabulary not loaded after %d downloads", calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("downloads = %d, want 2", got)
+	}
+}
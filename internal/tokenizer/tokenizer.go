@@ -0,0 +1,170 @@
+// Package tokenizer counts tokens for context-window management (compaction, budgets).
+// Counts only need to be close: the compactor calibrates them against the provider's reported usage.
+package tokenizer
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+// Tokenizer counts the tokens a model would see for a piece of text.
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+}
+
+const (
+	messageOverhead = 4    // role + formatting tokens per message
+	toolOverhead    = 8    // framing per tool definition
+	imageTokens     = 1000 // rough cost of one image; providers bill 85–1600 depending on size
+	pdfMinTokens    = 2000 // floor for a PDF of unknown size (URL not fetched yet)
+)
+
+// CountMessages counts the tokens of a conversation: content, tool calls and per-message overhead.
+func CountMessages(t Tokenizer, messages []llm.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += t.Count(msg.Content) + messageOverhead
+		total += len(msg.Images) * imageTokens
+		for _, doc := range msg.Documents {
+			total += t.Count(doc.Text) + documentTokens(doc)
+		}
+		for _, tc := range msg.ToolCalls {
+			total += t.Count(tc.Name) + t.Count(tc.Arguments)
+		}
+	}
+	return total
+}
+
+// documentTokens estimates a binary document (PDF) from its size: providers bill each page as text plus
+// an image, roughly 1500–3000 tokens, and a typical page is 50–100 KB.
+func documentTokens(doc llm.DocumentData) int {
+	if !doc.IsPDF() {
+		return 0
+	}
+	n := len(doc.Base64) * 3 / 4 / 40
+	if n < pdfMinTokens {
+		n = pdfMinTokens
+	}
+	return n
+}
+
+// CountRequest counts everything sent in one LLM call: system prompt, tool definitions and messages.
+func CountRequest(t Tokenizer, system string, tools []llm.ToolDef, messages []llm.Message) int {
+	total := t.Count(system)
+	for _, td := range tools {
+		total += t.Count(td.Name) + t.Count(td.Description) + t.Count(string(td.Parameters)) + toolOverhead
+	}
+	return total + CountMessages(t, messages)
+}
+
+// Heuristic estimates tokens from character classes: ~4 ASCII chars or ~1.5 CJK chars per token.
+// It needs no vocabulary and is the fallback when no BPE encoding applies.
+type Heuristic struct{}
+
+func (Heuristic) Name() string { return "heuristic" }
+
+func (Heuristic) Count(text string) int {
+	if len(text) == 0 {
+		return 0
+	}
+
+	chars := 0
+	cjk := 0
+	for _, r := range text {
+		chars++
+		if r >= 0x4E00 && r <= 0x9FFF {
+			cjk++
+		}
+	}
+
+	// CJK characters are roughly 1 token per 1-2 chars
+	// ASCII is roughly 1 token per 4 chars
+	ascii := chars - cjk
+	return (ascii / 4) + (cjk * 2 / 3) + 1
+}
+
+// BPE encodings that can be loaded from Dir.
+const (
+	Cl100kBase = "cl100k_base"
+	O200kBase  = "o200k_base"
+)
+
+// Dir holds the BPE vocabularies as <encoding>.tiktoken (the format published by OpenAI's tiktoken).
+// Set once at startup; encodings whose file is missing fall back to Heuristic until fetched (see AutoFetch).
+var Dir string
+
+var (
+	encMu     sync.Mutex
+	encodings = map[string]*BPE{}
+	encFailed = map[string]bool{}
+	fetches   = map[string]*fetchState{} // background downloads of missing vocabularies (see AutoFetch)
+)
+
+// ForModel returns the tokenizer for a provider's tokenizer setting and a model name.
+// name is "auto" (or empty: pick by model), "heuristic", or a BPE encoding such as "o200k_base".
+func ForModel(name, model string) Tokenizer {
+	switch name {
+	case "", "auto":
+		name = EncodingForModel(model)
+	case "heuristic":
+		return Heuristic{}
+	}
+	if name == "" {
+		return Heuristic{}
+	}
+	if enc := Encoding(name); enc != nil {
+		return enc
+	}
+	return Heuristic{}
+}
+
+// EncodingForModel returns the BPE encoding OpenAI models use, or "" for other models
+// (Claude, Gemini, Qwen, ... have their own vocabularies; the heuristic is used for them).
+func EncodingForModel(model string) string {
+	m := strings.ToLower(model)
+	if i := strings.LastIndex(m, "/"); i >= 0 {
+		m = m[i+1:] // "openai/gpt-4o" on routers
+	}
+	for _, prefix := range []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "gpt-oss", "o1", "o3", "o4"} {
+		if strings.HasPrefix(m, prefix) {
+			return O200kBase
+		}
+	}
+	for _, prefix := range []string{"gpt-4", "gpt-3.5", "gpt-35", "text-embedding-3", "text-embedding-ada"} {
+		if strings.HasPrefix(m, prefix) {
+			return Cl100kBase
+		}
+	}
+	return ""
+}
+
+// Encoding loads (once) and returns a BPE encoding from Dir; nil if it is unknown or its file cannot be read.
+func Encoding(name string) *BPE {
+	encMu.Lock()
+	defer encMu.Unlock()
+	if enc, ok := encodings[name]; ok {
+		return enc
+	}
+	if encFailed[name] || fetchPending(name) {
+		return nil
+	}
+	enc, err := LoadBPE(name, filepath.Join(Dir, name+".tiktoken"))
+	if err != nil {
+		if _, ok := vocabSources[name]; ok && AutoFetch && errors.Is(err, fs.ErrNotExist) {
+			fetchInBackground(name)
+			return nil
+		}
+		encFailed[name] = true
+		slog.Warn("tokenizer unavailable, using heuristic token estimate", "encoding", name, "error", err)
+		return nil
+	}
+	encodings[name] = enc
+	return enc
+}
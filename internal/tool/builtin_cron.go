@@ -96,6 +96,7 @@ type CronAddTool struct{ store *cronStore }
 
 func (t *CronAddTool) Name() string        { return "cron_add" }
 func (t *CronAddTool) Description() string { return "Add a scheduled cron job. Schedule is a cron expression (e.g. '0 9 * * *' for daily at 9am). ID is optional; if omitted a unique id is generated." }
+func (t *CronAddTool) Serial() bool { return true }
 func (t *CronAddTool) Parameters() json.RawMessage {
 	return json.RawMessage(`{
 		"type": "object",
@@ -152,6 +153,7 @@ type CronRemoveTool struct{ store *cronStore }
 
 func (t *CronRemoveTool) Name() string        { return "cron_remove" }
 func (t *CronRemoveTool) Description() string { return "Remove a cron job by id. Use cron_list to see ids." }
+func (t *CronRemoveTool) Serial() bool { return true }
 func (t *CronRemoveTool) Parameters() json.RawMessage {
 	return json.RawMessage(`{
 		"type": "object",
@@ -21,6 +21,7 @@ type ExecTool struct {
 
 func (t *ExecTool) Name() string        { return "exec" }
 func (t *ExecTool) Description() string { return "Execute a shell command and return its output" }
+func (t *ExecTool) Serial() bool        { return true }
 func (t *ExecTool) Parameters() json.RawMessage {
 	return json.RawMessage(`{
 		"type": "object",
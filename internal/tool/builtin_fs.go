@@ -50,6 +50,7 @@ type WriteFileTool struct{ WorkDir string }
 
 func (t *WriteFileTool) Name() string        { return "write_file" }
 func (t *WriteFileTool) Description() string { return "Create or overwrite a file with content" }
+func (t *WriteFileTool) Serial() bool        { return true }
 func (t *WriteFileTool) Parameters() json.RawMessage {
 	return json.RawMessage(`{
 		"type": "object",
@@ -89,6 +90,7 @@ type EditFileTool struct{ WorkDir string }
 
 func (t *EditFileTool) Name() string        { return "edit_file" }
 func (t *EditFileTool) Description() string { return "Replace exact string occurrences in a file" }
+func (t *EditFileTool) Serial() bool        { return true }
 func (t *EditFileTool) Parameters() json.RawMessage {
 	return json.RawMessage(`{
 		"type": "object",
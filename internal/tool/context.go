@@ -6,8 +6,9 @@ type contextKey string
 
 const runInfoKey contextKey = "runInfo"
 
-// RunInfo holds current run context (session, agent, model, workspace) for tools that need it.
+// RunInfo holds current run context (run, session, agent, model, workspace) for tools that need it.
 type RunInfo struct {
+	RunID      string
 	SessionKey string
 	AgentID    string
 	Model      string
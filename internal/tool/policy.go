@@ -0,0 +1,120 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lhdbsbz/aido/internal/llm"
+)
+
+const policyKey contextKey = "policy"
+
+// Groups are the named sets of builtin tools usable in allow/deny lists as "group:<name>".
+// "group:mcp" stands for every MCP tool and "group:mcp:<server>" for one server's tools.
+var Groups = map[string][]string{
+	"fs":      {"read_file", "write_file", "edit_file", "list_dir", "grep", "find_files"},
+	"runtime": {"exec"},
+	"web":     {"web_fetch"},
+	"memory":  {"memory_get", "memory_search"},
+	"session": {"session_status"},
+	"cron":    {"cron_list", "cron_add", "cron_remove"},
+}
+
+// Policy decides which tools an agent may use. Patterns are globs (path.Match) on tool names, which
+// are builtin names like "exec" or "server:tool" for MCP tools, or group references (see Groups).
+// A tool is allowed if it matches an allow pattern (an empty allow list allows every tool) and no
+// deny pattern: deny overrides allow. The zero Policy allows everything.
+type Policy struct {
+	allow, deny []string // expanded glob patterns
+}
+
+// NewPolicy compiles allow and deny lists; it fails on malformed globs and unknown groups.
+func NewPolicy(allow, deny []string) (Policy, error) {
+	var p Policy
+	var err error
+	if p.allow, err = expandPatterns(allow); err != nil {
+		return Policy{}, fmt.Errorf("tools.allow: %w", err)
+	}
+	if p.deny, err = expandPatterns(deny); err != nil {
+		return Policy{}, fmt.Errorf("tools.deny: %w", err)
+	}
+	return p, nil
+}
+
+func expandPatterns(patterns []string) ([]string, error) {
+	var out []string
+	for _, pat := range patterns {
+		pat = strings.TrimSpace(pat)
+		group, isGroup := strings.CutPrefix(pat, "group:")
+		switch {
+		case pat == "":
+			continue
+		case !isGroup:
+			if _, err := path.Match(pat, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pat, err)
+			}
+			out = append(out, pat)
+		case group == "mcp":
+			out = append(out, "*:*")
+		case strings.HasPrefix(group, "mcp:"):
+			out = append(out, strings.TrimPrefix(group, "mcp:")+":*")
+		default:
+			names, ok := Groups[group]
+			if !ok {
+				return nil, fmt.Errorf("unknown tool group %q (known: %s, mcp, mcp:<server>)", pat, strings.Join(groupNames(), ", "))
+			}
+			out = append(out, names...)
+		}
+	}
+	return out, nil
+}
+
+func groupNames() []string {
+	names := make([]string, 0, len(Groups))
+	for name := range Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Allows reports whether the named tool may be used.
+func (p Policy) Allows(name string) bool {
+	if len(p.allow) > 0 && !matchAny(p.allow, name) {
+		return false
+	}
+	return !matchAny(p.deny, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pat string) bool {
+		ok, _ := path.Match(pat, name)
+		return ok
+	})
+}
+
+// Filter returns the definitions of the allowed tools, in order.
+func (p Policy) Filter(defs []llm.ToolDef) []llm.ToolDef {
+	out := make([]llm.ToolDef, 0, len(defs))
+	for _, d := range defs {
+		if p.Allows(d.Name) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// WithPolicy attaches the agent's tool policy to ctx; Registry.Execute rejects calls it denies.
+func WithPolicy(ctx context.Context, p Policy) context.Context {
+	return context.WithValue(ctx, policyKey, p)
+}
+
+// PolicyFromContext returns the tool policy of ctx; the zero Policy (everything allowed) if none.
+func PolicyFromContext(ctx context.Context) Policy {
+	p, _ := ctx.Value(policyKey).(Policy)
+	return p
+}
@@ -0,0 +1,40 @@
+package tool
+
+import "testing"
+
+func TestPolicy(t *testing.T) {
+	cases := []struct {
+		allow, deny []string
+		allowed     []string
+		denied      []string
+	}{
+		{nil, nil, []string{"exec", "github:create_issue"}, nil},
+		{[]string{"group:fs", "web_*"}, nil, []string{"read_file", "edit_file", "web_fetch"}, []string{"exec", "github:list_issues"}},
+		{nil, []string{"group:runtime", "group:mcp:github"}, []string{"read_file", "jira:search"}, []string{"exec", "github:create_issue"}},
+		{[]string{"group:mcp"}, []string{"*:delete_*"}, []string{"github:list_issues"}, []string{"read_file", "github:delete_repo"}},
+		{[]string{"*"}, []string{"exec"}, []string{"cron_add", "github:x"}, []string{"exec"}}, // deny overrides allow
+	}
+	for _, tc := range cases {
+		p, err := NewPolicy(tc.allow, tc.deny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range tc.allowed {
+			if !p.Allows(name) {
+				t.Errorf("allow %v deny %v: %s denied", tc.allow, tc.deny, name)
+			}
+		}
+		for _, name := range tc.denied {
+			if p.Allows(name) {
+				t.Errorf("allow %v deny %v: %s allowed", tc.allow, tc.deny, name)
+			}
+		}
+	}
+
+	if _, err := NewPolicy([]string{"group:nope"}, nil); err == nil {
+		t.Error("unknown group accepted")
+	}
+	if _, err := NewPolicy(nil, []string{"[exec"}); err == nil {
+		t.Error("malformed pattern accepted")
+	}
+}
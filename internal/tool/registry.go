@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -18,6 +19,12 @@ type Tool interface {
 	Execute(ctx context.Context, params json.RawMessage) (string, error)
 }
 
+// SerialTool is implemented by tools whose calls must not overlap with other serial calls, such as
+// tools that write files or run commands. The agent runs such calls one at a time, in call order.
+type SerialTool interface {
+	Serial() bool
+}
+
 // Registry manages all available tools (builtin + MCP).
 type Registry struct {
 	mu    sync.RWMutex
@@ -62,12 +69,26 @@ func (r *Registry) Get(name string) (Tool, bool) {
 	return t, ok
 }
 
-// Execute runs a tool by name with the given parameters.
+// IsSerial reports whether calls of the named tool must run one at a time (see SerialTool).
+func (r *Registry) IsSerial(name string) bool {
+	t, ok := r.Get(name)
+	if !ok {
+		return false
+	}
+	s, ok := t.(SerialTool)
+	return ok && s.Serial()
+}
+
+// Execute runs a tool by name with the given parameters. Tools denied by the Policy of ctx are rejected,
+// as the model may call a tool it was never offered.
 func (r *Registry) Execute(ctx context.Context, name string, paramsJSON string) (string, error) {
 	t, ok := r.Get(name)
 	if !ok {
 		return "", fmt.Errorf("unknown tool: %s", name)
 	}
+	if !PolicyFromContext(ctx).Allows(name) {
+		return "", fmt.Errorf("tool %s is not allowed for this agent", name)
+	}
 	result, err := t.Execute(ctx, json.RawMessage(paramsJSON))
 	if err != nil {
 		return fmt.Sprintf(`{"error": %q}`, err.Error()), nil
@@ -75,13 +96,15 @@ func (r *Registry) Execute(ctx context.Context, name string, paramsJSON string)
 	return result, nil
 }
 
-// ListToolDefs returns LLM-compatible tool definitions for all registered tools.
+// ListToolDefs returns LLM-compatible tool definitions for all registered tools, sorted by name.
+// The order must be stable across calls: tools are part of the prompt prefix that providers cache.
 func (r *Registry) ListToolDefs() []llm.ToolDef {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	defs := make([]llm.ToolDef, 0, len(r.tools))
-	for _, t := range r.tools {
+	for _, name := range r.sortedNames() {
+		t := r.tools[name]
 		defs = append(defs, llm.ToolDef{
 			Name:        t.Name(),
 			Description: t.Description(),
@@ -91,13 +114,19 @@ func (r *Registry) ListToolDefs() []llm.ToolDef {
 	return defs
 }
 
-// ListNames returns all registered tool names.
+// ListNames returns all registered tool names, sorted.
 func (r *Registry) ListNames() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
+	return r.sortedNames()
+}
+
+// sortedNames returns tool names in lexical order. Caller must hold r.mu.
+func (r *Registry) sortedNames() []string {
 	names := make([]string, 0, len(r.tools))
 	for name := range r.tools {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
@@ -0,0 +1,43 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+type namedTool string
+
+func (t namedTool) Name() string                { return string(t) }
+func (t namedTool) Description() string         { return "Test tool." }
+func (t namedTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (t namedTool) Execute(context.Context, json.RawMessage) (string, error) {
+	return string(t), nil
+}
+
+func TestListToolDefsOrder(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"web_fetch", "github:list_issues", "exec", "read_file", "cron_add", "github:create_issue"} {
+		r.Register(namedTool(name))
+	}
+	want := []string{"cron_add", "exec", "github:create_issue", "github:list_issues", "read_file", "web_fetch"}
+	// The same order on every call, whatever the map iteration order
+	for i := 0; i < 20; i++ {
+		var names []string
+		for _, d := range r.ListToolDefs() {
+			names = append(names, d.Name)
+		}
+		if !slices.Equal(names, want) {
+			t.Fatalf("call %d: tools = %v, want %v", i, names, want)
+		}
+	}
+
+	// Re-registering tools (e.g. an MCP server reconnecting) keeps the order
+	r.UnregisterByPrefix("github:")
+	r.Register(namedTool("github:list_issues"))
+	r.Register(namedTool("github:create_issue"))
+	if got := r.ListNames(); !slices.Equal(got, want) {
+		t.Errorf("after re-registering: %v", got)
+	}
+}